@@ -0,0 +1,421 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/acker1019/fedora-phoenix/internal/config"
+	"github.com/acker1019/fedora-phoenix/internal/ops"
+	"github.com/acker1019/fedora-phoenix/internal/secret"
+	"github.com/acker1019/fedora-phoenix/internal/session"
+	"github.com/acker1019/fedora-phoenix/internal/utils"
+)
+
+// infrastructureActs builds the Block II Acts from the blueprint, an
+// UnlockLuks and MountDevice pair per volume (plus PersistLuks with persist: true),
+// then encrypted swap once the volumes are mounted. Secrets may be nil (dry-run,
+// keyfiles or prompt), in which case UnlockLuks has no password; passwords
+// were checked by checkLuksSecrets otherwise.
+func infrastructureActs(sess *session.Session) []ops.Act {
+	var acts []ops.Act
+
+	// Store infrastructure info in session
+	sess.LuksVolumes = nil
+	for _, vol := range sess.Blueprint.Infrastructure.Luks {
+		sess.LuksVolumes = append(sess.LuksVolumes, session.LuksVolume{
+			MapperName: vol.MapperName,
+			MountPoint: vol.MountPoint,
+			Optional:   vol.Optional,
+		})
+
+		unlock := &ops.UnlockLuks{
+			Device:     vol.Device,
+			MapperName: vol.MapperName,
+			LuksUUID:   vol.LuksUUID,
+			Keyfile:    keyfile(vol),
+			Optional:   vol.Optional,
+		}
+		if sess.Secrets != nil {
+			unlock.Password, _ = luksPassword(sess, vol)
+		}
+		if sess.Prompt {
+			unlock.Prompt = promptLuksPassword
+		}
+
+		acts = append(acts,
+			unlock,
+			&ops.MountDevice{
+				MapperName: vol.MapperName,
+				MountPoint: vol.MountPoint,
+				FSType:     vol.FSType,
+				Options:    vol.MountOptions,
+				Subvolume:  vol.Subvolume,
+				Fsck:       vol.Fsck,
+				Device:     vol.Device,
+				Optional:   vol.Optional,
+			},
+		)
+		if vol.Persist {
+			acts = append(acts, &ops.PersistLuks{
+				Device:        vol.Device,
+				MapperName:    vol.MapperName,
+				MountPoint:    vol.MountPoint,
+				FSType:        vol.FSType,
+				MountOptions:  ops.MountOptions(vol.MountOptions, vol.Subvolume),
+				DeviceTimeout: vol.Timeout(),
+				CrypttabPath:  ops.CrypttabPath,
+				FstabPath:     ops.FstabPath,
+				Optional:      vol.Optional,
+			})
+		}
+	}
+
+	return append(acts, swapActs(sess)...)
+}
+
+// swapActs builds the swap Acts. zram is disabled last, so that the
+// machine is never left without swap in between.
+func swapActs(sess *session.Session) []ops.Act {
+	swap := sess.Blueprint.Infrastructure.Swap
+	var acts []ops.Act
+
+	switch swap.Type {
+	case config.SwapPartition:
+		acts = append(acts, &ops.EncryptedSwap{
+			Device:       swap.Device,
+			MapperName:   swap.MapperName,
+			CrypttabPath: ops.CrypttabPath,
+			FstabPath:    ops.FstabPath,
+		})
+	case config.SwapFile:
+		vol, _ := sess.Blueprint.Infrastructure.Luks.Find(swap.Volume)
+		acts = append(acts, &ops.SwapFile{
+			Path:        filepath.Join(vol.MountPoint, swap.Path),
+			Size:        swap.Size,
+			VolumeMount: vol.MountPoint,
+			Optional:    vol.Optional,
+			FstabPath:   ops.FstabPath,
+		})
+	}
+
+	if swap.DisableZram {
+		acts = append(acts, &ops.DisableZram{ConfigPath: ops.ZramGeneratorPath})
+	}
+	return acts
+}
+
+// keyfile converts a volume's keyfile section, nil when it has none.
+func keyfile(vol config.LuksConfig) *ops.Keyfile {
+	if vol.Keyfile.Label == "" {
+		return nil
+	}
+	return &ops.Keyfile{Label: vol.Keyfile.Label, Path: vol.Keyfile.Path, Offset: vol.Keyfile.Offset, Size: vol.Keyfile.Size}
+}
+
+// checkLuksSecrets ensures every volume can be unlocked before anything is:
+// it needs its password, unless the medium of its keyfile is attached or
+// the password can be asked for on the terminal.
+// Secrets may be nil when no secrets file was given.
+func checkLuksSecrets(sess *session.Session) error {
+	for _, vol := range sess.Blueprint.Infrastructure.Luks {
+		_, err := luksPassword(sess, vol)
+		if err == nil || sess.Prompt {
+			continue
+		}
+		if k := keyfile(vol); k != nil {
+			if k.Present() {
+				continue
+			}
+			return fmt.Errorf("volume %s: key medium label:%s is not present and there is no password (%w)", vol.MapperName, k.Label, err)
+		}
+		return fmt.Errorf("volume %s: %w", vol.MapperName, err)
+	}
+	return nil
+}
+
+// luksPassword returns a volume's password from the secrets, given by
+// its password reference or its secret_key.
+func luksPassword(sess *session.Session, vol config.LuksConfig) (*secret.Value, error) {
+	if sess.Secrets == nil {
+		return nil, fmt.Errorf("no secrets file")
+	}
+	if vol.Password != "" {
+		return sess.Secrets.Resolve(vol.Password)
+	}
+	return sess.Secrets.LuksPasswordFor(vol.SecretKey)
+}
+
+// secretRefs returns the blueprint's ${secret:name} references that must
+// resolve before any Act runs. With a vault, only those of Block II are:
+// the others may be in the vault, which is checked once it is loaded.
+func secretRefs(bp *config.Blueprint) []config.SecretRef {
+	refs := bp.SecretRefs()
+	if bp.Infrastructure.Vault.Path == "" {
+		return refs
+	}
+	var early []config.SecretRef
+	for _, ref := range refs {
+		if strings.HasPrefix(ref.Field, "infrastructure.") {
+			early = append(early, ref)
+		}
+	}
+	return early
+}
+
+// resolveSecretRefs ensures refs resolve before the Acts using them run.
+// An unresolved LUKS password is left to the unlock prompt or keyfile,
+// which checkLuksSecrets has vouched for. With a terminal, any other
+// missing secret is asked for, once per name, and kept for the run.
+func resolveSecretRefs(sess *session.Session, refs []config.SecretRef) error {
+	var kept []config.SecretRef
+	asked := make(map[string]bool)
+	for _, ref := range refs {
+		_, ok := sess.Secrets.Lookup(ref.Name)
+		switch {
+		case ok:
+		case strings.HasPrefix(ref.Field, "infrastructure.luks["):
+			continue
+		case sess.Prompt && !asked[ref.Name]:
+			asked[ref.Name] = true
+			value, err := utils.ReadSecret(fmt.Sprintf("🔑 Secret %s (%s): ", ref.Name, ref.Field))
+			if err != nil {
+				return fmt.Errorf("%s: %w", ref.Field, err)
+			}
+			if value.Empty() {
+				break // Reported as not defined below
+			}
+			if sess.Secrets == nil {
+				sess.Secrets = &config.Secrets{}
+			}
+			sess.Secrets.Add(ref.Name, value)
+		}
+		kept = append(kept, ref)
+	}
+	return sess.Secrets.CheckRefs(kept)
+}
+
+// expandSecrets resolves the ${secret:name} references of a blueprint
+// value. They were checked before any Act ran; without secrets (dry-run)
+// the references are kept.
+func expandSecrets(sess *session.Session, value string) string {
+	expanded, err := sess.Secrets.Expand(value)
+	if err != nil {
+		return value
+	}
+	return expanded
+}
+
+// promptLuksPassword asks for a volume's password on the terminal.
+func promptLuksPassword(mapperName string, attempt int) (*secret.Value, error) {
+	if attempt > 1 {
+		return utils.ReadSecret(fmt.Sprintf("🔑 LUKS password for %s (attempt %d/%d): ", mapperName, attempt, ops.MaxPasswordAttempts))
+	}
+	return utils.ReadSecret(fmt.Sprintf("🔑 LUKS password for %s: ", mapperName))
+}
+
+// keyfilesOnly reports whether every volume has a keyfile, so that
+// provision can run without a secrets file.
+func keyfilesOnly(bp *config.Blueprint) bool {
+	for _, vol := range bp.Infrastructure.Luks {
+		if vol.Keyfile.Label == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// markMountedVolumes records which volumes ended up mounted after Block II
+// (optional volumes may have been skipped).
+func markMountedVolumes(sess *session.Session) {
+	for i := range sess.LuksVolumes {
+		sess.LuksVolumes[i].Mounted = ops.IsMounted(sess.Runner, sess.LuksVolumes[i].MountPoint)
+	}
+}
+
+// loadVault merges the second-stage secrets from the vault on its LUKS
+// volume into the session, once Block II has mounted it. A volume that is
+// not mounted (optional, or filtered out with --only/--skip) or a vault
+// that does not exist yet only produces a warning.
+func loadVault(sess *session.Session) error {
+	vault := sess.Blueprint.Infrastructure.Vault
+	if vault.Path == "" {
+		return nil
+	}
+
+	vol, _ := sess.Blueprint.Infrastructure.Luks.Find(vault.Volume)
+	if !ops.IsMounted(sess.Runner, vol.MountPoint) {
+		fmt.Printf("⚠️  Vault volume %s is not mounted, continuing without the vault\n", vol.MapperName)
+		return nil
+	}
+	path := filepath.Join(vol.MountPoint, vault.Path)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		fmt.Printf("⚠️  No vault at %s yet, continuing without it\n", path)
+		return nil
+	}
+
+	secrets, err := config.LoadVault(path, sess.UID)
+	if err != nil {
+		return err
+	}
+	if sess.Secrets == nil {
+		sess.Secrets = &config.Secrets{}
+	}
+	for _, key := range sess.Secrets.Merge(secrets) {
+		fmt.Printf("⚠️  Vault overrides %s from the secrets file\n", key)
+	}
+	fmt.Printf("✓ Loaded vault %s\n", path)
+	return nil
+}
+
+// systemActs builds the Block III Acts from the blueprint. The package
+// Acts share one inventory, so that the installed packages are queried
+// once rather than per Act.
+func systemActs(sess *session.Session) []ops.Act {
+	bp := sess.Blueprint
+	inventory := ops.NewPackageInventory()
+	var acts []ops.Act
+
+	if len(bp.System.Packages) > 0 {
+		acts = append(acts, &ops.EnsurePackages{Packages: bp.System.Packages, Inventory: inventory})
+	}
+	if len(bp.System.PinnedPackages) > 0 {
+		acts = append(acts, &ops.EnsurePinnedPackages{Packages: bp.System.PinnedPackages, Inventory: inventory})
+	}
+	if len(bp.System.Services) > 0 {
+		acts = append(acts, &ops.EnsureServices{Services: bp.System.Services})
+	}
+	if bp.Identity.Shell != "" {
+		acts = append(acts, &ops.EnsureUserShell{Username: bp.Identity.Username, Shell: bp.Identity.Shell})
+	}
+
+	return acts
+}
+
+// userSpaceActs builds the Block IV Acts from the blueprint.
+func userSpaceActs(sess *session.Session) []ops.Act {
+	bp := sess.Blueprint
+	username := bp.Identity.Username
+	var acts []ops.Act
+
+	// Expand all paths in blueprint using the determined home directory
+	sess.StowSourceDir = utils.ExpandPath(bp.UserSpace.Stow.SourceDir, sess.UserHome)
+	sess.StowTargetDir = utils.ExpandPath(bp.UserSpace.Stow.TargetDir, sess.UserHome)
+	sess.SyncBaseDir = utils.ExpandPath(bp.UserSpace.Sync.BaseDir, sess.UserHome)
+
+	// Project persistent directories from the LUKS volumes first, so that
+	// everything below may live on them
+	acts = append(acts, projectionActs(sess)...)
+
+	// Extract Dotfiles Archive (if provided) into the sync repo, or the legacy stow dir
+	if sess.DotfilesArchive != "" {
+		destDir := sess.SyncBaseDir
+		if destDir == "" {
+			destDir = sess.StowSourceDir
+		}
+		acts = append(acts, &ops.ExtractTarball{Archive: sess.DotfilesArchive, DestDir: destDir, Username: username})
+	}
+
+	// Deploy Dotfiles by physical copy (ADR-0007)
+	if len(bp.UserSpace.Sync.Items) > 0 {
+		acts = append(acts, &ops.SyncDotfiles{BaseDir: sess.SyncBaseDir, Items: syncItems(sess), UID: sess.UID, GID: sess.GID})
+	}
+
+	// Deploy Dotfiles with Stow (deprecated)
+	if len(bp.UserSpace.Stow.Packages) > 0 {
+		acts = append(acts, &ops.RunStow{
+			SourceDir: sess.StowSourceDir,
+			TargetDir: sess.StowTargetDir,
+			Packages:  bp.UserSpace.Stow.Packages,
+			Username:  username,
+		})
+	}
+
+	// Clone Git Repositories
+	for _, repo := range bp.UserSpace.Repos {
+		acts = append(acts, gitClone(sess, repo, username))
+	}
+
+	// Write files and session environment, which may hold secrets
+	return append(acts, fileActs(sess)...)
+}
+
+// fileActs builds an EnsureFile per blueprint file, plus one for the
+// environment.d file. Modes were validated when the blueprint was loaded.
+func fileActs(sess *session.Session) []ops.Act {
+	var acts []ops.Act
+	for _, file := range sess.Blueprint.UserSpace.Files {
+		mode, _ := file.Mode()
+		acts = append(acts, &ops.EnsureFile{
+			Path:    utils.ExpandPath(file.Dest, sess.UserHome),
+			Content: expandSecrets(sess, file.Content),
+			Mode:    mode,
+			UID:     sess.UID,
+			GID:     sess.GID,
+		})
+	}
+
+	if env := sess.Blueprint.UserSpace.Environment; len(env) > 0 {
+		vars := make(map[string]string, len(env))
+		for key, value := range env {
+			vars[key] = expandSecrets(sess, value)
+		}
+		acts = append(acts, &ops.EnsureFile{
+			Path:    filepath.Join(sess.UserHome, ops.EnvironmentPath),
+			Content: ops.EnvironmentFile(vars),
+			Mode:    config.DefaultFileMode,
+			UID:     sess.UID,
+			GID:     sess.GID,
+		})
+	}
+	return acts
+}
+
+// gitClone builds the GitClone of a repository. The ${secret:name}
+// password of its URL becomes the clone's Token and is cut from the URL;
+// without secrets (dry-run) there is no Token.
+func gitClone(sess *session.Session, repo config.RepoConfig, username string) *ops.GitClone {
+	url, user, ref := config.SplitRepoURL(repo.URL)
+	clone := &ops.GitClone{URL: url, Dest: utils.ExpandPath(repo.Dest, sess.UserHome), Username: username, TokenUser: user}
+	if ref != "" {
+		clone.Token, _ = sess.Secrets.Resolve(ref)
+	}
+	return clone
+}
+
+// projectionActs builds a ProjectDirectory per blueprint projection.
+// Volumes were validated when the blueprint was loaded. Paths are cleaned:
+// a trailing slash on Dest would make the symlink calls follow the link.
+func projectionActs(sess *session.Session) []ops.Act {
+	var acts []ops.Act
+	for _, p := range sess.Blueprint.UserSpace.Projections {
+		vol, _ := sess.Blueprint.Infrastructure.Luks.Find(p.Volume)
+		acts = append(acts, &ops.ProjectDirectory{
+			Src:         filepath.Join(vol.MountPoint, p.Src),
+			Dest:        filepath.Clean(utils.ExpandPath(p.Dest, sess.UserHome)),
+			Method:      p.Method,
+			VolumeMount: filepath.Clean(vol.MountPoint),
+			Optional:    vol.Optional,
+			AllowShadow: p.AllowShadow,
+			UID:         sess.UID,
+			GID:         sess.GID,
+		})
+	}
+	return acts
+}
+
+// syncItems resolves the blueprint sync items against SyncBaseDir and UserHome.
+// Modes were validated when the blueprint was loaded.
+func syncItems(sess *session.Session) []ops.SyncItem {
+	items := make([]ops.SyncItem, 0, len(sess.Blueprint.UserSpace.Sync.Items))
+	for _, item := range sess.Blueprint.UserSpace.Sync.Items {
+		mode, _ := item.Mode()
+		items = append(items, ops.SyncItem{
+			Src:  filepath.Join(sess.SyncBaseDir, item.Src),
+			Dest: utils.ExpandPath(item.Dest, sess.UserHome),
+			Mode: mode,
+		})
+	}
+	return items
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/acker1019/fedora-phoenix/internal/logging"
+	"github.com/acker1019/fedora-phoenix/internal/ops"
+)
+
+// exitOnError renders err with "Reason" and "Hint" lines and exits with the
+// code of the Block it failed in (see ops.Block.ExitCode). It is the single
+// place where a failed run turns into a process exit; nothing panics.
+// Secrets are redacted, since commands and stderr may contain them.
+func exitOnError(err error) {
+	var e *ops.Error
+	if !errors.As(err, &e) {
+		fmt.Printf("❌ Error: %s\n", logging.Redact(err.Error()))
+		os.Exit(1)
+	}
+
+	if e.Act != "" {
+		fmt.Printf("❌ %s failed (%s)\n", e.Act, e.Block)
+	} else {
+		fmt.Printf("❌ %s failed\n", e.Block)
+	}
+	fmt.Printf("Reason: %s\n", logging.Redact(e.Err.Error()))
+	if e.Command != "" {
+		fmt.Printf("Command: %s\n", logging.Redact(e.Command))
+	}
+	if e.ExitCode >= 0 {
+		fmt.Printf("Exit code: %d\n", e.ExitCode)
+	}
+	if e.Stderr != "" {
+		fmt.Println("Stderr:")
+		for _, line := range strings.Split(e.Stderr, "\n") {
+			fmt.Printf("  %s\n", logging.Redact(line))
+		}
+	}
+	if e.Hint != "" {
+		fmt.Printf("Hint: %s\n", e.Hint)
+	}
+
+	os.Exit(e.Block.ExitCode())
+}
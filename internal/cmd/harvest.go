@@ -0,0 +1,243 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/acker1019/fedora-phoenix/internal/config"
+	"github.com/acker1019/fedora-phoenix/internal/ops"
+	"github.com/acker1019/fedora-phoenix/internal/session"
+	"github.com/acker1019/fedora-phoenix/internal/utils"
+
+	"github.com/spf13/cobra"
+)
+
+// harvestCmd represents the harvest command
+var harvestCmd = &cobra.Command{
+	Use:   "harvest",
+	Short: "Copy running-system dotfile changes back into the dotfiles repo",
+	Long: `Scan userspace.sync items and copy files whose content changed on the system
+back into the dotfiles repo, updating modes and owners in the state store (ADR-0007).
+Harvest never commits: review the result with git status and commit it yourself.
+
+Run as the normal user, not via sudo.
+
+Daemon mode polls on the blueprint's userspace.sync.harvest_interval:
+  --watch     Harvest in a loop until SIGTERM (what the service runs)
+  --daemon    Install and start ` + ops.HarvestUnit + ` (systemd user scope)
+  --shutdown  Stop the service and remove its unit file
+  --check     Show service state, last scan and pending drift
+
+Exit codes:
+  0   Success
+  1   Usage error
+  10  Blueprint could not be loaded
+  40  Harvest failed`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runHarvest()
+	},
+}
+
+// harvest-only flags
+var harvestDryRun bool
+var harvestWatch bool
+var harvestDaemon bool
+var harvestShutdown bool
+var harvestCheck bool
+
+func init() {
+	rootCmd.AddCommand(harvestCmd)
+	harvestCmd.Flags().BoolVar(&harvestDryRun, "dry-run", false, "Preview what would be harvested without writing to the repo")
+	harvestCmd.Flags().BoolVar(&harvestWatch, "watch", false, "Harvest periodically until SIGTERM")
+	harvestCmd.Flags().BoolVar(&harvestDaemon, "daemon", false, "Install and start the harvest systemd user service")
+	harvestCmd.Flags().BoolVar(&harvestShutdown, "shutdown", false, "Stop the harvest service and remove its unit file")
+	harvestCmd.Flags().BoolVar(&harvestCheck, "check", false, "Show harvest service status, last scan and pending drift")
+	harvestCmd.MarkFlagsMutuallyExclusive("watch", "daemon", "shutdown", "check")
+}
+
+func runHarvest() {
+	// Harvested files must stay owned by the user, never by root
+	if os.Geteuid() == 0 {
+		fmt.Println("❌ Error: harvest must be run as the normal user, not root.")
+		os.Exit(1)
+	}
+
+	sess, err := harvestSession()
+	if err != nil {
+		exitOnError(err)
+	}
+
+	switch {
+	case harvestWatch:
+		runHarvestWatch(sess)
+	case harvestDaemon:
+		if err := harvestService(sess).Install(sess.Runner); err != nil {
+			exitOnError(harvestError(err))
+		}
+		fmt.Printf("✅ %s enabled. Check it with `phoenix harvest --check`.\n", ops.HarvestUnit)
+	case harvestShutdown:
+		if err := harvestService(sess).Remove(sess.Runner); err != nil {
+			exitOnError(harvestError(err))
+		}
+		fmt.Printf("✅ %s stopped and removed.\n", ops.HarvestUnit)
+	case harvestCheck:
+		runHarvestCheck(sess)
+	default:
+		report, err := harvestOnce(sess, harvestDryRun)
+		if err != nil {
+			exitOnError(err)
+		}
+		printHarvest(report, harvestDryRun)
+	}
+}
+
+// runHarvestWatch polls until SIGTERM or Ctrl-C; the running scan is finished first.
+func runHarvestWatch(sess *session.Session) {
+	interval, _ := sess.Blueprint.UserSpace.Sync.Interval() // validated on load
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	h := &ops.Harvest{BaseDir: sess.SyncBaseDir, Items: syncItems(sess), DryRun: harvestDryRun}
+	if err := h.Watch(ctx, interval, ops.HarvestStatusPath(sess.UserHome)); err != nil {
+		exitOnError(harvestError(err))
+	}
+}
+
+// runHarvestCheck prints the service state, the daemon's last scan and
+// the drift a harvest would pick up right now.
+func runHarvestCheck(sess *session.Session) {
+	svc := harvestService(sess)
+	enabled, active := svc.State(sess.Runner)
+
+	fmt.Printf("Service:   %s (%s, %s)\n", ops.HarvestUnit, enabled, active)
+	if _, err := os.Stat(svc.UnitPath()); err == nil {
+		fmt.Printf("Unit file: %s\n", svc.UnitPath())
+	} else {
+		fmt.Println("Unit file: not installed (run `phoenix harvest --daemon`)")
+	}
+
+	status, err := ops.LoadHarvestStatus(ops.HarvestStatusPath(sess.UserHome))
+	switch {
+	case err != nil:
+		fmt.Printf("Last scan: unreadable (%v)\n", err)
+	case status == nil:
+		fmt.Println("Last scan: never")
+	case status.Error != "":
+		fmt.Printf("Last scan: %s, failed: %s\n", status.LastScan.Local().Format(time.DateTime), status.Error)
+	default:
+		fmt.Printf("Last scan: %s, harvested %d files, %d mode changes, %d missing\n",
+			status.LastScan.Local().Format(time.DateTime), status.Harvested, status.ModeChanged, status.Missing)
+	}
+
+	report, err := harvestOnce(sess, true)
+	if err != nil {
+		exitOnError(err)
+	}
+	fmt.Printf("Pending:   %d files, %d mode changes, %d missing\n",
+		len(report.Harvested), len(report.ModeChanged), len(report.Missing))
+}
+
+// harvestService describes the daemon unit for this binary and blueprint.
+func harvestService(sess *session.Session) *ops.HarvestService {
+	exe, err := os.Executable()
+	if err != nil {
+		exitOnError(harvestError(fmt.Errorf("failed to locate the phoenix binary: %w", err)))
+	}
+	blueprint, err := filepath.Abs(blueprintPath)
+	if err != nil {
+		exitOnError(harvestError(err))
+	}
+	return &ops.HarvestService{
+		UnitDir:    filepath.Join(sess.UserHome, ".config", "systemd", "user"),
+		Executable: exe,
+		Blueprint:  blueprint,
+	}
+}
+
+// harvestSession loads the blueprint for the current (non-root) user.
+func harvestSession() (*session.Session, error) {
+	sess := &session.Session{Runner: utils.NewSystemRunner()}
+
+	current, err := user.Current()
+	if err != nil {
+		return nil, ops.NewBlockError(ops.BlockIdentity, ops.CategoryPermission,
+			"Run harvest from the user's desktop session", fmt.Errorf("failed to detect current user: %w", err))
+	}
+	sess.Username = current.Username
+	if sess.UID, sess.GID, sess.UserHome, err = utils.LookupUser(sess.Username); err != nil {
+		return nil, ops.NewBlockError(ops.BlockIdentity, ops.CategoryConfig, "Check the current user account", err)
+	}
+
+	sess.Blueprint, err = config.LoadBlueprint(blueprintPath)
+	if err != nil {
+		return nil, ops.NewBlockError(ops.BlockIdentity, ops.CategoryConfig,
+			"Check the --blueprint path and compare with phoenix.example.yml", fmt.Errorf("failed to load blueprint: %w", err))
+	}
+	if sess.Blueprint.Identity.Username != sess.Username {
+		fmt.Printf("⚠️  Blueprint identity is %s, harvesting for %s\n", sess.Blueprint.Identity.Username, sess.Username)
+	}
+	if len(sess.Blueprint.UserSpace.Sync.Items) == 0 {
+		return nil, ops.NewBlockError(ops.BlockIdentity, ops.CategoryConfig,
+			"Declare userspace.sync.items in the blueprint", fmt.Errorf("nothing to harvest"))
+	}
+
+	sess.SyncBaseDir = utils.ExpandPath(sess.Blueprint.UserSpace.Sync.BaseDir, sess.UserHome)
+	return sess, nil
+}
+
+// harvestOnce runs a single System → Repo scan.
+func harvestOnce(sess *session.Session, dryRun bool) (*ops.HarvestReport, error) {
+	h := &ops.Harvest{BaseDir: sess.SyncBaseDir, Items: syncItems(sess), DryRun: dryRun}
+	report, err := h.Run()
+	if err != nil {
+		return nil, harvestError(err)
+	}
+	return report, nil
+}
+
+// harvestError attributes err to Harvest so it exits with the Block IV code.
+func harvestError(err error) error {
+	var e *ops.Error
+	if !errors.As(err, &e) {
+		e = ops.NewBlockError(ops.BlockUserSpace, ops.CategoryUnknown, "", err)
+	}
+	e.Act, e.Block = "Harvest", ops.BlockUserSpace
+	return e
+}
+
+// printHarvest renders the harvest summary.
+func printHarvest(report *ops.HarvestReport, dryRun bool) {
+	verb := "Harvested"
+	if dryRun {
+		fmt.Println("🔍 DRY-RUN MODE (no changes will be made)")
+		verb = "Would harvest"
+	}
+
+	for _, path := range report.Harvested {
+		fmt.Printf("  ← %s\n", path)
+	}
+	for _, path := range report.ModeChanged {
+		fmt.Printf("  ± %s (mode/owner)\n", path)
+	}
+	for _, path := range report.Missing {
+		fmt.Printf("  ✗ %s (missing on system)\n", path)
+	}
+	for _, path := range report.Skipped {
+		fmt.Printf("  ⚠ %s (symlink or not a regular file, skipped)\n", path)
+	}
+
+	fmt.Println()
+	fmt.Printf("%s: %d files, %d mode changes, %d missing, %d unchanged\n",
+		verb, len(report.Harvested), len(report.ModeChanged), len(report.Missing), report.Unchanged)
+	if report.Changed() && !dryRun {
+		fmt.Println("Review with `git status` in the dotfiles repo and commit yourself.")
+	}
+}
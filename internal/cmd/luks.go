@@ -0,0 +1,215 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/acker1019/fedora-phoenix/internal/config"
+	"github.com/acker1019/fedora-phoenix/internal/ops"
+	"github.com/acker1019/fedora-phoenix/internal/utils"
+
+	"github.com/spf13/cobra"
+)
+
+// luksCmd groups the LUKS maintenance commands
+var luksCmd = &cobra.Command{
+	Use:   "luks",
+	Short: "Maintain the LUKS volumes of the blueprint",
+	Long: `The LUKS partition is the one thing that survives every reinstall.
+These commands back up its header and report on its keyslots.`,
+}
+
+var luksBackupHeaderCmd = &cobra.Command{
+	Use:   "backup-header",
+	Short: "Back up LUKS headers with a SHA-256 checksum",
+	Long: `Write ` + "`cryptsetup luksHeaderBackup`" + ` of each volume to its header_backup path
+(or --output), together with a sha256sum file. An identical existing backup
+is left untouched.
+
+Exit codes:
+  0   Success
+  1   Usage error
+  10  Blueprint could not be loaded
+  20  Backup failed`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runLuksBackupHeader()
+	},
+}
+
+var luksStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Report LUKS version, cipher, PBKDF, keyslots and tokens",
+	Long: `Parse ` + "`cryptsetup luksDump`" + ` of each volume and warn about weak or
+fragile setups (LUKS1, pbkdf2 keyslots, no recovery passphrase).
+
+Exit codes:
+  0   Success
+  1   Usage error
+  10  Blueprint could not be loaded
+  20  A header could not be read`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runLuksStatus()
+	},
+}
+
+// luks-only flags
+var luksVolume string
+var luksBackupOutput string
+var luksStatusJSON bool
+
+func init() {
+	rootCmd.AddCommand(luksCmd)
+	luksCmd.AddCommand(luksBackupHeaderCmd, luksStatusCmd)
+	luksCmd.PersistentFlags().StringVar(&luksVolume, "volume", "", "Only this volume (infrastructure.luks mapper_name)")
+	luksBackupHeaderCmd.Flags().StringVarP(&luksBackupOutput, "output", "o", "", "Backup file, overriding header_backup (single volume)")
+	luksStatusCmd.Flags().BoolVar(&luksStatusJSON, "json", false, "Print a machine-readable JSON report")
+}
+
+func runLuksBackupHeader() {
+	volumes := luksVolumes()
+
+	if luksBackupOutput != "" {
+		if len(volumes) != 1 {
+			fmt.Println("❌ Error: --output needs a single volume, select one with --volume")
+			os.Exit(1)
+		}
+		volumes[0].HeaderBackup = luksBackupOutput
+	}
+
+	r := utils.NewSystemRunner()
+	done := 0
+	for _, vol := range volumes {
+		if vol.HeaderBackup == "" {
+			fmt.Printf("⚠️  %s: no header_backup path, skipped\n", vol.MapperName)
+			continue
+		}
+		if skipAbsentVolume(vol) {
+			continue
+		}
+
+		backup, err := ops.BackupLuksHeader(r, vol.Device, vol.LuksUUID, vol.HeaderBackup)
+		if err != nil {
+			exitOnError(luksError("BackupLuksHeader", err))
+		}
+		if backup.Changed {
+			fmt.Printf("✅ %s: header of %s written to %s\n", vol.MapperName, backup.Device, backup.Path)
+		} else {
+			fmt.Printf("✓ %s: %s is up to date\n", vol.MapperName, backup.Path)
+		}
+		fmt.Printf("   sha256 %s (%s)\n", backup.SHA256, backup.ChecksumPath)
+		done++
+	}
+
+	if done == 0 {
+		fmt.Println("❌ Error: nothing to back up: set header_backup on a volume or pass --output")
+		os.Exit(1)
+	}
+	fmt.Println("🔑 Keep the backup off this disk: it can restore a damaged header, and old passphrases with it.")
+}
+
+func runLuksStatus() {
+	volumes := luksVolumes()
+	r := utils.NewSystemRunner()
+
+	reports := []*ops.LuksReport{}
+	for _, vol := range volumes {
+		if skipAbsentVolume(vol) {
+			continue
+		}
+		report, err := ops.LuksStatus(r, vol.Device, vol.LuksUUID)
+		if err != nil {
+			exitOnError(luksError("LuksStatus", err))
+		}
+		reports = append(reports, report)
+		if !luksStatusJSON {
+			printLuksStatus(vol.MapperName, report)
+		}
+	}
+
+	if luksStatusJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(reports); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Error: failed to encode report: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// luksVolumes loads the blueprint and returns the selected volumes.
+// Both commands read the raw device, so they need root.
+func luksVolumes() []config.LuksConfig {
+	if os.Geteuid() != 0 {
+		fmt.Println("❌ Error: This command must be run as root (sudo).")
+		os.Exit(1)
+	}
+
+	bp, err := config.LoadBlueprint(blueprintPath)
+	if err != nil {
+		exitOnError(ops.NewBlockError(ops.BlockIdentity, ops.CategoryConfig,
+			"Check the --blueprint path and compare with phoenix.example.yml", fmt.Errorf("failed to load blueprint: %w", err)))
+	}
+
+	if luksVolume == "" {
+		return bp.Infrastructure.Luks
+	}
+	vol, err := bp.Infrastructure.Luks.Find(luksVolume)
+	if err != nil {
+		fmt.Printf("❌ Error: --volume: %v\n", err)
+		os.Exit(1)
+	}
+	return []config.LuksConfig{vol}
+}
+
+// skipAbsentVolume reports (and announces) an optional volume whose disk is not attached.
+func skipAbsentVolume(vol config.LuksConfig) bool {
+	if _, err := utils.ResolveDevice(vol.Device); vol.Optional && errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("⏭️  %s: optional device %s not present, skipped\n", vol.MapperName, vol.Device)
+		return true
+	}
+	return false
+}
+
+// luksError attributes a failure to the named operation in Block II.
+func luksError(name string, err error) error {
+	var e *ops.Error
+	if !errors.As(err, &e) {
+		e = ops.NewBlockError(ops.BlockInfrastructure, ops.CategoryUnknown, "", err)
+	}
+	e.Act, e.Block = name, ops.BlockInfrastructure
+	return e
+}
+
+// printLuksStatus renders one volume's report.
+func printLuksStatus(name string, report *ops.LuksReport) {
+	fmt.Printf("🔐 %s (%s)\n", name, report.Device)
+	fmt.Printf("  Version:  LUKS%d\n", report.Version)
+	fmt.Printf("  UUID:     %s\n", report.UUID)
+	if report.Label != "" {
+		fmt.Printf("  Label:    %s\n", report.Label)
+	}
+	fmt.Printf("  Cipher:   %s\n", report.Cipher)
+
+	fmt.Printf("  Keyslots: %d used\n", len(report.Keyslots))
+	for _, slot := range report.Keyslots {
+		fmt.Printf("    %d: %s (%s)\n", slot.ID, slot.Type, slot.PBKDF)
+	}
+	if len(report.Tokens) > 0 {
+		fmt.Printf("  Tokens:   %d\n", len(report.Tokens))
+		for _, token := range report.Tokens {
+			slots := make([]string, 0, len(token.Keyslots))
+			for _, s := range token.Keyslots {
+				slots = append(slots, fmt.Sprint(s))
+			}
+			fmt.Printf("    %d: %s (keyslot %s)\n", token.ID, token.Type, strings.Join(slots, ", "))
+		}
+	}
+
+	for _, w := range report.Warnings {
+		fmt.Printf("  ⚠️  %s\n", w)
+	}
+	fmt.Println()
+}
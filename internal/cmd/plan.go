@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/acker1019/fedora-phoenix/internal/config"
+	"github.com/acker1019/fedora-phoenix/internal/ops"
+	"github.com/acker1019/fedora-phoenix/internal/session"
+	"github.com/acker1019/fedora-phoenix/internal/utils"
+)
+
+// runPlan runs the Check half of every Act and prints what provision would change.
+// It never loads, consumes or destroys the secrets file.
+func runPlan() {
+	fmt.Println("🔍 DRY-RUN MODE (no changes will be made)")
+
+	sess := &session.Session{Runner: utils.NewSystemRunner()}
+
+	// Real User Detection (home directory is resolved, never created)
+	realUser, realUID, realGID, err := utils.GetRealUser()
+	if err != nil {
+		exitOnError(ops.NewBlockError(ops.BlockIdentity, ops.CategoryPermission,
+			"Run Phoenix from the user's desktop session", fmt.Errorf("failed to detect real user: %w", err)))
+	}
+	sess.Username = realUser
+	sess.UID = realUID
+	sess.GID = realGID
+	sess.UserHome = ops.HomeDir(sess.Username)
+
+	// Block I: Blueprint only, secrets are not needed for checks
+	sess.Blueprint, err = config.LoadBlueprint(blueprintPath)
+	if err != nil {
+		exitOnError(ops.NewBlockError(ops.BlockIdentity, ops.CategoryConfig,
+			"Check the --blueprint path and compare with phoenix.example.yml", fmt.Errorf("failed to load blueprint: %w", err)))
+	}
+	sess.DotfilesArchive = dotfilesArchive
+
+	// Blocks II-IV in provision order
+	var acts []ops.Act
+	acts = append(acts, infrastructureActs(sess)...)
+	acts = append(acts, systemActs(sess)...)
+	acts = append(acts, userSpaceActs(sess)...)
+
+	pb := &ops.Playbook{Runner: sess.Runner, DryRun: true, Only: onlyActs, Skip: skipActs}
+	results, err := pb.Run(acts)
+	if err != nil {
+		exitOnError(err)
+	}
+
+	printPlan(results)
+}
+
+// printPlan renders the Check results grouped by Block and a final summary count.
+func printPlan(results []ops.Result) {
+	var unchanged, changes int
+	var block ops.Block
+
+	for _, res := range results {
+		if res.Filtered {
+			continue
+		}
+		if res.Act.Block() != block {
+			block = res.Act.Block()
+			fmt.Println()
+			fmt.Println(block)
+		}
+		for _, d := range res.Diffs {
+			if d.Satisfied {
+				unchanged++
+				fmt.Printf("  ✓ %s: %s\n", d.Item, d.Detail)
+			} else {
+				changes++
+				fmt.Printf("  → %s: %s\n", d.Item, d.Detail)
+			}
+		}
+	}
+
+	fmt.Println()
+	fmt.Printf("Summary: %d already satisfied, %d would change\n", unchanged, changes)
+}
+
+// printReport renders one line per Act with its outcome and duration.
+func printReport(results []ops.Result) {
+	for _, res := range results {
+		switch {
+		case res.Filtered:
+			fmt.Printf("  - %-22s filtered\n", res.Act.Name())
+		case res.Applied:
+			fmt.Printf("  → %-22s %d changed (%s)\n", res.Act.Name(), len(res.Pending()), res.Duration.Round(time.Millisecond))
+		default:
+			fmt.Printf("  ✓ %-22s up to date (%s)\n", res.Act.Name(), res.Duration.Round(time.Millisecond))
+		}
+	}
+}
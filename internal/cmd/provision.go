@@ -16,27 +16,51 @@ import (
 var provisionCmd = &cobra.Command{
 	Use:   "provision",
 	Short: "Start the full restoration protocol",
-	Long:  `Unlock LUKS, mount data, install packages, and link dotfiles.`,
+	Long: `Unlock LUKS, mount data, install packages, and link dotfiles.
+
+Without --secrets, LUKS passwords come from keyfiles or are asked for on
+the terminal (echo off); a wrong password is asked for again.
+
+The secrets file must be owned by root or the invoking user and must not
+be accessible by group or others. --secrets=- reads it from stdin, and
+/dev/fd/N from an inherited descriptor; neither is destroyed afterwards.
+A plaintext file is overwritten and deleted, which only destroys its
+content on a tmpfs or a spinning disk: the report says which it was.
+
+Exit codes:
+  0   Success
+  1   Usage error
+  10  Block I (Identity & Configuration) failed
+  20  Block II (Infrastructure) failed
+  30  Block III (System State) failed
+  40  Block IV (User Space) failed`,
 	Run: func(cmd *cobra.Command, args []string) {
 		runProvision()
 	},
 }
 
+// provision-only flags
+var dryRun bool
+var onlyActs []string
+var skipActs []string
+
 func init() {
 	rootCmd.AddCommand(provisionCmd)
 	// 如果 provision 有自己專屬的 flag，可以在這裡加
-	// provisionCmd.Flags().BoolP("dry-run", "d", false, "Preview changes only")
+	// -d is taken by the global --dotfiles-archive flag
+	provisionCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Preview changes only (secrets are not required or destroyed)")
+	provisionCmd.Flags().StringSliceVar(&onlyActs, "only", nil, "Run only these Acts (e.g. EnsurePackages,GitClone)")
+	provisionCmd.Flags().StringSliceVar(&skipActs, "skip", nil, "Skip these Acts")
 }
 
 func runProvision() {
-	// 1. Validate Flags
-	if secretsPath == "" {
-		fmt.Println("❌ Error: --secrets flag is required.")
-		fmt.Println("Usage: sudo phoenix provision --secrets=/path/to/secrets.yml")
-		os.Exit(1)
+	// Dry-run only executes the Check half of every Act
+	if dryRun {
+		runPlan()
+		return
 	}
 
-	// 2. Root Check
+	// 1. Root Check
 	if os.Geteuid() != 0 {
 		fmt.Println("❌ Error: This command must be run as root (sudo).")
 		os.Exit(1)
@@ -47,19 +71,21 @@ func runProvision() {
 	// ============================================================================
 	// Initialize Session
 	// ============================================================================
-	sess := &session.Session{}
+	sess := &session.Session{Runner: utils.NewSystemRunner()}
 
-	// 3. Real User Detection (supports X11 & Wayland)
+	// 2. Real User Detection (supports X11 & Wayland)
 	realUser, realUID, realGID, err := utils.GetRealUser()
 	if err != nil {
-		panic(fmt.Sprintf("Failed to detect real user: %v", err))
+		exitOnError(ops.NewBlockError(ops.BlockIdentity, ops.CategoryPermission,
+			"Run Phoenix via sudo from the user's desktop session", fmt.Errorf("failed to detect real user: %w", err)))
 	}
 	sess.Username = realUser
 	sess.UID = realUID
 	sess.GID = realGID
 	sess.UserHome, err = ops.EnsureUserHome(sess.Username, sess.UID, sess.GID)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to ensure home directory: %v", err))
+		exitOnError(ops.NewBlockError(ops.BlockIdentity, ops.CategoryPermission,
+			"Check that /home is writable by root", fmt.Errorf("failed to ensure home directory: %w", err)))
 	}
 	fmt.Printf("✓ Detected real user: %s (UID %d, GID %d) -> %s\n", sess.Username, sess.UID, sess.GID, sess.UserHome)
 
@@ -71,80 +97,96 @@ func runProvision() {
 	// Load Blueprint (phoenix.yml)
 	sess.Blueprint, err = config.LoadBlueprint(blueprintPath)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to load blueprint: %v", err))
+		exitOnError(ops.NewBlockError(ops.BlockIdentity, ops.CategoryConfig,
+			"Check the --blueprint path and compare with phoenix.example.yml", fmt.Errorf("failed to load blueprint: %w", err)))
 	}
 
-	// Load Secrets
-	sess.Secrets, err = config.LoadSecrets(secretsPath)
-	if err != nil {
-		panic(fmt.Sprintf("Failed to load secrets: %v", err))
+	// Load Secrets. Without a secrets file, passwords come from keyfiles
+	// or are asked for on the terminal.
+	sess.Prompt = utils.HasTTY()
+	secretsOutcome := "✓ Secrets: no secrets file given"
+	switch {
+	case secretsPath != "":
+		sess.Secrets, err = config.LoadSecrets(secretsPath, sess.UID)
+		if err != nil {
+			exitOnError(ops.NewBlockError(ops.BlockIdentity, ops.CategoryConfig,
+				"Check the --secrets path and compare with secrets.example.yml", fmt.Errorf("failed to load secrets: %w", err)))
+		}
+		// Self-destruct logic; an encrypted bundle is safe to keep, and
+		// stdin or an inherited descriptor leaves nothing behind
+		switch {
+		case sess.Secrets.Sealed():
+			fmt.Println("✓ Secrets bundle is sealed, keeping it")
+			secretsOutcome = fmt.Sprintf("✓ Secrets: sealed bundle %s kept", secretsPath)
+		case config.IsSecretsStream(secretsPath):
+			secretsOutcome = fmt.Sprintf("✓ Secrets: read from %s, nothing to destroy", secretsPath)
+		default:
+			report := config.CleanupSecrets(secretsPath)
+			secretsOutcome = "✓ Secrets: " + report.String()
+			if !report.Clean() {
+				secretsOutcome = "⚠️  Secrets: " + report.String()
+			}
+		}
+	case !sess.Prompt && !keyfilesOnly(sess.Blueprint):
+		fmt.Println("❌ Error: --secrets flag is required without a terminal (unless every LUKS volume has a keyfile).")
+		fmt.Println("Usage: sudo phoenix provision --secrets=/path/to/secrets.yml (or --secrets=- to read stdin)")
+		os.Exit(1)
+	}
+	if err := checkLuksSecrets(sess); err != nil {
+		exitOnError(ops.NewBlockError(ops.BlockIdentity, ops.CategoryConfig,
+			"Match every infrastructure.luks secret_key with an entry in luks_passwords, or attach the key medium", err))
+	}
+	if err := resolveSecretRefs(sess, secretRefs(sess.Blueprint)); err != nil {
+		exitOnError(ops.NewBlockError(ops.BlockIdentity, ops.CategoryConfig,
+			"Define every ${secret:name} of the blueprint in the secrets file (or the vault), or run on a terminal to be asked for it", err))
 	}
-	// Self-destruct logic
-	config.CleanupSecrets(secretsPath)
 
 	// Store dotfiles archive path
 	sess.DotfilesArchive = dotfilesArchive
 
+	// Every remaining Block is a list of Acts walked by the same Playbook
+	pb := &ops.Playbook{Runner: sess.Runner, Only: onlyActs, Skip: skipActs}
+	var results []ops.Result
+
 	// ============================================================================
 	// Block II: Infrastructure
 	// ============================================================================
 	fmt.Println("🔧 Step 2/5: Setting up infrastructure...")
 
-	// Store infrastructure info in session
-	sess.LuksMapperName = sess.Blueprint.Infrastructure.Luks.MapperName
-	sess.LuksMountPoint = sess.Blueprint.Infrastructure.Luks.MountPoint
-
-	// LUKS Unlock
-	err = ops.UnlockLuks(
-		sess.Blueprint.Infrastructure.Luks.Device,
-		sess.LuksMapperName,
-		sess.Secrets.LuksPassword,
-	)
+	// LUKS Unlock & Mount Device
+	blockResults, err := pb.Run(infrastructureActs(sess))
+	results = append(results, blockResults...)
 	if err != nil {
-		panic(err)
+		exitOnError(err)
 	}
-	sess.LuksUnlocked = true
-
-	// Mount Device
-	if err := ops.MountDevice(
-		sess.LuksMapperName,
-		sess.LuksMountPoint,
-	); err != nil {
-		panic(err)
+	markMountedVolumes(sess)
+
+	// Second-stage secrets live on the now unlocked volume
+	if err := loadVault(sess); err != nil {
+		e := ops.NewBlockError(ops.BlockInfrastructure, ops.CategoryConfig,
+			"Check infrastructure.vault and compare the file with secrets.example.yml", fmt.Errorf("failed to load vault: %w", err))
+		e.Act = "LoadVault"
+		exitOnError(e)
+	}
+	if sess.Blueprint.Infrastructure.Vault.Path != "" {
+		if err := resolveSecretRefs(sess, sess.Blueprint.SecretRefs()); err != nil {
+			e := ops.NewBlockError(ops.BlockInfrastructure, ops.CategoryConfig,
+				"Define every ${secret:name} of the blueprint in the vault or the secrets file", err)
+			e.Act = "LoadVault"
+			exitOnError(e)
+		}
 	}
-	sess.LuksMounted = true
 
 	// ============================================================================
 	// Block III: System State
 	// ============================================================================
 	fmt.Println("📦 Step 3/5: Configuring system state...")
 
-	// Install Packages
-	if len(sess.Blueprint.System.Packages) > 0 {
-		if err := ops.EnsurePackages(sess.Blueprint.System.Packages); err != nil {
-			panic(err)
-		}
-	}
-
-	// Install Pinned Packages
-	if len(sess.Blueprint.System.PinnedPackages) > 0 {
-		if err := ops.EnsurePinnedPackages(sess.Blueprint.System.PinnedPackages); err != nil {
-			panic(err)
-		}
-	}
-
-	// Enable Services
-	if len(sess.Blueprint.System.Services) > 0 {
-		if err := ops.EnsureServices(sess.Blueprint.System.Services); err != nil {
-			panic(err)
-		}
-	}
-
-	// Set User Shell
-	if sess.Blueprint.Identity.Shell != "" {
-		if err := ops.EnsureUserShell(sess.Blueprint.Identity.Username, sess.Blueprint.Identity.Shell); err != nil {
-			panic(err)
-		}
+	// Install Packages, Pinned Packages, Enable Services, Set User Shell
+	blockResults, err = pb.Run(systemActs(sess))
+	results = append(results, blockResults...)
+	if err != nil {
+		exitOnError(err)
 	}
 
 	// ============================================================================
@@ -152,40 +194,20 @@ func runProvision() {
 	// ============================================================================
 	fmt.Println("👤 Step 4/5: Restoring user space...")
 
-	// Expand all paths in blueprint using the determined home directory
-	sess.StowSourceDir = utils.ExpandPath(sess.Blueprint.UserSpace.Stow.SourceDir, sess.UserHome)
-	sess.StowTargetDir = utils.ExpandPath(sess.Blueprint.UserSpace.Stow.TargetDir, sess.UserHome)
-
-	// Extract Dotfiles Archive (if provided)
-	if sess.DotfilesArchive != "" {
-		if err := ops.ExtractTarball(
-			sess.DotfilesArchive,
-			sess.StowSourceDir,
-			sess.Blueprint.Identity.Username,
-		); err != nil {
-			panic(err)
-		}
+	// Extract Dotfiles, Deploy with Stow, Clone Git Repositories
+	blockResults, err = pb.Run(userSpaceActs(sess))
+	results = append(results, blockResults...)
+	if err != nil {
+		exitOnError(err)
 	}
 
-	// Deploy Dotfiles with Stow
-	if len(sess.Blueprint.UserSpace.Stow.Packages) > 0 {
-		if err := ops.RunStow(
-			sess.StowSourceDir,
-			sess.StowTargetDir,
-			sess.Blueprint.UserSpace.Stow.Packages,
-			sess.Blueprint.Identity.Username,
-		); err != nil {
-			panic(err)
-		}
-	}
+	// Nothing needs the secrets anymore (on failure, exiting releases them)
+	sess.Secrets.Destroy()
+	sess.Secrets = nil
 
-	// Clone Git Repositories
-	for _, repo := range sess.Blueprint.UserSpace.Repos {
-		expandedDest := utils.ExpandPath(repo.Dest, sess.UserHome)
-		if err := ops.GitClone(repo.URL, expandedDest, sess.Blueprint.Identity.Username); err != nil {
-			panic(err)
-		}
-	}
+	fmt.Println("📋 Step 5/5: Report")
+	printReport(results)
+	fmt.Printf("  %s\n", secretsOutcome)
 
 	fmt.Println("✨ Phoenix Protocol Complete. Welcome back, Commander.")
 }
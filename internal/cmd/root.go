@@ -34,7 +34,7 @@ func Execute() {
 func init() {
 	// 定義全域 Flag
 	// PersistentFlags 代表這個 flag 可以被所有子命令繼承
-	rootCmd.PersistentFlags().StringVarP(&secretsPath, "secrets", "s", "", "Path to the secrets YAML file (required)")
+	rootCmd.PersistentFlags().StringVarP(&secretsPath, "secrets", "s", "", "Path to the secrets YAML file, - for stdin (prompted for on the terminal when omitted)")
 	rootCmd.PersistentFlags().StringVarP(&blueprintPath, "blueprint", "b", "phoenix.yml", "Path to the blueprint YAML file")
 	rootCmd.PersistentFlags().StringVarP(&dotfilesArchive, "dotfiles-archive", "d", "", "Path to dotfiles tarball (.tgz)")
 }
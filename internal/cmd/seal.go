@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/acker1019/fedora-phoenix/internal/config"
+	"github.com/acker1019/fedora-phoenix/internal/ops"
+	"github.com/acker1019/fedora-phoenix/internal/session"
+	"github.com/acker1019/fedora-phoenix/internal/utils"
+
+	"github.com/spf13/cobra"
+)
+
+// sealCmd represents the seal command
+var sealCmd = &cobra.Command{
+	Use:   "seal",
+	Short: "Tear down projections, unmount and lock every LUKS volume",
+	Long: `Reverse the infrastructure part of provision before a reinstall or travel:
+remove projections, switch off a swap file, unmount each volume and close its mapper, in reverse
+blueprint order. If a mount is busy, the processes holding it are listed.
+
+Exit codes:
+  0   Success
+  1   Usage error
+  10  Blueprint could not be loaded
+  20  Unmount or lock failed
+  40  Projection teardown failed`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runSeal()
+	},
+}
+
+// seal-only flags
+var sealDryRun bool
+
+func init() {
+	rootCmd.AddCommand(sealCmd)
+	sealCmd.Flags().BoolVar(&sealDryRun, "dry-run", false, "Preview what would be unmounted and locked")
+}
+
+func runSeal() {
+	if !sealDryRun && os.Geteuid() != 0 {
+		fmt.Println("❌ Error: This command must be run as root (sudo).")
+		os.Exit(1)
+	}
+
+	sess := &session.Session{Runner: utils.NewSystemRunner()}
+
+	// Projection targets follow the blueprint identity, like verify
+	var err error
+	sess.Blueprint, err = config.LoadBlueprint(blueprintPath)
+	if err != nil {
+		exitOnError(ops.NewBlockError(ops.BlockIdentity, ops.CategoryConfig,
+			"Check the --blueprint path and compare with phoenix.example.yml", fmt.Errorf("failed to load blueprint: %w", err)))
+	}
+	sess.Username = sess.Blueprint.Identity.Username
+	sess.UserHome = ops.HomeDir(sess.Username)
+
+	if sealDryRun {
+		fmt.Println("🔍 DRY-RUN MODE (no changes will be made)")
+	} else {
+		fmt.Println("🔒 Sealing LUKS volumes...")
+	}
+
+	pb := &ops.Playbook{Runner: sess.Runner, DryRun: sealDryRun}
+	results, err := pb.Run(sealActs(sess))
+	if err != nil {
+		exitOnError(err)
+	}
+
+	if sealDryRun {
+		printPlan(results)
+		return
+	}
+	printReport(results)
+	fmt.Println("✨ All volumes sealed.")
+}
+
+// sealActs reverses provision: projections first, then each volume
+// (unmount, lock), both in reverse blueprint order. A swap file is switched
+// off before its volume is unmounted.
+func sealActs(sess *session.Session) []ops.Act {
+	bp := sess.Blueprint
+	var acts []ops.Act
+
+	for i := len(bp.UserSpace.Projections) - 1; i >= 0; i-- {
+		p := bp.UserSpace.Projections[i]
+		vol, _ := bp.Infrastructure.Luks.Find(p.Volume)
+		acts = append(acts, &ops.TeardownProjection{
+			Src:    filepath.Join(vol.MountPoint, p.Src),
+			Dest:   filepath.Clean(utils.ExpandPath(p.Dest, sess.UserHome)),
+			Method: p.Method,
+		})
+	}
+
+	swap := bp.Infrastructure.Swap
+	for i := len(bp.Infrastructure.Luks) - 1; i >= 0; i-- {
+		vol := bp.Infrastructure.Luks[i]
+		if swap.Type == config.SwapFile {
+			if swapVol, _ := bp.Infrastructure.Luks.Find(swap.Volume); swapVol.MapperName == vol.MapperName {
+				acts = append(acts, &ops.SwapOff{Path: filepath.Join(vol.MountPoint, swap.Path)})
+			}
+		}
+		acts = append(acts,
+			&ops.UnmountDevice{MountPoint: vol.MountPoint},
+			&ops.LockLuks{MapperName: vol.MapperName},
+		)
+	}
+
+	return acts
+}
@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"unicode/utf8"
+
+	"github.com/acker1019/fedora-phoenix/internal/config"
+	"github.com/acker1019/fedora-phoenix/internal/utils"
+
+	"github.com/spf13/cobra"
+)
+
+// secretsCmd groups the secrets bundle commands
+var secretsCmd = &cobra.Command{
+	Use:   "secrets",
+	Short: "Seal and open passphrase-encrypted secrets bundles",
+	Long: `A sealed bundle is the secrets YAML encrypted with XChaCha20-Poly1305 under
+a key derived from a passphrase by Argon2id. provision --secrets accepts it
+directly: it asks for the passphrase, decrypts in memory and keeps the
+bundle instead of destroying it.`,
+}
+
+var secretsSealCmd = &cobra.Command{
+	Use:   "seal <secrets.yml>",
+	Short: "Encrypt a plaintext secrets file into a bundle",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runSecretsSeal(args[0])
+	},
+}
+
+var secretsOpenCmd = &cobra.Command{
+	Use:   "open <bundle>",
+	Short: "Decrypt a bundle to stdout (or --output)",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runSecretsOpen(args[0])
+	},
+}
+
+// secrets-only flags
+var secretsOutput string
+var secretsShred bool
+
+// minPassphraseLen is the shortest passphrase seal accepts
+const minPassphraseLen = 12
+
+func init() {
+	rootCmd.AddCommand(secretsCmd)
+	secretsCmd.AddCommand(secretsSealCmd, secretsOpenCmd)
+	secretsSealCmd.Flags().StringVarP(&secretsOutput, "output", "o", "", "Bundle path, must not exist yet (default: <secrets.yml>.sealed)")
+	secretsSealCmd.Flags().BoolVar(&secretsShred, "shred", false, "Overwrite and delete the plaintext file after sealing")
+	secretsOpenCmd.Flags().StringVarP(&secretsOutput, "output", "o", "", "Write the plaintext to this file (mode 0600) instead of stdout")
+}
+
+// runSecretsSeal and runSecretsOpen exit only once sealSecretsFile and
+// openSecretsFile have returned: os.Exit skips deferred calls, and those
+// wipe the plaintext and destroy the passphrases.
+func runSecretsSeal(path string) {
+	if err := sealSecretsFile(path); err != nil {
+		exitWithError(err)
+	}
+}
+
+func runSecretsOpen(path string) {
+	if err := openSecretsFile(path); err != nil {
+		exitWithError(err)
+	}
+}
+
+func sealSecretsFile(path string) error {
+	plaintext, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	defer clear(plaintext)
+
+	// Never seal something provision could not use
+	if config.IsBundle(plaintext) {
+		return fmt.Errorf("%s is already sealed", path)
+	}
+	book, err := config.ParseSecrets(plaintext)
+	if err != nil {
+		return err
+	}
+	book.Destroy()
+
+	passphrase, err := utils.ReadSecret("🔑 New passphrase: ")
+	if err != nil {
+		return err
+	}
+	defer passphrase.Destroy()
+	if utf8.RuneCount(passphrase.Bytes()) < minPassphraseLen {
+		return fmt.Errorf("passphrase must be at least %d characters", minPassphraseLen)
+	}
+	confirm, err := utils.ReadSecret("🔑 Repeat passphrase: ")
+	if err != nil {
+		return err
+	}
+	defer confirm.Destroy()
+	if !confirm.Equal(passphrase) {
+		return fmt.Errorf("passphrases do not match")
+	}
+
+	bundle, err := config.SealSecrets(plaintext, passphrase.Bytes())
+	if err != nil {
+		return err
+	}
+
+	out := secretsOutput
+	if out == "" {
+		out = path + ".sealed"
+	}
+	if err := writeExclusive(out, bundle); err != nil {
+		return err
+	}
+	fmt.Printf("✅ Sealed %s -> %s\n", path, out)
+
+	if secretsShred {
+		fmt.Printf("🗑️  %s\n", config.CleanupSecrets(path))
+	} else {
+		fmt.Printf("⚠️  %s is still in plaintext: delete it (or re-run with --shred)\n", path)
+	}
+	return nil
+}
+
+func openSecretsFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if !config.IsBundle(data) {
+		return fmt.Errorf("%s is not a sealed bundle", path)
+	}
+
+	passphrase, err := utils.ReadSecret(fmt.Sprintf("🔑 Passphrase for %s: ", path))
+	if err != nil {
+		return err
+	}
+	plaintext, err := config.OpenSecrets(data, passphrase.Bytes())
+	passphrase.Destroy()
+	if err != nil {
+		return err
+	}
+	defer clear(plaintext)
+
+	if secretsOutput == "" {
+		os.Stdout.Write(plaintext)
+		if !bytes.HasSuffix(plaintext, []byte("\n")) {
+			fmt.Println()
+		}
+		return nil
+	}
+	if err := writeExclusive(secretsOutput, plaintext); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "✅ Opened %s -> %s\n", path, secretsOutput)
+	return nil
+}
+
+// writeExclusive creates path with mode 0600 and writes data to it.
+// O_EXCL: never overwrite (or follow a symlink to) an existing file.
+// A partly written file is removed.
+func writeExclusive(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	_, err = file.Write(data)
+	if syncErr := file.Sync(); err == nil {
+		err = syncErr
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// exitWithError prints a usage-level error and exits 1.
+func exitWithError(err error) {
+	fmt.Printf("❌ Error: %v\n", err)
+	os.Exit(1)
+}
@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/acker1019/fedora-phoenix/internal/config"
+	"github.com/acker1019/fedora-phoenix/internal/ops"
+	"github.com/acker1019/fedora-phoenix/internal/session"
+	"github.com/acker1019/fedora-phoenix/internal/utils"
+
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of `phoenix verify`
+const (
+	verifyExitClean = 0 // Live system matches the blueprint
+	verifyExitError = 1 // At least one check could not be evaluated
+	verifyExitDrift = 2 // Drift detected
+)
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Audit the live system against the blueprint (read-only)",
+	Long: `Evaluate every Check of the blueprint against the live machine and report drift.
+No secrets file is needed: LUKS volumes are only reported as locked or unlocked.
+
+Exit codes:
+  0   No drift
+  1   A check could not be evaluated
+  2   Drift detected
+  10  Blueprint could not be loaded`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runVerify()
+	},
+}
+
+// verify-only flags
+var verifyJSON bool
+var verifyExtraPackages bool
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().BoolVar(&verifyJSON, "json", false, "Print a machine-readable JSON report")
+	verifyCmd.Flags().BoolVar(&verifyExtraPackages, "extra-packages", false, "Also report user-installed packages missing from the blueprint")
+}
+
+// verifyReport is the JSON document printed by `phoenix verify --json`.
+type verifyReport struct {
+	CheckedAt time.Time     `json:"checked_at"`
+	Drift     bool          `json:"drift"`
+	OK        int           `json:"ok"`
+	Drifted   int           `json:"drifted"`
+	Errors    int           `json:"errors"`
+	Checks    []verifyCheck `json:"checks"`
+}
+
+type verifyCheck struct {
+	Name  string       `json:"name"`
+	Block string       `json:"block"`
+	Items []verifyItem `json:"items"`
+	Error string       `json:"error,omitempty"`
+}
+
+type verifyItem struct {
+	Item   string `json:"item"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+}
+
+func runVerify() {
+	sess := &session.Session{Runner: utils.NewSystemRunner()}
+
+	// Block I: Blueprint only; the home directory follows the blueprint
+	// identity so that verify also works from a systemd timer (no SUDO_USER)
+	var err error
+	sess.Blueprint, err = config.LoadBlueprint(blueprintPath)
+	if err != nil {
+		exitOnError(ops.NewBlockError(ops.BlockIdentity, ops.CategoryConfig,
+			"Check the --blueprint path and compare with phoenix.example.yml", fmt.Errorf("failed to load blueprint: %w", err)))
+	}
+	sess.Username = sess.Blueprint.Identity.Username
+	sess.UserHome = ops.HomeDir(sess.Username)
+	if sess.UID, sess.GID, _, err = utils.LookupUser(sess.Username); err != nil {
+		exitOnError(ops.NewBlockError(ops.BlockIdentity, ops.CategoryConfig,
+			"Check identity.username in the blueprint", err))
+	}
+
+	results := ops.Verify(sess.Runner, verifyCheckers(sess))
+	report := buildVerifyReport(results)
+
+	if verifyJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Error: failed to encode report: %v\n", err)
+			os.Exit(verifyExitError)
+		}
+	} else {
+		printVerify(report)
+	}
+
+	switch {
+	case report.Errors > 0:
+		os.Exit(verifyExitError)
+	case report.Drift:
+		os.Exit(verifyExitDrift)
+	default:
+		os.Exit(verifyExitClean)
+	}
+}
+
+// verifyCheckers builds the read-only audit list from the blueprint.
+func verifyCheckers(sess *session.Session) []ops.Checker {
+	bp := sess.Blueprint
+	var checkers []ops.Checker
+
+	// Block II & III: the Check half of the provisioning Acts
+	for _, act := range infrastructureActs(sess) {
+		checkers = append(checkers, act)
+	}
+	for _, act := range systemActs(sess) {
+		checkers = append(checkers, act)
+	}
+	if verifyExtraPackages {
+		declared := append(append([]string{}, bp.System.Packages...), bp.System.PinnedPackages...)
+		checkers = append(checkers, &ops.ExtraPackages{Declared: declared})
+	}
+
+	// Block IV: projections, synced dotfiles, dotfile links and repositories
+	for _, act := range projectionActs(sess) {
+		checkers = append(checkers, act)
+	}
+	sess.StowSourceDir = utils.ExpandPath(bp.UserSpace.Stow.SourceDir, sess.UserHome)
+	sess.StowTargetDir = utils.ExpandPath(bp.UserSpace.Stow.TargetDir, sess.UserHome)
+	sess.SyncBaseDir = utils.ExpandPath(bp.UserSpace.Sync.BaseDir, sess.UserHome)
+	if len(bp.UserSpace.Sync.Items) > 0 {
+		checkers = append(checkers, &ops.SyncDotfiles{BaseDir: sess.SyncBaseDir, Items: syncItems(sess), UID: sess.UID, GID: sess.GID})
+	}
+	if len(bp.UserSpace.Stow.Packages) > 0 {
+		checkers = append(checkers, &ops.StowLinks{
+			SourceDir: sess.StowSourceDir,
+			TargetDir: sess.StowTargetDir,
+			Packages:  bp.UserSpace.Stow.Packages,
+		})
+	}
+	for _, repo := range bp.UserSpace.Repos {
+		url, _, _ := config.SplitRepoURL(repo.URL)
+		checkers = append(checkers, &ops.GitClone{URL: url, Dest: utils.ExpandPath(repo.Dest, sess.UserHome), Username: sess.Username})
+	}
+
+	return checkers
+}
+
+// buildVerifyReport converts the audit Results into the report document.
+func buildVerifyReport(results []ops.Result) verifyReport {
+	report := verifyReport{CheckedAt: time.Now().UTC(), Checks: []verifyCheck{}}
+
+	for _, res := range results {
+		check := verifyCheck{Name: res.Act.Name(), Block: res.Act.Block().String(), Items: []verifyItem{}}
+		if res.Err != nil {
+			check.Error = res.Err.Error()
+			report.Errors++
+		}
+		for _, d := range res.Diffs {
+			check.Items = append(check.Items, verifyItem{Item: d.Item, OK: d.Satisfied, Detail: d.Detail})
+			if d.Satisfied {
+				report.OK++
+			} else {
+				report.Drifted++
+			}
+		}
+		report.Checks = append(report.Checks, check)
+	}
+
+	report.Drift = report.Drifted > 0
+	return report
+}
+
+// printVerify renders the report grouped by Block.
+func printVerify(report verifyReport) {
+	var block string
+	for _, check := range report.Checks {
+		if check.Block != block {
+			block = check.Block
+			fmt.Println()
+			fmt.Println(block)
+		}
+		if check.Error != "" {
+			fmt.Printf("  ! %s: %s\n", check.Name, check.Error)
+		}
+		for _, item := range check.Items {
+			if item.OK {
+				fmt.Printf("  ✓ %s: %s\n", item.Item, item.Detail)
+			} else {
+				fmt.Printf("  ✗ %s: %s\n", item.Item, item.Detail)
+			}
+		}
+	}
+
+	fmt.Println()
+	fmt.Printf("Summary: %d ok, %d drifted, %d errors\n", report.OK, report.Drifted, report.Errors)
+}
@@ -3,8 +3,14 @@ package config
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/acker1019/fedora-phoenix/internal/logging"
+	"github.com/acker1019/fedora-phoenix/internal/utils"
 	"gopkg.in/yaml.v3"
 )
 
@@ -30,14 +36,123 @@ type Blueprint struct {
 
 // InfrastructureConfig defines storage and hardware mappings
 type InfrastructureConfig struct {
-	Luks LuksConfig `yaml:"luks"`
+	Luks  LuksVolumes `yaml:"luks"`
+	Swap  SwapConfig  `yaml:"swap"`
+	Vault VaultConfig `yaml:"vault"`
 }
 
-// LuksConfig defines LUKS partition configuration
+// VaultConfig locates the second-stage secrets file on a LUKS volume,
+// loaded once the volume is mounted. An empty Path means no vault.
+type VaultConfig struct {
+	Volume string `yaml:"volume"` // infrastructure.luks mapper_name
+	Path   string `yaml:"path"`   // Relative to the volume's mount point
+}
+
+// LuksConfig defines one LUKS partition (volume)
 type LuksConfig struct {
-	Device     string `yaml:"device"`
+	Device     string `yaml:"device"`    // Path, or a uuid:/partuuid:/partlabel:/label: selector
+	LuksUUID   string `yaml:"luks_uuid"` // Optional expected LUKS header UUID (implied by uuid:)
 	MapperName string `yaml:"mapper_name"`
 	MountPoint string `yaml:"mount_point"`
+	SecretKey  string `yaml:"secret_key"` // Key in secrets luks_passwords; empty uses luks_password
+	Password   string `yaml:"password"`   // ${secret:name} reference, instead of secret_key
+	Optional   bool   `yaml:"optional"`   // Skip instead of failing when the device is absent (e.g. external disk)
+
+	// Key on removable media, tried before the password
+	Keyfile KeyfileConfig `yaml:"keyfile"`
+
+	// Filesystem
+	FSType       string `yaml:"fs_type"`       // e.g. "btrfs"; empty lets mount probe
+	MountOptions string `yaml:"mount_options"` // e.g. "noatime,compress=zstd"
+	Subvolume    string `yaml:"subvolume"`     // btrfs only
+	Fsck         bool   `yaml:"fsck"`          // Read-only health check before mounting
+
+	// Boot-time persistence via /etc/crypttab and /etc/fstab
+	Persist       bool   `yaml:"persist"`
+	DeviceTimeout string `yaml:"device_timeout"` // x-systemd.device-timeout, defaults to DefaultDeviceTimeout
+
+	// Destination of `phoenix luks backup-header` (keep it off this volume)
+	HeaderBackup string `yaml:"header_backup"`
+}
+
+// KeyfileConfig locates a LUKS key file on removable media. An empty
+// Label means the volume has no keyfile.
+type KeyfileConfig struct {
+	Label  string `yaml:"label"`  // Filesystem label of the medium (e.g. a USB stick)
+	Path   string `yaml:"path"`   // Relative to the medium's root
+	Offset int64  `yaml:"offset"` // --keyfile-offset in bytes
+	Size   int64  `yaml:"size"`   // --keyfile-size in bytes; 0 reads the whole file
+}
+
+// DefaultDeviceTimeout bounds how long boot waits for a persisted volume.
+const DefaultDeviceTimeout = "10s"
+
+// Timeout returns DeviceTimeout or DefaultDeviceTimeout.
+func (c LuksConfig) Timeout() string {
+	if c.DeviceTimeout == "" {
+		return DefaultDeviceTimeout
+	}
+	return c.DeviceTimeout
+}
+
+// LuksVolumes is the list of LUKS volumes, unlocked and mounted in order.
+// A single mapping (the original schema) is accepted as a one-volume list.
+type LuksVolumes []LuksConfig
+
+// Find returns the volume with the given mapper name. An empty name
+// selects the only volume when exactly one is defined.
+func (v LuksVolumes) Find(mapperName string) (LuksConfig, error) {
+	if mapperName == "" {
+		if len(v) != 1 {
+			return LuksConfig{}, fmt.Errorf("volume is required when more than one LUKS volume is defined")
+		}
+		return v[0], nil
+	}
+	for _, vol := range v {
+		if vol.MapperName == mapperName {
+			return vol, nil
+		}
+	}
+	return LuksConfig{}, fmt.Errorf("unknown volume %q: must be an infrastructure.luks mapper_name", mapperName)
+}
+
+// UnmarshalYAML accepts either a sequence of volumes or a single volume mapping.
+func (v *LuksVolumes) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.MappingNode {
+		var single LuksConfig
+		if err := node.Decode(&single); err != nil {
+			return err
+		}
+		*v = LuksVolumes{single}
+		return nil
+	}
+
+	var list []LuksConfig
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*v = list
+	return nil
+}
+
+// Swap types
+const (
+	SwapPartition = "partition" // Random-key encrypted partition, re-created on every boot
+	SwapFile      = "file"      // Swap file on a LUKS volume
+)
+
+// DefaultSwapMapper is the mapper name of a random-key swap partition.
+const DefaultSwapMapper = "cryptswap"
+
+// SwapConfig defines encrypted swap. An empty Type leaves swap alone.
+type SwapConfig struct {
+	Type        string `yaml:"type"`         // SwapPartition or SwapFile
+	Device      string `yaml:"device"`       // partition: path, partuuid: or partlabel: (formatted on every boot!)
+	MapperName  string `yaml:"mapper_name"`  // partition: defaults to DefaultSwapMapper
+	Volume      string `yaml:"volume"`       // file: infrastructure.luks mapper_name holding the file
+	Path        string `yaml:"path"`         // file: relative to the volume's mount point
+	Size        string `yaml:"size"`         // file: e.g. "8G"
+	DisableZram bool   `yaml:"disable_zram"` // Turn off Fedora's default zram swap
 }
 
 // SystemConfig defines OS-level state
@@ -55,11 +170,98 @@ type IdentityConfig struct {
 
 // UserSpaceConfig defines user-level configuration (Block IV)
 type UserSpaceConfig struct {
-	Stow  StowConfig   `yaml:"stow"`
-	Repos []RepoConfig `yaml:"repos"`
+	Projections []ProjectionConfig `yaml:"projections"`
+	Stow        StowConfig         `yaml:"stow"` // Deprecated: superseded by Sync (ADR-0007)
+	Sync        SyncConfig         `yaml:"sync"`
+	Repos       []RepoConfig       `yaml:"repos"`
+	Files       []FileConfig       `yaml:"files"`       // Files written from the blueprint, e.g. with secrets
+	Environment map[string]string  `yaml:"environment"` // Session variables (~/.config/environment.d)
+}
+
+// ProjectionConfig maps a directory of a LUKS volume into the home directory
+type ProjectionConfig struct {
+	Volume      string `yaml:"volume"`       // mapper_name of the volume; may be omitted with a single volume
+	Src         string `yaml:"src"`          // Relative to the volume's mount point
+	Dest        string `yaml:"dest"`         // Target path, "~" is expanded
+	Method      string `yaml:"method"`       // "bind" (default) or "symlink"
+	AllowShadow bool   `yaml:"allow_shadow"` // Project over a non-empty directory
+}
+
+// SyncConfig defines the physical-copy dotfiles synchronization (ADR-0007)
+type SyncConfig struct {
+	BaseDir         string     `yaml:"base_dir"`
+	Items           []SyncItem `yaml:"items"`
+	HarvestInterval string     `yaml:"harvest_interval"` // Poll interval of `harvest --watch` (e.g. "5m"), defaults to DefaultHarvestInterval
+}
+
+// DefaultHarvestInterval is the harvest daemon's poll interval (ADR-0007).
+const DefaultHarvestInterval = 5 * time.Minute
+
+// Interval parses HarvestInterval, falling back to DefaultHarvestInterval.
+func (c SyncConfig) Interval() (time.Duration, error) {
+	if c.HarvestInterval == "" {
+		return DefaultHarvestInterval, nil
+	}
+	d, err := time.ParseDuration(c.HarvestInterval)
+	if err != nil || d < time.Second {
+		return 0, fmt.Errorf("invalid harvest_interval %q: must be a duration of at least 1s like \"5m\"", c.HarvestInterval)
+	}
+	return d, nil
+}
+
+// SyncItem maps a file or directory in BaseDir onto the system
+type SyncItem struct {
+	Src          string `yaml:"src"`           // Relative to BaseDir; a trailing "/" is optional for directories
+	Dest         string `yaml:"dest"`          // Target path, "~" is expanded
+	DefaultChmod string `yaml:"default_chmod"` // Optional octal mode (e.g. "0600"); source mode is kept if empty
+}
+
+// Mode parses DefaultChmod. It returns 0 when no default is set.
+func (i SyncItem) Mode() (os.FileMode, error) {
+	if i.DefaultChmod == "" {
+		return 0, nil
+	}
+	mode, ok := parseMode(i.DefaultChmod)
+	if !ok {
+		return 0, fmt.Errorf("invalid default_chmod %q: must be an octal mode like \"0600\"", i.DefaultChmod)
+	}
+	return mode, nil
+}
+
+// parseMode parses an octal permission mode like "0600".
+func parseMode(s string) (os.FileMode, bool) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, false
+	}
+	return os.FileMode(mode), true
+}
+
+// FileConfig is a file written with fixed content. Content may reference
+// secrets as ${secret:name}, which is why files default to mode 0600.
+type FileConfig struct {
+	Dest    string `yaml:"dest"`    // Target path, "~" is expanded
+	Content string `yaml:"content"` // Written verbatim after resolving references
+	Chmod   string `yaml:"chmod"`   // Optional octal mode, defaults to DefaultFileMode
+}
+
+// DefaultFileMode is the mode of userspace.files without chmod.
+const DefaultFileMode os.FileMode = 0600
+
+// Mode parses Chmod, falling back to DefaultFileMode.
+func (f FileConfig) Mode() (os.FileMode, error) {
+	if f.Chmod == "" {
+		return DefaultFileMode, nil
+	}
+	mode, ok := parseMode(f.Chmod)
+	if !ok {
+		return 0, fmt.Errorf("invalid chmod %q: must be an octal mode like \"0600\"", f.Chmod)
+	}
+	return mode, nil
 }
 
 // StowConfig defines GNU Stow deployment configuration
+// Deprecated: ADR-0007 replaces Stow symlinks with SyncConfig.
 type StowConfig struct {
 	SourceDir string   `yaml:"source_dir"`
 	TargetDir string   `yaml:"target_dir"`
@@ -109,14 +311,14 @@ func validateBlueprint(bp *Blueprint) error {
 	}
 
 	// Validate Infrastructure
-	if bp.Infrastructure.Luks.Device == "" {
-		return fmt.Errorf("infrastructure.luks.device is required")
+	if err := validateLuks(bp.Infrastructure.Luks); err != nil {
+		return err
 	}
-	if bp.Infrastructure.Luks.MapperName == "" {
-		return fmt.Errorf("infrastructure.luks.mapper_name is required")
+	if err := validateSwap(bp); err != nil {
+		return err
 	}
-	if bp.Infrastructure.Luks.MountPoint == "" {
-		return fmt.Errorf("infrastructure.luks.mount_point is required")
+	if err := validateVault(bp); err != nil {
+		return err
 	}
 
 	// Validate Identity
@@ -124,5 +326,230 @@ func validateBlueprint(bp *Blueprint) error {
 		return fmt.Errorf("identity.username is required")
 	}
 
+	// Validate User Space
+	if err := validateProjections(bp); err != nil {
+		return err
+	}
+	if err := validateSync(&bp.UserSpace.Sync); err != nil {
+		return err
+	}
+	if err := validateFiles(bp); err != nil {
+		return err
+	}
+	if err := validateSecretRefs(bp); err != nil {
+		return err
+	}
+	if len(bp.UserSpace.Stow.Packages) > 0 {
+		blueprintLog.Warn("userspace.stow is deprecated (ADR-0007), migrate to userspace.sync")
+	}
+
+	return nil
+}
+
+// validateLuks ensures every volume is complete and mapper names and
+// mount points are unique
+func validateLuks(volumes LuksVolumes) error {
+	if len(volumes) == 0 {
+		return fmt.Errorf("infrastructure.luks requires at least one volume")
+	}
+
+	mappers := make(map[string]bool)
+	mounts := make(map[string]bool)
+	for i, vol := range volumes {
+		if vol.Device == "" {
+			return fmt.Errorf("infrastructure.luks[%d].device is required", i)
+		}
+		if _, err := utils.DeviceSpecPath(vol.Device); err != nil {
+			return fmt.Errorf("infrastructure.luks[%d]: %w", i, err)
+		}
+		if vol.MapperName == "" {
+			return fmt.Errorf("infrastructure.luks[%d].mapper_name is required", i)
+		}
+		if vol.MountPoint == "" {
+			return fmt.Errorf("infrastructure.luks[%d].mount_point is required", i)
+		}
+		if vol.DeviceTimeout != "" {
+			if _, err := time.ParseDuration(vol.DeviceTimeout); err != nil {
+				return fmt.Errorf("infrastructure.luks[%d]: invalid device_timeout %q: must be a duration like \"10s\"", i, vol.DeviceTimeout)
+			}
+		}
+		if strings.ContainsAny(vol.MountOptions, " \t") {
+			return fmt.Errorf("infrastructure.luks[%d]: mount_options must be comma-separated without spaces", i)
+		}
+		if err := validateKeyfile(vol.Keyfile); err != nil {
+			return fmt.Errorf("infrastructure.luks[%d].keyfile: %w", i, err)
+		}
+		if vol.HeaderBackup != "" {
+			if !filepath.IsAbs(vol.HeaderBackup) {
+				return fmt.Errorf("infrastructure.luks[%d]: header_backup must be an absolute path", i)
+			}
+			if rel, err := filepath.Rel(vol.MountPoint, vol.HeaderBackup); err == nil && filepath.IsLocal(rel) {
+				return fmt.Errorf("infrastructure.luks[%d]: header_backup must not be on the volume it backs up", i)
+			}
+		}
+		if vol.Subvolume != "" && vol.FSType != "btrfs" {
+			return fmt.Errorf("infrastructure.luks[%d]: subvolume requires fs_type: btrfs", i)
+		}
+		if mappers[vol.MapperName] {
+			return fmt.Errorf("infrastructure.luks[%d]: duplicate mapper_name %q", i, vol.MapperName)
+		}
+		if mounts[vol.MountPoint] {
+			return fmt.Errorf("infrastructure.luks[%d]: duplicate mount_point %q", i, vol.MountPoint)
+		}
+		mappers[vol.MapperName] = true
+		mounts[vol.MountPoint] = true
+	}
+	return nil
+}
+
+// validateKeyfile ensures a keyfile names its medium and a path inside it
+func validateKeyfile(k KeyfileConfig) error {
+	if k == (KeyfileConfig{}) {
+		return nil
+	}
+	if k.Label == "" || k.Path == "" {
+		return fmt.Errorf("label and path are required")
+	}
+	if filepath.IsAbs(k.Path) || !filepath.IsLocal(k.Path) {
+		return fmt.Errorf("path %q must be relative to the medium's root", k.Path)
+	}
+	if k.Offset < 0 || k.Size < 0 {
+		return fmt.Errorf("offset and size must not be negative")
+	}
+	return nil
+}
+
+// swapSize matches the sizes fallocate and btrfs both accept (e.g. "8G")
+var swapSize = regexp.MustCompile(`^[1-9][0-9]*[KMGT]?$`)
+
+// validateSwap ensures the swap section is complete for its type and
+// that a partition is addressed by something mkswap does not rewrite
+func validateSwap(bp *Blueprint) error {
+	swap := &bp.Infrastructure.Swap
+	switch swap.Type {
+	case "":
+		return nil
+	case SwapPartition:
+		if swap.Device == "" {
+			return fmt.Errorf("infrastructure.swap.device is required for type %q", SwapPartition)
+		}
+		if _, err := utils.DeviceSpecPath(swap.Device); err != nil {
+			return fmt.Errorf("infrastructure.swap: %w", err)
+		}
+		if strings.HasPrefix(swap.Device, "uuid:") || strings.HasPrefix(swap.Device, "label:") {
+			return fmt.Errorf("infrastructure.swap: device %q changes on every boot (the partition is re-formatted): use partuuid:, partlabel: or a path", swap.Device)
+		}
+		if swap.MapperName == "" {
+			swap.MapperName = DefaultSwapMapper
+		}
+		if _, err := bp.Infrastructure.Luks.Find(swap.MapperName); err == nil {
+			return fmt.Errorf("infrastructure.swap: mapper_name %q is already used by a LUKS volume", swap.MapperName)
+		}
+	case SwapFile:
+		if swap.Path == "" || swap.Size == "" {
+			return fmt.Errorf("infrastructure.swap: path and size are required for type %q", SwapFile)
+		}
+		if filepath.IsAbs(swap.Path) || !filepath.IsLocal(swap.Path) {
+			return fmt.Errorf("infrastructure.swap: path %q must be relative to the volume's mount point", swap.Path)
+		}
+		if !swapSize.MatchString(swap.Size) {
+			return fmt.Errorf("infrastructure.swap: invalid size %q: use a number with an optional K/M/G/T suffix", swap.Size)
+		}
+		if _, err := bp.Infrastructure.Luks.Find(swap.Volume); err != nil {
+			return fmt.Errorf("infrastructure.swap: %w", err)
+		}
+	default:
+		return fmt.Errorf("infrastructure.swap: type must be %q or %q, got %q", SwapPartition, SwapFile, swap.Type)
+	}
+	return nil
+}
+
+// validateVault ensures the vault names a known volume and a path inside it
+func validateVault(bp *Blueprint) error {
+	vault := bp.Infrastructure.Vault
+	if vault.Path == "" {
+		if vault.Volume != "" {
+			return fmt.Errorf("infrastructure.vault.path is required")
+		}
+		return nil
+	}
+	if filepath.IsAbs(vault.Path) || !filepath.IsLocal(vault.Path) {
+		return fmt.Errorf("infrastructure.vault: path %q must be relative to the volume's mount point", vault.Path)
+	}
+	if _, err := bp.Infrastructure.Luks.Find(vault.Volume); err != nil {
+		return fmt.Errorf("infrastructure.vault: %w", err)
+	}
+	return nil
+}
+
+// validateProjections ensures every projection names a known volume,
+// a relative source inside it and a supported method
+func validateProjections(bp *Blueprint) error {
+	for i := range bp.UserSpace.Projections {
+		p := &bp.UserSpace.Projections[i]
+		if p.Src == "" || p.Dest == "" {
+			return fmt.Errorf("userspace.projections[%d]: src and dest are required", i)
+		}
+		if filepath.IsAbs(p.Src) || !filepath.IsLocal(p.Src) {
+			return fmt.Errorf("userspace.projections[%d]: src %q must be relative to the volume's mount point", i, p.Src)
+		}
+		switch p.Method {
+		case "":
+			p.Method = "bind"
+		case "bind", "symlink":
+		default:
+			return fmt.Errorf("userspace.projections[%d]: method must be \"bind\" or \"symlink\", got %q", i, p.Method)
+		}
+		if _, err := bp.Infrastructure.Luks.Find(p.Volume); err != nil {
+			return fmt.Errorf("userspace.projections[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// envName matches the variable names environment.d accepts
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateFiles ensures every file has a destination and a valid mode,
+// and that environment variables have valid single-line values
+func validateFiles(bp *Blueprint) error {
+	for i, file := range bp.UserSpace.Files {
+		if file.Dest == "" {
+			return fmt.Errorf("userspace.files[%d].dest is required", i)
+		}
+		if _, err := file.Mode(); err != nil {
+			return fmt.Errorf("userspace.files[%d]: %w", i, err)
+		}
+	}
+	for key, value := range bp.UserSpace.Environment {
+		if !envName.MatchString(key) {
+			return fmt.Errorf("userspace.environment: invalid variable name %q", key)
+		}
+		if strings.Contains(value, "\n") {
+			return fmt.Errorf("userspace.environment.%s: value must be a single line", key)
+		}
+	}
+	return nil
+}
+
+// validateSync ensures every sync item is complete and has a valid mode
+func validateSync(sync *SyncConfig) error {
+	if len(sync.Items) == 0 {
+		return nil
+	}
+	if sync.BaseDir == "" {
+		return fmt.Errorf("userspace.sync.base_dir is required when items are defined")
+	}
+	for i, item := range sync.Items {
+		if item.Src == "" || item.Dest == "" {
+			return fmt.Errorf("userspace.sync.items[%d]: src and dest are required", i)
+		}
+		if _, err := item.Mode(); err != nil {
+			return fmt.Errorf("userspace.sync.items[%d]: %w", i, err)
+		}
+	}
+	if _, err := sync.Interval(); err != nil {
+		return fmt.Errorf("userspace.sync: %w", err)
+	}
 	return nil
 }
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"gopkg.in/yaml.v3"
+)
+
+// BundleVersion is the format version of sealed secrets bundles.
+const BundleVersion = 1
+
+// Argon2id parameters of new bundles (RFC 9106, second recommended option).
+// Bundles record their own parameters, so these may grow without
+// breaking old bundles.
+const (
+	bundleTime    = 3
+	bundleMemory  = 64 * 1024 // KiB
+	bundleThreads = 4
+	bundleSaltLen = 16
+
+	maxBundleMemory = 4 * 1024 * 1024 // KiB; refuse bundles that would exhaust memory
+)
+
+// ErrBadPassphrase is returned when a bundle does not decrypt: the
+// passphrase is wrong or the bundle was modified.
+var ErrBadPassphrase = errors.New("wrong passphrase or corrupted bundle")
+
+// Bundle is a passphrase-encrypted secrets file. The plaintext secrets
+// YAML is encrypted with XChaCha20-Poly1305 under a key derived by Argon2id;
+// the header fields are authenticated as associated data.
+type Bundle struct {
+	Version int       `yaml:"phoenix_secrets"`
+	KDF     BundleKDF `yaml:"kdf"`
+	Cipher  string    `yaml:"cipher"`
+	Nonce   string    `yaml:"nonce"` // base64
+	Data    string    `yaml:"data"`  // base64 ciphertext
+}
+
+// BundleKDF records the key derivation of a bundle.
+type BundleKDF struct {
+	Name    string `yaml:"name"`
+	Time    uint32 `yaml:"time"`
+	Memory  uint32 `yaml:"memory"` // KiB
+	Threads uint8  `yaml:"threads"`
+	Salt    string `yaml:"salt"` // base64
+}
+
+const (
+	bundleKDFName    = "argon2id"
+	bundleCipherName = "xchacha20-poly1305"
+)
+
+// IsBundle reports whether data is a sealed bundle rather than plain secrets YAML.
+func IsBundle(data []byte) bool {
+	var probe struct {
+		Version int `yaml:"phoenix_secrets"`
+	}
+	return yaml.Unmarshal(data, &probe) == nil && probe.Version > 0
+}
+
+// SealSecrets encrypts plaintext secrets YAML under passphrase.
+func SealSecrets(plaintext, passphrase []byte) ([]byte, error) {
+	salt := make([]byte, bundleSaltLen)
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	b := Bundle{
+		Version: BundleVersion,
+		KDF: BundleKDF{
+			Name:    bundleKDFName,
+			Time:    bundleTime,
+			Memory:  bundleMemory,
+			Threads: bundleThreads,
+			Salt:    base64.StdEncoding.EncodeToString(salt),
+		},
+		Cipher: bundleCipherName,
+		Nonce:  base64.StdEncoding.EncodeToString(nonce),
+	}
+
+	aead, err := b.aead(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	b.Data = base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, plaintext, b.associatedData()))
+	return yaml.Marshal(&b)
+}
+
+// OpenSecrets decrypts a bundle produced by SealSecrets.
+func OpenSecrets(data, passphrase []byte) ([]byte, error) {
+	var b Bundle
+	if err := yaml.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("failed to parse bundle: %w", err)
+	}
+	if b.Version != BundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", b.Version)
+	}
+	if b.KDF.Name != bundleKDFName || b.Cipher != bundleCipherName {
+		return nil, fmt.Errorf("unsupported bundle algorithms %s/%s", b.KDF.Name, b.Cipher)
+	}
+	if b.KDF.Time == 0 || b.KDF.Memory == 0 || b.KDF.Memory > maxBundleMemory || b.KDF.Threads == 0 {
+		return nil, fmt.Errorf("invalid bundle KDF parameters")
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(b.KDF.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundle salt: %w", err)
+	}
+	nonce, err := base64.StdEncoding.DecodeString(b.Nonce)
+	if err != nil || len(nonce) != chacha20poly1305.NonceSizeX {
+		return nil, fmt.Errorf("invalid bundle nonce")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(b.Data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundle data: %w", err)
+	}
+
+	aead, err := b.aead(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, b.associatedData())
+	if err != nil {
+		return nil, ErrBadPassphrase
+	}
+	return plaintext, nil
+}
+
+// aead derives the bundle key from passphrase with the recorded parameters.
+func (b *Bundle) aead(passphrase, salt []byte) (cipher.AEAD, error) {
+	key := argon2.IDKey(passphrase, salt, b.KDF.Time, b.KDF.Memory, b.KDF.Threads, chacha20poly1305.KeySize)
+	defer clear(key) // NewX keeps its own copy
+	return chacha20poly1305.NewX(key)
+}
+
+// associatedData binds the header to the ciphertext, so parameters cannot
+// be swapped without failing authentication.
+func (b *Bundle) associatedData() []byte {
+	return fmt.Appendf(nil, "phoenix-secrets/%d %s t=%d m=%d p=%d salt=%s %s",
+		b.Version, b.KDF.Name, b.KDF.Time, b.KDF.Memory, b.KDF.Threads, b.KDF.Salt, b.Cipher)
+}
@@ -0,0 +1,158 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/acker1019/fedora-phoenix/internal/secret"
+)
+
+// secretRef matches a ${secret:name} reference in a blueprint field
+var secretRef = regexp.MustCompile(`\$\{secret:([^}]*)\}`)
+
+// wholeSecretRef matches a value that is exactly one reference
+var wholeSecretRef = regexp.MustCompile(`^\$\{secret:[^}]*\}$`)
+
+// secretRefName matches the names a reference may use: a secrets map
+// entry, or a fixed section and its key (e.g. "wifi_keys.Office WiFi")
+var secretRefName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_-]*(\.[^}]+)?$`)
+
+// repoCredentialURL matches a repository URL whose password is a single
+// reference: scheme://user:${secret:name}@host/path
+var repoCredentialURL = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9+.-]*://)([^:@/]+):(\$\{secret:[^}]*\})@(.+)$`)
+
+// SecretRef is a ${secret:name} reference found in the blueprint.
+type SecretRef struct {
+	Field      string // Blueprint field, e.g. "userspace.repos[0].url"
+	Name       string // Referenced secret
+	SingleLine bool   // The field cannot hold a multi-line value
+}
+
+// SecretRefs lists the references of every field that may hold them:
+// LUKS passwords, repository URLs, file contents and environment variables.
+func (bp *Blueprint) SecretRefs() []SecretRef {
+	var refs []SecretRef
+	for i, vol := range bp.Infrastructure.Luks {
+		refs = append(refs, findSecretRefs(fmt.Sprintf("infrastructure.luks[%d].password", i), vol.Password, true)...)
+	}
+	for i, repo := range bp.UserSpace.Repos {
+		refs = append(refs, findSecretRefs(fmt.Sprintf("userspace.repos[%d].url", i), repo.URL, true)...)
+	}
+	for i, file := range bp.UserSpace.Files {
+		refs = append(refs, findSecretRefs(fmt.Sprintf("userspace.files[%d].content", i), file.Content, false)...)
+	}
+	for _, key := range slices.Sorted(maps.Keys(bp.UserSpace.Environment)) {
+		refs = append(refs, findSecretRefs("userspace.environment."+key, bp.UserSpace.Environment[key], true)...)
+	}
+	return refs
+}
+
+// findSecretRefs lists the references in the value of field.
+func findSecretRefs(field, value string, singleLine bool) []SecretRef {
+	var refs []SecretRef
+	for _, m := range secretRef.FindAllStringSubmatch(value, -1) {
+		refs = append(refs, SecretRef{Field: field, Name: m[1], SingleLine: singleLine})
+	}
+	return refs
+}
+
+// CheckRefs ensures every reference resolves, and that single-line fields
+// do not reference multi-line secrets. All problems are reported at once.
+func (s *Secrets) CheckRefs(refs []SecretRef) error {
+	var problems []string
+	for _, ref := range refs {
+		value, ok := s.Lookup(ref.Name)
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s: secret %q is not defined", ref.Field, ref.Name))
+		case ref.SingleLine && bytes.Contains(value.Bytes(), []byte("\n")):
+			problems = append(problems, fmt.Sprintf("%s: secret %q spans several lines", ref.Field, ref.Name))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("unresolved secret references:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// Expand replaces every ${secret:name} reference in value. Without
+// secrets (e.g. dry-run), references are kept as they are, so that
+// plans show the reference rather than failing. The result is a plain
+// string; consumers that can take a *secret.Value should use Lookup.
+func (s *Secrets) Expand(value string) (string, error) {
+	if s == nil {
+		return value, nil
+	}
+	var missing []string
+	expanded := secretRef.ReplaceAllStringFunc(value, func(ref string) string {
+		name := secretRef.FindStringSubmatch(ref)[1]
+		secret, ok := s.Lookup(name)
+		if !ok {
+			missing = append(missing, name)
+			return ref
+		}
+		return string(secret.Bytes())
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("secret %q is not defined", missing[0])
+	}
+	return expanded, nil
+}
+
+// Resolve returns the secret of a value that is exactly one reference
+// (e.g. a LUKS password), without ever turning it into a string.
+func (s *Secrets) Resolve(ref string) (*secret.Value, error) {
+	m := secretRef.FindStringSubmatch(ref)
+	if m == nil || !wholeSecretRef.MatchString(ref) {
+		return nil, fmt.Errorf("%q is not a ${secret:name} reference", ref)
+	}
+	value, ok := s.Lookup(m[1])
+	if !ok {
+		return nil, fmt.Errorf("secret %q is not defined", m[1])
+	}
+	return value, nil
+}
+
+// SplitRepoURL splits a repository URL of the form
+// scheme://user:${secret:name}@host/path into the URL git is given, without
+// credentials, and the user and password reference of its userinfo. A URL
+// without a reference is returned as is, with an empty user and ref.
+func SplitRepoURL(raw string) (url, user, ref string) {
+	m := repoCredentialURL.FindStringSubmatch(raw)
+	if m == nil {
+		return raw, "", ""
+	}
+	return m[1] + m[4], m[2], m[3]
+}
+
+// validateSecretRefs ensures references are well-formed and that a LUKS
+// password is given only as a reference
+func validateSecretRefs(bp *Blueprint) error {
+	for i, vol := range bp.Infrastructure.Luks {
+		if vol.Password == "" {
+			continue
+		}
+		if !wholeSecretRef.MatchString(vol.Password) {
+			return fmt.Errorf("infrastructure.luks[%d]: password must be a single ${secret:name} reference, never the password itself", i)
+		}
+		if vol.SecretKey != "" {
+			return fmt.Errorf("infrastructure.luks[%d]: password and secret_key are mutually exclusive", i)
+		}
+	}
+	// A token in a clone URL would end up in git's argv and .git/config
+	for i, repo := range bp.UserSpace.Repos {
+		if url, _, _ := SplitRepoURL(repo.URL); secretRef.MatchString(url) {
+			return fmt.Errorf("userspace.repos[%d].url: a ${secret:name} reference is only allowed as the password, as in https://user:${secret:name}@host/path", i)
+		}
+	}
+	for _, ref := range bp.SecretRefs() {
+		if !secretRefName.MatchString(ref.Name) {
+			return fmt.Errorf("%s: invalid secret reference ${secret:%s}", ref.Field, ref.Name)
+		}
+	}
+	return nil
+}
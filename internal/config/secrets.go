@@ -1,11 +1,23 @@
 package config
 
 import (
+	"bytes"
 	"crypto/rand"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"syscall"
 
 	"github.com/acker1019/fedora-phoenix/internal/logging"
+	"github.com/acker1019/fedora-phoenix/internal/secret"
+	"github.com/acker1019/fedora-phoenix/internal/utils"
+	"golang.org/x/term"
 	"gopkg.in/yaml.v3"
 )
 
@@ -13,99 +25,518 @@ var log = logging.WithSource("secrets")
 
 // Secrets defines the schema for the secrets configuration file.
 // We use YAML tags here to map keys from the input file.
+//
+// Every value is a *secret.Value in locked memory; Destroy zeroes them
+// once the run no longer needs them.
 type Secrets struct {
-	LuksPassword string `yaml:"luks_password"`
-	// Add other secrets here as needed, e.g.:
-	// GitToken     string `yaml:"git_token"`
-	// RootPassword string `yaml:"root_password"`
+	LuksPassword  *secret.Value            `yaml:"luks_password"`  // Default password for volumes without secret_key
+	LuksPasswords map[string]*secret.Value `yaml:"luks_passwords"` // Per-volume passwords, keyed by secret_key
+
+	// Second-stage secrets, usually kept in the vault on the LUKS volume (see LoadVault)
+	Tokens         map[string]*secret.Value `yaml:"tokens"`          // API tokens, keyed by service (e.g. github)
+	SSHPassphrases map[string]*secret.Value `yaml:"ssh_passphrases"` // Keyed by key file name (e.g. id_ed25519)
+	WifiKeys       map[string]*secret.Value `yaml:"wifi_keys"`       // Keyed by SSID
+
+	// Named secrets of any type, referenced from the blueprint as ${secret:name}
+	Named map[string]NamedSecret `yaml:"secrets"`
+
+	sealed bool // Loaded from a passphrase-encrypted bundle
+}
+
+// Sealed reports whether the secrets came from an encrypted bundle,
+// which is safe to keep and need not be destroyed after the run.
+func (s *Secrets) Sealed() bool {
+	return s.sealed
+}
+
+// Named secret types
+const (
+	SecretString    = "string"    // Single line (default)
+	SecretMultiline = "multiline" // Kept verbatim, e.g. a PEM private key
+	SecretFile      = "file"      // Base64-encoded file content, decoded on load
+)
+
+// NamedSecret is an entry of the secrets map. A plain string is
+// shorthand for a secret of type string.
+type NamedSecret struct {
+	Type  string        `yaml:"type"`
+	Value *secret.Value `yaml:"value"`
+}
+
+// UnmarshalYAML accepts either a scalar value or a type/value mapping.
+func (n *NamedSecret) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*n = NamedSecret{Type: SecretString, Value: &secret.Value{}}
+		return n.Value.UnmarshalYAML(node)
+	}
+	type plain NamedSecret
+	return node.Decode((*plain)(n))
+}
+
+// secretName matches the names of the secrets map
+var secretName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_-]*$`)
+
+// validateNamed checks names and types of the secrets map and decodes
+// file secrets. Errors never include a value.
+func (s *Secrets) validateNamed() error {
+	for _, name := range slices.Sorted(maps.Keys(s.Named)) {
+		named := s.Named[name]
+		if !secretName.MatchString(name) {
+			return fmt.Errorf("secrets.%s: names may only contain letters, digits, '_' and '-'", name)
+		}
+		value := named.Value.Bytes()
+		switch named.Type {
+		case "", SecretString:
+			named.Type = SecretString
+			if line := bytes.TrimSuffix(value, []byte("\n")); len(line) < len(value) {
+				// Copy line before Destroy unmaps the memory it points into
+				trimmed := secret.New(bytes.Clone(line))
+				named.Value.Destroy()
+				named.Value = trimmed
+				s.Named[name] = named // Destroyed with the others if validation fails
+			}
+			if bytes.Contains(named.Value.Bytes(), []byte("\n")) {
+				return fmt.Errorf("secrets.%s: a string secret must be a single line (use type: %s)", name, SecretMultiline)
+			}
+		case SecretMultiline:
+		case SecretFile:
+			encoded := bytes.Join(bytes.Fields(value), nil)
+			content := make([]byte, base64.StdEncoding.DecodedLen(len(encoded)))
+			n, err := base64.StdEncoding.Decode(content, encoded)
+			clear(encoded)
+			if err != nil {
+				clear(content)
+				return fmt.Errorf("secrets.%s: value of a file secret must be base64", name)
+			}
+			named.Value.Destroy()
+			named.Value = secret.New(content[:n])
+			clear(content)
+			s.Named[name] = named
+		default:
+			return fmt.Errorf("secrets.%s: type must be %q, %q or %q, got %q", name, SecretString, SecretMultiline, SecretFile, named.Type)
+		}
+		if named.Value.Empty() {
+			return fmt.Errorf("secrets.%s: value is empty", name)
+		}
+		s.Named[name] = named
+	}
+	return nil
+}
+
+// Lookup returns the secret a ${secret:name} reference resolves to: an
+// entry of the secrets map, or a dotted key into one of the fixed
+// sections (e.g. tokens.github, luks_passwords.data) or luks_password.
+func (s *Secrets) Lookup(name string) (*secret.Value, bool) {
+	if s == nil {
+		return nil, false
+	}
+	if named, ok := s.Named[name]; ok {
+		return named.Value, true
+	}
+	if name == "luks_password" {
+		return s.LuksPassword, !s.LuksPassword.Empty()
+	}
+
+	section, key, _ := strings.Cut(name, ".")
+	var values map[string]*secret.Value
+	switch section {
+	case "luks_passwords":
+		values = s.LuksPasswords
+	case "tokens":
+		values = s.Tokens
+	case "ssh_passphrases":
+		values = s.SSHPassphrases
+	case "wifi_keys":
+		values = s.WifiKeys
+	}
+	value, ok := values[key]
+	return value, ok && !value.Empty()
+}
+
+// Add stores value as the string secret name, e.g. one asked for on the
+// terminal, destroying the value it replaces. Lookup finds it under name
+// even when name is of a fixed section (e.g. "tokens.github").
+func (s *Secrets) Add(name string, value *secret.Value) {
+	if s.Named == nil {
+		s.Named = make(map[string]NamedSecret)
+	}
+	if old, ok := s.Named[name]; ok {
+		old.Value.Destroy()
+	}
+	s.Named[name] = NamedSecret{Type: SecretString, Value: value}
+}
+
+// Destroy zeroes every secret. Secrets must not be used afterwards.
+func (s *Secrets) Destroy() {
+	if s == nil {
+		return
+	}
+	s.LuksPassword.Destroy()
+	for _, values := range []map[string]*secret.Value{s.LuksPasswords, s.Tokens, s.SSHPassphrases, s.WifiKeys} {
+		for _, value := range values {
+			value.Destroy()
+		}
+	}
+	for _, named := range s.Named {
+		named.Value.Destroy()
+	}
+}
+
+// bundleAttempts bounds the passphrase tries when opening a bundle.
+const bundleAttempts = 3
+
+// StdinSecrets is the secrets path that reads them from stdin.
+const StdinSecrets = "-"
+
+// IsSecretsStream reports whether path is stdin or an inherited file
+// descriptor (e.g. /dev/fd/3 or bash's <(...)), which is read but never
+// cleaned up.
+func IsSecretsStream(path string) bool {
+	return path == StdinSecrets || path == "/dev/stdin" ||
+		strings.HasPrefix(path, "/dev/fd/") || strings.HasPrefix(path, "/proc/self/fd/")
 }
 
-// LoadSecrets reads and parses the secrets YAML file from the given path.
-func LoadSecrets(path string) (*Secrets, error) {
+// LoadSecrets reads and parses the secrets YAML file from the given path,
+// or from stdin for StdinSecrets. A file must be owned by root or uid and
+// not be accessible by group or others.
+// A sealed bundle (see SealSecrets) is decrypted in memory after asking
+// for its passphrase on the terminal; the plaintext never touches the disk.
+func LoadSecrets(path string, uid int) (*Secrets, error) {
 	log.Infof("Loading secrets from local file: %s", path)
 
-	// 1. Sanity check: file existence
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return nil, fmt.Errorf("secret file not found at: %s", path)
+	data, sealed, err := readSecretsFile(path, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(data)
+
+	book, err := ParseSecrets(data)
+	if err != nil {
+		return nil, err
+	}
+	book.sealed = sealed
+	if !sealed {
+		warnIneffectiveCleanup(path)
+	}
+	return book, nil
+}
+
+// readSecretsFile reads a secrets file, decrypting a sealed bundle in memory.
+func readSecretsFile(path string, uid int) ([]byte, bool, error) {
+	// 1. Sanity check: file existence, ownership and mode
+	file, err := openSecretsFile(path, uid)
+	if err != nil {
+		return nil, false, err
 	}
+	defer file.Close()
 
 	// 2. Read file content
-	data, err := os.ReadFile(path)
+	data, err := readSecretsData(file)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// 3. Decrypt a sealed bundle in memory
+	if !IsBundle(data) {
+		return data, false, nil
+	}
+	plaintext, err := openBundle(path, data)
 	if err != nil {
+		return nil, false, err
+	}
+	return plaintext, true, nil
+}
+
+// maxSecretsSize bounds the buffer of a secrets file, which a stream
+// cannot tell the size of in advance.
+const maxSecretsSize = 1 << 20
+
+// readSecretsData reads file into a single buffer, allocated once: growing
+// it (as io.ReadAll does) would leave copies of the plaintext on the heap.
+// A regular file is read at its size; a stream into a buffer of
+// maxSecretsSize. The buffer is cleared if the read fails.
+func readSecretsData(file *os.File) ([]byte, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	size := int64(maxSecretsSize)
+	if info.Mode().IsRegular() {
+		if info.Size() > maxSecretsSize {
+			return nil, fmt.Errorf("secrets file is larger than %d bytes", maxSecretsSize)
+		}
+		size = info.Size()
+	}
+
+	// One byte more than expected tells a file that grew from one that did not
+	buf := make([]byte, size+1)
+	n, err := io.ReadFull(file, buf)
+	switch {
+	case err == nil:
+		clear(buf)
+		if info.Mode().IsRegular() {
+			return nil, fmt.Errorf("secrets file changed while it was read")
+		}
+		return nil, fmt.Errorf("secrets file is larger than %d bytes", maxSecretsSize)
+	case !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF):
+		clear(buf)
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
+	return buf[:n], nil
+}
+
+// openSecretsFile opens path, or stdin for StdinSecrets. It checks the
+// opened file rather than the path, so that it cannot be swapped in between.
+func openSecretsFile(path string, uid int) (*os.File, error) {
+	file := os.Stdin
+	if path != StdinSecrets {
+		var err error
+		if file, err = os.Open(path); err != nil {
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("secret file not found at: %s", path)
+			}
+			return nil, fmt.Errorf("failed to open file: %w", err)
+		}
+	} else if term.IsTerminal(int(file.Fd())) {
+		return nil, fmt.Errorf("--secrets=%s reads stdin, which is a terminal: pipe the secrets file in", StdinSecrets)
+	}
+
+	if err := checkSecretsFile(path, file, uid); err != nil {
+		if file != os.Stdin {
+			file.Close()
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+// checkSecretsFile refuses regular files that another user could read or
+// have written. Pipes and other streams are private to the process.
+func checkSecretsFile(path string, file *os.File, uid int) error {
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	if perm := info.Mode().Perm(); perm&0066 != 0 {
+		if IsSecretsStream(path) {
+			return fmt.Errorf("the file behind %s is readable or writable by group or others (mode %04o): chmod 600 it", path, perm)
+		}
+		return fmt.Errorf("%s is readable or writable by group or others (mode %04o): run chmod 600 %s", path, perm, path)
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && st.Uid != 0 && int(st.Uid) != uid {
+		return fmt.Errorf("%s is owned by UID %d, expected root or UID %d", path, st.Uid, uid)
+	}
+	return nil
+}
+
+// warnIneffectiveCleanup warns, before the run, that CleanupSecrets will
+// not be able to destroy the content of a plaintext file.
+func warnIneffectiveCleanup(path string) {
+	if IsSecretsStream(path) {
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	if problem := overwriteProblem(file); problem != "" {
+		log.Warnf("%s cannot be destroyed reliably: %s. Prefer a tmpfs such as /dev/shm, --secrets=%s, or a sealed bundle", path, problem, StdinSecrets)
+	}
+}
 
-	// 3. Parse YAML
+// overwriteProblem explains why overwriting file does not destroy its old
+// content, or returns "" when it does (in memory, or on a spinning disk).
+func overwriteProblem(file *os.File) string {
+	storage, err := utils.FileStorage(file)
+	switch {
+	case err != nil:
+		return fmt.Sprintf("its storage is unknown (%v)", err)
+	case storage.InMemory:
+		return ""
+	case storage.CopyOnWrite:
+		return fmt.Sprintf("%s is copy-on-write, so the overwrite goes to new blocks", storage.FSType)
+	case storage.Network:
+		return fmt.Sprintf("%s keeps the file on another machine", storage.FSType)
+	case storage.Device == "":
+		return fmt.Sprintf("the device behind %s is unknown", storage.FSType)
+	case storage.Removable:
+		return fmt.Sprintf("%s is removable flash media, which remaps writes", storage.Device)
+	case !storage.Rotational:
+		return fmt.Sprintf("%s is an SSD, which remaps writes", storage.Device)
+	}
+	return ""
+}
+
+// ParseSecrets parses and validates plaintext secrets YAML.
+func ParseSecrets(data []byte) (*Secrets, error) {
 	var book Secrets
 	if err := yaml.Unmarshal(data, &book); err != nil {
+		book.Destroy() // Values decoded before the error
 		return nil, fmt.Errorf("failed to parse YAML structure: %w", err)
 	}
 
-	// Validation: Ensure critical secrets are present
-	if book.LuksPassword == "" {
-		return nil, fmt.Errorf("invalid secrets file: 'luks_password' is missing or empty")
+	// Validation: Ensure critical secrets are present. A file of named
+	// secrets may leave LUKS passwords to password: ${secret:name} references.
+	if book.LuksPassword.Empty() && len(book.LuksPasswords) == 0 && len(book.Named) == 0 {
+		book.Destroy()
+		return nil, fmt.Errorf("invalid secrets file: 'luks_password', 'luks_passwords' and 'secrets' are all missing or empty")
+	}
+	if err := book.validateNamed(); err != nil {
+		book.Destroy()
+		return nil, fmt.Errorf("invalid secrets file: %w", err)
 	}
 
 	return &book, nil
 }
 
+// openBundle asks for the bundle passphrase on the terminal and decrypts it,
+// asking again after a wrong passphrase.
+func openBundle(path string, data []byte) ([]byte, error) {
+	log.Infof("Secrets file %s is sealed", path)
+	for attempt := 1; ; attempt++ {
+		passphrase, err := utils.ReadSecret(fmt.Sprintf("🔑 Passphrase for %s: ", path))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read passphrase: %w", err)
+		}
+
+		plaintext, err := OpenSecrets(data, passphrase.Bytes())
+		passphrase.Destroy()
+		if errors.Is(err, ErrBadPassphrase) && attempt < bundleAttempts {
+			log.Warnf("Wrong passphrase (attempt %d/%d)", attempt, bundleAttempts)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", path, err)
+		}
+		return plaintext, nil
+	}
+}
+
+// LuksPasswordFor returns the password of a volume by its secret_key.
+// An empty key selects the default luks_password.
+func (s *Secrets) LuksPasswordFor(key string) (*secret.Value, error) {
+	if key == "" {
+		if s.LuksPassword.Empty() {
+			return nil, fmt.Errorf("'luks_password' is missing or empty")
+		}
+		return s.LuksPassword, nil
+	}
+	password, ok := s.LuksPasswords[key]
+	if !ok || password.Empty() {
+		return nil, fmt.Errorf("'luks_passwords.%s' is missing or empty", key)
+	}
+	return password, nil
+}
+
+// CleanupReport is the outcome of CleanupSecrets.
+type CleanupReport struct {
+	Path        string
+	Overwritten bool   // Random data was written over the content and synced
+	Problem     string // Why the overwrite may not have destroyed the content
+	Removed     bool
+	Err         error // Why the overwrite or the removal failed
+}
+
+// Clean reports whether the file is gone and its content destroyed.
+func (c CleanupReport) Clean() bool {
+	return c.Removed && c.Overwritten && c.Problem == ""
+}
+
+// String describes the outcome for the run summary.
+func (c CleanupReport) String() string {
+	switch {
+	case !c.Removed:
+		return fmt.Sprintf("%s was NOT deleted (%v): delete it by hand", c.Path, c.Err)
+	case !c.Overwritten:
+		return fmt.Sprintf("%s deleted without overwrite (%v): the content may be recoverable", c.Path, c.Err)
+	case c.Problem != "":
+		return fmt.Sprintf("%s overwritten and deleted, but %s: the content may be recoverable", c.Path, c.Problem)
+	}
+	return fmt.Sprintf("%s overwritten and deleted", c.Path)
+}
+
 // CleanupSecrets safely removes the secrets file from the disk.
 // This implements the "Self-Destruct" policy with secure overwrite.
-func CleanupSecrets(path string) {
+func CleanupSecrets(path string) CleanupReport {
 	log.Infof("Destroying secrets file: %s", path)
+	report := CleanupReport{Path: path}
 
 	// Step 1: Overwrite file with random data before deletion
-	if err := secureOverwrite(path); err != nil {
+	problem, err := secureOverwrite(path)
+	if err != nil {
 		log.Warnf("Failed to overwrite secrets file: %v", err)
+		report.Err = err
 		// Continue to deletion even if overwrite fails
 	} else {
 		log.Info("Secrets file overwritten with random data")
+		report.Overwritten = true
+		report.Problem = problem
 	}
 
 	// Step 2: Remove the file
 	if err := os.Remove(path); err != nil {
 		log.Warnf("Failed to delete secrets file: %v", err)
+		report.Err = err
 	} else {
 		log.Info("Secrets file destroyed successfully")
+		report.Removed = true
+	}
+
+	if report.Problem != "" {
+		log.Warnf("Overwrite may not have destroyed the content: %s", report.Problem)
 	}
+	return report
 }
 
 // secureOverwrite overwrites the file with cryptographically secure random data.
-// This prevents file recovery from filesystem-level artifacts.
-func secureOverwrite(path string) error {
+// This prevents file recovery from filesystem-level artifacts, as far as
+// the storage allows: the returned problem explains why it may not.
+func secureOverwrite(path string) (string, error) {
+	// Open file for writing (truncate not needed, we'll overwrite in place)
+	file, err := os.OpenFile(path, os.O_WRONLY, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file for overwrite: %w", err)
+	}
+	defer file.Close()
+
 	// Get file size
-	info, err := os.Stat(path)
+	info, err := file.Stat()
 	if err != nil {
-		return fmt.Errorf("failed to stat file: %w", err)
+		return "", fmt.Errorf("failed to stat file: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%s is not a regular file", path)
 	}
 
+	problem := overwriteProblem(file)
 	fileSize := info.Size()
 	if fileSize == 0 {
-		return nil // Nothing to overwrite
+		return problem, nil // Nothing to overwrite
 	}
 
-	// Open file for writing (truncate not needed, we'll overwrite in place)
-	file, err := os.OpenFile(path, os.O_WRONLY, 0600)
-	if err != nil {
-		return fmt.Errorf("failed to open file for overwrite: %w", err)
-	}
-	defer file.Close()
-
 	// Generate random data
 	randomData := make([]byte, fileSize)
 	if _, err := rand.Read(randomData); err != nil {
-		return fmt.Errorf("failed to generate random data: %w", err)
+		return "", fmt.Errorf("failed to generate random data: %w", err)
 	}
 
 	// Write random data to file
 	if _, err := file.Write(randomData); err != nil {
-		return fmt.Errorf("failed to write random data: %w", err)
+		return "", fmt.Errorf("failed to write random data: %w", err)
 	}
 
 	// Sync to disk to ensure data is written
 	if err := file.Sync(); err != nil {
-		return fmt.Errorf("failed to sync file: %w", err)
+		return "", fmt.Errorf("failed to sync file: %w", err)
 	}
 
-	return nil
+	return problem, nil
 }
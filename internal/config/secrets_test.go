@@ -0,0 +1,135 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseSecretsNamed(t *testing.T) {
+	// A block scalar keeps its final newline, which a string secret drops
+	data := []byte(`secrets:
+  plain: hunter2
+  block: |
+    s3cr3t
+  pem:
+    type: multiline
+    value: |
+      -----BEGIN KEY-----
+      abc
+      -----END KEY-----
+  blob:
+    type: file
+    value: aGVsbG8K
+`)
+	book, err := ParseSecrets(data)
+	if err != nil {
+		t.Fatalf("ParseSecrets() error = %v", err)
+	}
+	defer book.Destroy()
+
+	want := map[string]string{
+		"plain": "hunter2",
+		"block": "s3cr3t",
+		"pem":   "-----BEGIN KEY-----\nabc\n-----END KEY-----\n",
+		"blob":  "hello\n",
+	}
+	for name, value := range want {
+		got, ok := book.Lookup(name)
+		if !ok {
+			t.Errorf("Lookup(%q) not found", name)
+			continue
+		}
+		if string(got.Bytes()) != value {
+			t.Errorf("Lookup(%q) = %q, want %q", name, got.Bytes(), value)
+		}
+	}
+}
+
+func TestParseSecretsNamedInvalid(t *testing.T) {
+	tests := []struct {
+		name, data, want string
+	}{
+		{
+			name: "string over several lines",
+			data: "secrets:\n  token: |\n    line one\n    line two\n",
+			want: "must be a single line",
+		},
+		{name: "file not base64", data: "secrets:\n  blob:\n    type: file\n    value: \"!!\"\n", want: "must be base64"},
+		{name: "unknown type", data: "secrets:\n  x:\n    type: binary\n    value: y\n", want: "type must be"},
+		{name: "invalid name", data: "secrets:\n  a.b: c\n", want: "names may only contain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book, err := ParseSecrets([]byte(tt.data))
+			if err == nil {
+				book.Destroy()
+				t.Fatal("ParseSecrets() error = nil")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("ParseSecrets() error = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadSecretsData(t *testing.T) {
+	const content = "luks_password: correct horse\n"
+	path := filepath.Join(t.TempDir(), "secrets.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	data, err := readSecretsData(file)
+	if err != nil {
+		t.Fatalf("readSecretsData() error = %v", err)
+	}
+	if string(data) != content {
+		t.Errorf("readSecretsData() = %q, want %q", data, content)
+	}
+	// Sized once: no spare capacity beyond the byte that detects growth
+	if cap(data) != len(content)+1 {
+		t.Errorf("buffer capacity = %d, want %d", cap(data), len(content)+1)
+	}
+}
+
+func TestReadSecretsDataStream(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		content []byte
+		wantErr bool
+	}{
+		{name: "piped file", content: []byte("luks_password: correct horse\n")},
+		{name: "too large", content: bytes.Repeat([]byte("#"), maxSecretsSize+1), wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r, w, err := os.Pipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			go func() {
+				w.Write(tt.content)
+				w.Close()
+			}()
+
+			data, err := readSecretsData(r)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("readSecretsData() of %d bytes error = nil", len(tt.content))
+				}
+				return
+			}
+			if err != nil || !bytes.Equal(data, tt.content) {
+				t.Errorf("readSecretsData() = %q (%v), want %q", data, err, tt.content)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/acker1019/fedora-phoenix/internal/secret"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadVault reads the second-stage secrets file from an unlocked LUKS
+// volume. It uses the secrets schema and ownership rules, but unlike
+// LoadSecrets it needs no LUKS password, and the file is never destroyed:
+// the volume keeps it encrypted at rest. A sealed bundle is accepted as well.
+func LoadVault(path string, uid int) (*Secrets, error) {
+	log.Infof("Loading vault from: %s", path)
+
+	data, sealed, err := readSecretsFile(path, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(data)
+
+	// A failed Unmarshal may have decoded some values already
+	var vault Secrets
+	if err := yaml.Unmarshal(data, &vault); err != nil {
+		vault.Destroy()
+		return nil, fmt.Errorf("failed to parse YAML structure: %w", err)
+	}
+	if err := vault.validateNamed(); err != nil {
+		vault.Destroy()
+		return nil, fmt.Errorf("invalid vault: %w", err)
+	}
+	vault.sealed = sealed
+	return &vault, nil
+}
+
+// Merge moves the secrets of other into s. Values of other take
+// precedence, and the values they replace are destroyed; the returned
+// keys (e.g. "tokens.github") are those whose value changed.
+func (s *Secrets) Merge(other *Secrets) []string {
+	var replaced []string
+	if !other.LuksPassword.Empty() {
+		if !s.LuksPassword.Empty() && !s.LuksPassword.Equal(other.LuksPassword) {
+			replaced = append(replaced, "luks_password")
+		}
+		s.LuksPassword.Destroy()
+		s.LuksPassword = other.LuksPassword
+	}
+	replaced = append(replaced, mergeSecretMap(&s.LuksPasswords, other.LuksPasswords, "luks_passwords", identity)...)
+	replaced = append(replaced, mergeSecretMap(&s.Tokens, other.Tokens, "tokens", identity)...)
+	replaced = append(replaced, mergeSecretMap(&s.SSHPassphrases, other.SSHPassphrases, "ssh_passphrases", identity)...)
+	replaced = append(replaced, mergeSecretMap(&s.WifiKeys, other.WifiKeys, "wifi_keys", identity)...)
+	replaced = append(replaced, mergeSecretMap(&s.Named, other.Named, "secrets", func(n NamedSecret) *secret.Value { return n.Value })...)
+	return replaced
+}
+
+// identity is the value accessor of the plain secret maps.
+func identity(v *secret.Value) *secret.Value { return v }
+
+// mergeSecretMap moves src into *dst and returns the replaced keys,
+// prefixed with section. value returns the secret of an entry.
+func mergeSecretMap[V any](dst *map[string]V, src map[string]V, section string, value func(V) *secret.Value) []string {
+	if len(src) == 0 {
+		return nil
+	}
+	if *dst == nil {
+		*dst = make(map[string]V, len(src))
+	}
+
+	var replaced []string
+	for _, key := range slices.Sorted(maps.Keys(src)) {
+		if old, ok := (*dst)[key]; ok {
+			if !value(old).Equal(value(src[key])) {
+				replaced = append(replaced, section+"."+key)
+			}
+			value(old).Destroy()
+		}
+		(*dst)[key] = src[key]
+	}
+	return replaced
+}
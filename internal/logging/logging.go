@@ -57,6 +57,9 @@ func init() {
 	})
 	logger.SetLevel(logrus.InfoLevel)
 
+	// Secrets registered with AddSecret never reach the output
+	logger.AddHook(redactHook{})
+
 	// Inject the logrus instance into our global logger
 	log = logger
 }
@@ -0,0 +1,119 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// RedactedText replaces secret values in log output.
+const RedactedText = "[REDACTED]"
+
+// minRedactLength is the shortest secret that is redacted. Shorter values
+// occur in ordinary text: replacing them would mangle every log line and,
+// by where the replacements land, give the value away.
+const minRedactLength = 6
+
+// minRedactLine is the shortest line of a multi-line secret that is
+// redacted on its own (shorter lines, like a PEM's last line, are too common).
+const minRedactLine = 8
+
+// redactor holds the secret values registered with AddSecret. It keeps
+// references to the caller's memory rather than copies, so that the
+// values exist only once.
+var redactor struct {
+	sync.RWMutex
+	values []redactValue // Longest first, so that a secret containing another is replaced whole
+}
+
+type redactValue struct {
+	owner *byte  // First byte of the registered slice, identifies it for RemoveSecret
+	value []byte // The slice, or one of its lines
+}
+
+// AddSecret registers a value that must never appear in log output. Every
+// entry logged afterwards has it replaced with RedactedText, in the message
+// and in its fields. Lines of a multi-line value are registered as well.
+//
+// The slice is referenced, not copied: it must stay valid until it is
+// unregistered with RemoveSecret. Values shorter than minRedactLength are
+// not registered; a warning tells that they may appear in the output.
+func AddSecret(value []byte) {
+	if len(value) == 0 {
+		return
+	}
+	if len(value) < minRedactLength {
+		log.Warnf("A secret is shorter than %d characters: it is not redacted from log output", minRedactLength)
+		return
+	}
+	owner := &value[0]
+	values := []redactValue{{owner, value}}
+	if bytes.Contains(value, []byte("\n")) {
+		for _, line := range bytes.Split(value, []byte("\n")) {
+			if line = bytes.TrimSpace(line); len(line) >= minRedactLine {
+				values = append(values, redactValue{owner, line})
+			}
+		}
+	}
+
+	redactor.Lock()
+	defer redactor.Unlock()
+	redactor.values = append(redactor.values, values...)
+	slices.SortStableFunc(redactor.values, func(a, b redactValue) int { return len(b.value) - len(a.value) })
+}
+
+// RemoveSecret unregisters a value registered with AddSecret, before
+// its memory is released.
+func RemoveSecret(value []byte) {
+	if len(value) == 0 {
+		return
+	}
+	redactor.Lock()
+	defer redactor.Unlock()
+	redactor.values = slices.DeleteFunc(redactor.values, func(v redactValue) bool { return v.owner == &value[0] })
+}
+
+// Redact replaces every registered secret in s with RedactedText. Output
+// that does not go through the logger (e.g. error reports) uses it directly.
+func Redact(s string) string {
+	redactor.RLock()
+	defer redactor.RUnlock()
+
+	var out []byte
+	for _, v := range redactor.values {
+		if out == nil {
+			if !bytes.Contains([]byte(s), v.value) {
+				continue
+			}
+			out = []byte(s)
+		}
+		out = bytes.ReplaceAll(out, v.value, []byte(RedactedText))
+	}
+	if out == nil {
+		return s
+	}
+	return string(out)
+}
+
+// redactHook applies Redact to every entry before it is formatted.
+type redactHook struct{}
+
+func (redactHook) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (redactHook) Fire(entry *logrus.Entry) error {
+	entry.Message = Redact(entry.Message)
+	for key, value := range entry.Data {
+		switch v := value.(type) {
+		case string:
+			entry.Data[key] = Redact(v)
+		case error, fmt.Stringer:
+			if s := fmt.Sprint(v); Redact(s) != s {
+				entry.Data[key] = Redact(s)
+			}
+		}
+	}
+	return nil
+}
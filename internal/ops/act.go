@@ -0,0 +1,82 @@
+package ops
+
+import (
+	"fmt"
+
+	"github.com/acker1019/fedora-phoenix/internal/utils"
+)
+
+// Block identifies which stage of the Phoenix Protocol an Act belongs to.
+// See ADR-0002 (Block Architecture).
+type Block int
+
+const (
+	BlockIdentity       Block = iota + 1 // Block I: Identity & Configuration
+	BlockInfrastructure                  // Block II: Infrastructure
+	BlockSystem                          // Block III: System State
+	BlockUserSpace                       // Block IV: User Space
+)
+
+// String returns the display name of the block (e.g. "Block II: Infrastructure").
+func (b Block) String() string {
+	switch b {
+	case BlockIdentity:
+		return "Block I: Identity & Configuration"
+	case BlockInfrastructure:
+		return "Block II: Infrastructure"
+	case BlockSystem:
+		return "Block III: System State"
+	case BlockUserSpace:
+		return "Block IV: User Space"
+	default:
+		return fmt.Sprintf("Block %d", int(b))
+	}
+}
+
+// Checker is the read-only half of an Act. Audits such as `phoenix verify`
+// also use Checkers that have no Apply half (e.g. extra packages).
+type Checker interface {
+	// Name returns the Act name as listed in docs/act-list.md (e.g. "UnlockLuks").
+	Name() string
+
+	// Block returns the stage this Act belongs to.
+	Block() Block
+
+	// Check compares the desired state against the live system without changing it.
+	Check(r utils.Runner) ([]Diff, error)
+}
+
+// Act is an atomic, idempotent operation following the Check-Diff-Act pattern
+// (ADR-0005). Check must be read-only; Apply receives only the unsatisfied
+// Diffs returned by Check and reconciles them. Both run every external
+// command through the given Runner.
+type Act interface {
+	Checker
+
+	// Apply reconciles the pending Diffs produced by Check.
+	Apply(r utils.Runner, pending []Diff) error
+}
+
+// Ensure runs the full Check-Diff-Act cycle for a single Act.
+// It is used when one Act depends on another (e.g. the versionlock plugin).
+func Ensure(r utils.Runner, act Act) error {
+	diffs, err := act.Check(r)
+	if err != nil {
+		return err
+	}
+	if todo := pendingDiffs(diffs); len(todo) > 0 {
+		return act.Apply(r, todo)
+	}
+	return nil
+}
+
+// pendingDiffs filters out Diffs whose desired state is already in place.
+func pendingDiffs(diffs []Diff) []Diff {
+	var todo []Diff
+	for _, d := range diffs {
+		if !d.Satisfied {
+			todo = append(todo, d)
+		}
+	}
+	return todo
+}
@@ -0,0 +1,113 @@
+package ops
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/acker1019/fedora-phoenix/internal/utils/utilstest"
+)
+
+// actCase is one entry of an Act's table test. The Act runs once through
+// a Playbook against a FakeRunner scripted by script, so that Check,
+// Apply and the conversion of failures into an *Error are all exercised.
+type actCase struct {
+	name    string
+	act     func(t *testing.T) Act // Builds the Act, and any files it works on
+	script  func(r *utilstest.FakeRunner)
+	pending []string // Keys of the Diffs Check reports as pending
+	fail    Category // Category of the expected *Error; zero expects success
+	exit    int      // Exit code of the failed command, if any
+}
+
+// runActCases runs every case as a subtest. The runner script must be
+// followed exactly: a command Apply should not run fails the case.
+func runActCases(t *testing.T, cases []actCase) {
+	t.Helper()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			act := tc.act(t)
+			r := utilstest.NewFakeRunner()
+			if tc.script != nil {
+				tc.script(r)
+			}
+
+			results, err := (&Playbook{Runner: r}).Run([]Act{act})
+			switch {
+			case tc.fail != 0:
+				checkActError(t, act, err, tc.fail, tc.exit)
+			case err != nil:
+				t.Fatalf("Run() error = %v", err)
+			default:
+				if got := diffKeys(results[0].Pending()); !slices.Equal(got, tc.pending) {
+					t.Errorf("pending = %q, want %q", got, tc.pending)
+				}
+			}
+			if unmet := r.Unmet(); unmet != nil {
+				t.Errorf("runner script not followed: %q", unmet)
+			}
+		})
+	}
+}
+
+// checkActError checks that err is the *Error of a failed act, with the
+// exit code of its Block.
+func checkActError(t *testing.T, act Act, err error, category Category, exitCode int) {
+	t.Helper()
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("error = %v, want an *Error", err)
+	}
+	if e.Category != category {
+		t.Errorf("category = %s, want %s (%v)", e.Category, category, e)
+	}
+	if e.Act != act.Name() || e.Block != act.Block() {
+		t.Errorf("error attributed to %s (%s), want %s (%s)", e.Act, e.Block, act.Name(), act.Block())
+	}
+	if want := int(act.Block()) * 10; e.Block.ExitCode() != want {
+		t.Errorf("process exit code = %d, want %d", e.Block.ExitCode(), want)
+	}
+	if exitCode != 0 && e.ExitCode != exitCode {
+		t.Errorf("command exit code = %d, want %d", e.ExitCode, exitCode)
+	}
+}
+
+// checkCategory checks the category of an *Error returned by a direct
+// Check or Apply.
+func checkCategory(t *testing.T, err error, category Category) {
+	t.Helper()
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("error = %v, want an *Error", err)
+	}
+	if e.Category != category {
+		t.Errorf("category = %s, want %s (%v)", e.Category, category, e)
+	}
+}
+
+// diffKeys returns the keys of diffs, nil when there are none.
+func diffKeys(diffs []Diff) []string {
+	var keys []string
+	for _, d := range diffs {
+		keys = append(keys, d.Key)
+	}
+	return keys
+}
+
+// constAct returns an act builder for an Act that needs no files.
+func constAct(act Act) func(*testing.T) Act {
+	return func(*testing.T) Act { return act }
+}
+
+func TestEnsureSkipsSatisfiedAct(t *testing.T) {
+	r := utilstest.NewFakeRunner()
+	r.Expect("systemctl is-enabled sshd")
+	r.Expect("systemctl is-active sshd")
+
+	if err := Ensure(r, &EnsureServices{Services: []string{"sshd"}}); err != nil {
+		t.Fatalf("Ensure() error = %v", err)
+	}
+	if unmet := r.Unmet(); unmet != nil {
+		t.Errorf("runner script not followed: %q", unmet)
+	}
+}
@@ -0,0 +1,21 @@
+package ops
+
+// Diff is the outcome of the Check half of an Act for a single item.
+// It records whether the live system already matches the blueprint and,
+// if not, what the Act half would change.
+type Diff struct {
+	Key       string // Raw subject handed back to Apply (e.g. "vim")
+	Item      string // Subject being checked (e.g. "package vim")
+	Satisfied bool   // True if the desired state is already in place
+	Detail    string // Current state, or the change that would be made
+}
+
+// satisfied builds a Diff for an item that needs no change.
+func satisfied(key, item, detail string) Diff {
+	return Diff{Key: key, Item: item, Satisfied: true, Detail: detail}
+}
+
+// pending builds a Diff for an item the Act half would change.
+func pending(key, item, detail string) Diff {
+	return Diff{Key: key, Item: item, Satisfied: false, Detail: detail}
+}
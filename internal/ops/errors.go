@@ -0,0 +1,132 @@
+package ops
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/acker1019/fedora-phoenix/internal/utils"
+)
+
+// Category classifies why an Act failed, so the user gets a relevant Hint.
+type Category int
+
+const (
+	CategoryUnknown    Category = iota
+	CategoryConfig              // Blueprint, secrets or flags are wrong
+	CategoryAuth                // Wrong password or key material
+	CategoryDevice              // Block device missing, busy or unmountable
+	CategoryPackage             // rpm/dnf transaction failed
+	CategoryService             // systemd unit could not be enabled
+	CategoryPermission          // Not root, or file ownership problem
+	CategoryCommand             // Any other external command failure
+)
+
+// String returns a short lower-case label for the category.
+func (c Category) String() string {
+	switch c {
+	case CategoryConfig:
+		return "config"
+	case CategoryAuth:
+		return "auth"
+	case CategoryDevice:
+		return "device"
+	case CategoryPackage:
+		return "package"
+	case CategoryService:
+		return "service"
+	case CategoryPermission:
+		return "permission"
+	case CategoryCommand:
+		return "command"
+	default:
+		return "unknown"
+	}
+}
+
+// ExitCode returns the process exit code used when an Act of this block fails,
+// so wrapper scripts can tell where the Phoenix Protocol stopped.
+//
+//	10 = Block I, 20 = Block II, 30 = Block III, 40 = Block IV
+func (b Block) ExitCode() int {
+	if b < BlockIdentity || b > BlockUserSpace {
+		return 1
+	}
+	return int(b) * 10
+}
+
+// Error is the structured failure returned by Acts and the Playbook.
+// It carries enough context to render "Reason" and "Hint" lines
+// (see anti-requirements.md) without a Go stack trace.
+type Error struct {
+	Category Category
+	Act      string // Failing Act name (filled in by the Playbook)
+	Block    Block  // Block of the failing Act (filled in by the Playbook)
+	Command  string // Underlying command line, if any
+	ExitCode int    // Underlying command exit code, or -1
+	Stderr   string // Tail of the command's stderr
+	Hint     string // Remediation advice for the user
+	Err      error
+}
+
+func (e *Error) Error() string {
+	if e.Act != "" {
+		return fmt.Sprintf("%s: %v", e.Act, e.Err)
+	}
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// fail wraps err as a categorized *Error, copying command details
+// from a wrapped *utils.CommandError when present.
+func fail(category Category, hint string, err error) *Error {
+	e := &Error{Category: category, ExitCode: -1, Hint: hint, Err: err}
+
+	var cmdErr *utils.CommandError
+	if errors.As(err, &cmdErr) {
+		e.Command = cmdErr.Command
+		e.ExitCode = cmdErr.ExitCode
+		e.Stderr = cmdErr.Stderr
+	}
+	return e
+}
+
+// asError converts any error into an *Error attributed to act.
+// Errors that are already an *Error keep their category and hint.
+func asError(act Act, err error) *Error {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = fail(CategoryUnknown, "", err)
+	}
+	if e.Act == "" {
+		e.Act = act.Name()
+		e.Block = act.Block()
+	}
+	return e
+}
+
+// NewBlockError wraps a failure that happens outside of an Act
+// (e.g. loading the blueprint) so it is rendered and exits like one.
+func NewBlockError(block Block, category Category, hint string, err error) *Error {
+	e := fail(category, hint, err)
+	e.Block = block
+	return e
+}
+
+// cryptsetupHint maps cryptsetup exit codes (see cryptsetup(8)) to advice.
+func cryptsetupHint(exitCode int) (Category, string) {
+	switch exitCode {
+	case 1:
+		return CategoryDevice, "Wrong parameters: check infrastructure.luks in the blueprint"
+	case 2:
+		return CategoryAuth, "Check if the password is correct"
+	case 4:
+		return CategoryDevice, "Wrong device specified: check that the LUKS device exists"
+	case 5:
+		return CategoryDevice, "Device already exists or is busy: try `cryptsetup close` first"
+	default:
+		return CategoryDevice, "Run `cryptsetup open` manually to see the full error"
+	}
+}
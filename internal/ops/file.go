@@ -0,0 +1,123 @@
+package ops
+
+import (
+	"bytes"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/acker1019/fedora-phoenix/internal/logging"
+	"github.com/acker1019/fedora-phoenix/internal/utils"
+)
+
+var fileLog = logging.WithSource("ops/file")
+
+// EnvironmentPath is the environment.d file of userspace.environment,
+// relative to the user's home directory.
+const EnvironmentPath = ".config/environment.d/60-phoenix.conf"
+
+// EnsureFile writes a file with fixed content, mode and ownership.
+// Content may hold resolved secrets: diffs only ever say that it differs.
+type EnsureFile struct {
+	Path    string
+	Content string
+	Mode    os.FileMode
+	UID     int
+	GID     int
+}
+
+func (a *EnsureFile) Name() string { return "EnsureFile" }
+func (a *EnsureFile) Block() Block { return BlockUserSpace }
+
+// Check compares content, mode and ownership of the file.
+func (a *EnsureFile) Check(r utils.Runner) ([]Diff, error) {
+	item := fmt.Sprintf("file %s", a.Path)
+
+	info, err := os.Stat(a.Path)
+	if os.IsNotExist(err) {
+		return []Diff{pending(a.Path, item, "would create")}, nil
+	}
+	if err != nil {
+		return nil, fail(CategoryPermission, "", err)
+	}
+
+	var reasons []string
+	current, err := os.ReadFile(a.Path)
+	if err != nil {
+		return nil, fail(CategoryPermission, "", err)
+	}
+	if !bytes.Equal(current, []byte(a.Content)) {
+		reasons = append(reasons, "content differs")
+	}
+	clear(current)
+	if info.Mode().Perm() != a.Mode {
+		reasons = append(reasons, fmt.Sprintf("mode %04o != %04o", info.Mode().Perm(), a.Mode))
+	}
+	if uid, gid, ok := fileOwner(info); ok && (uid != a.UID || gid != a.GID) {
+		reasons = append(reasons, fmt.Sprintf("owner %d:%d != %d:%d", uid, gid, a.UID, a.GID))
+	}
+
+	if len(reasons) == 0 {
+		return []Diff{satisfied(a.Path, item, "up to date")}, nil
+	}
+	return []Diff{pending(a.Path, item, fmt.Sprintf("would update (%s)", strings.Join(reasons, ", ")))}, nil
+}
+
+// Apply atomically replaces the file. The temp file gets its final mode
+// before any content is written, so secrets are never world-readable.
+func (a *EnsureFile) Apply(r utils.Runner, pending []Diff) error {
+	dir := filepath.Dir(a.Path)
+	if err := ensureUserDir(dir, dirMode(a.Mode), a.UID, a.GID); err != nil {
+		return fail(CategoryPermission, "", fmt.Errorf("failed to create %s: %w", dir, err))
+	}
+
+	fileLog.Infof("Writing %s", a.Path)
+	if err := writeFileAtomic(a.Path, []byte(a.Content), a.Mode, a.UID, a.GID); err != nil {
+		return fail(CategoryPermission, "", fmt.Errorf("failed to write %s: %w", a.Path, err))
+	}
+	return nil
+}
+
+// writeFileAtomic replaces path with data (temp file in the same
+// directory + rename), owned by uid:gid.
+func writeFileAtomic(path string, data []byte, mode os.FileMode, uid, gid int) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".phoenix-file-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chown(uid, gid); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// EnvironmentFile renders variables as an environment.d file, sorted by name.
+func EnvironmentFile(vars map[string]string) string {
+	var b strings.Builder
+	b.WriteString("# Managed by phoenix (userspace.environment), do not edit\n")
+	for _, key := range slices.Sorted(maps.Keys(vars)) {
+		fmt.Fprintf(&b, "%s=%s\n", key, vars[key])
+	}
+	return b.String()
+}
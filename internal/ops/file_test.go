@@ -0,0 +1,89 @@
+package ops
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newEnsureFile returns an EnsureFile of environment.d/60-phoenix.conf in
+// a temporary home directory, owned by the test user.
+func newEnsureFile(t *testing.T, content string, mode os.FileMode) *EnsureFile {
+	t.Helper()
+	return &EnsureFile{
+		Path: filepath.Join(t.TempDir(), ".config", "environment.d", "60-phoenix.conf"), Content: content, Mode: mode,
+		UID: os.Getuid(), GID: os.Getgid(),
+	}
+}
+
+// ensureOnce runs Check and applies what it reports as pending, returning
+// the number of pending Diffs.
+func ensureOnce(t *testing.T, a *EnsureFile) int {
+	t.Helper()
+	diffs, err := a.Check(nil)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	pending := pendingDiffs(diffs)
+	if len(pending) > 0 {
+		if err := a.Apply(nil, pending); err != nil {
+			t.Fatalf("Apply() error = %v", err)
+		}
+	}
+	return len(pending)
+}
+
+func TestEnsureFileCreates(t *testing.T) {
+	a := newEnsureFile(t, "EDITOR=vim\n", 0600)
+
+	if n := ensureOnce(t, a); n != 1 {
+		t.Errorf("pending = %d, want 1", n)
+	}
+	if content, err := os.ReadFile(a.Path); err != nil || string(content) != "EDITOR=vim\n" {
+		t.Errorf("content = %q (%v), want %q", content, err, "EDITOR=vim\n")
+	}
+	if info, err := os.Stat(a.Path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v (%v), want 0600", info.Mode().Perm(), err)
+	}
+	if n := ensureOnce(t, a); n != 0 {
+		t.Errorf("pending after Apply = %d, want none", n)
+	}
+}
+
+func TestEnsureFileUpdates(t *testing.T) {
+	a := newEnsureFile(t, "EDITOR=vim\n", 0600)
+	ensureOnce(t, a)
+
+	a.Content, a.Mode = "EDITOR=hx\n", 0644
+	if n := ensureOnce(t, a); n != 1 {
+		t.Errorf("pending = %d, want 1", n)
+	}
+	if content, err := os.ReadFile(a.Path); err != nil || string(content) != "EDITOR=hx\n" {
+		t.Errorf("content = %q (%v), want %q", content, err, "EDITOR=hx\n")
+	}
+	if info, err := os.Stat(a.Path); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("mode = %v (%v), want 0644", info.Mode().Perm(), err)
+	}
+}
+
+func TestEnsureFileParentNotDirectory(t *testing.T) {
+	blocker := filepath.Join(t.TempDir(), "blocker")
+	if err := os.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	a := &EnsureFile{Path: filepath.Join(blocker, "60-phoenix.conf"), Content: "EDITOR=vim\n", Mode: 0644, UID: os.Getuid(), GID: os.Getgid()}
+
+	diffs, err := a.Check(nil)
+	if err == nil {
+		err = a.Apply(nil, pendingDiffs(diffs))
+	}
+	checkCategory(t, err, CategoryPermission)
+}
+
+func TestEnvironmentFile(t *testing.T) {
+	got := EnvironmentFile(map[string]string{"PATH": "$HOME/.local/bin:$PATH", "EDITOR": "vim"})
+	want := "# Managed by phoenix (userspace.environment), do not edit\nEDITOR=vim\nPATH=$HOME/.local/bin:$PATH\n"
+	if got != want {
+		t.Errorf("EnvironmentFile() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,232 @@
+package ops
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/acker1019/fedora-phoenix/internal/logging"
+	"github.com/acker1019/fedora-phoenix/internal/statestore"
+	"github.com/acker1019/fedora-phoenix/internal/utils"
+)
+
+var harvestLog = logging.WithSource("ops/harvest")
+
+// Harvest copies running-system changes of the sync items back into the
+// dotfiles repo (System → Repo, ADR-0007). It is the reverse of SyncDotfiles
+// and is not an Act: it runs as the normal user and never commits to git.
+type Harvest struct {
+	BaseDir string // Dotfiles repo root, holds the state store
+	Items   []SyncItem
+	DryRun  bool // Report only, write nothing
+}
+
+// HarvestReport lists what a harvest changed (or would change in dry-run).
+type HarvestReport struct {
+	Harvested   []string // Repo files whose content was copied back from the system
+	ModeChanged []string // Files whose recorded mode or owner was updated
+	Missing     []string // Managed files absent on the system (left alone in the repo)
+	Skipped     []string // System paths that are symlinks or not regular files (not followed)
+	Unchanged   int      // Files already in sync
+}
+
+// Changed reports whether the repo differs from the system.
+func (r *HarvestReport) Changed() bool {
+	return len(r.Harvested) > 0 || len(r.ModeChanged) > 0
+}
+
+// harvestFile is a repo/system pair; the system side is the source here.
+type harvestFile struct {
+	rel  string // Repo-relative path, the state store key
+	repo string
+	sys  string
+	mode os.FileMode // Mode provision would apply without a record
+}
+
+// Run scans every sync item, copies back differing files and updates the state store.
+func (h *Harvest) Run() (*HarvestReport, error) {
+	storePath := filepath.Join(h.BaseDir, statestore.FileName)
+	store, err := statestore.Load(storePath)
+	if err != nil {
+		return nil, fail(CategoryConfig, "Fix or remove the state store file in the dotfiles repo", err)
+	}
+
+	files, err := h.files()
+	if err != nil {
+		return nil, err
+	}
+	harvestLog.Infof("Scanning %d harvested files...", len(files))
+
+	report := &HarvestReport{}
+	dirty := false
+	for _, f := range files {
+		if err := h.harvestFile(f, store, report, &dirty); err != nil {
+			return nil, fail(CategoryPermission, "Check that the dotfiles repo is writable by the current user",
+				fmt.Errorf("failed to harvest %s: %w", f.sys, err))
+		}
+	}
+
+	if !h.DryRun && dirty {
+		if err := store.Save(storePath); err != nil {
+			return nil, fail(CategoryPermission, "Check that the dotfiles repo is writable by the current user", err)
+		}
+	}
+
+	return report, nil
+}
+
+// harvestFile compares one pair and records the result in report.
+// dirty is set when the state store needs to be saved. A symlink on the
+// system is skipped rather than harvested as the file it points to.
+func (h *Harvest) harvestFile(f harvestFile, store *statestore.Store, report *HarvestReport, dirty *bool) error {
+	info, err := os.Lstat(f.sys)
+	if os.IsNotExist(err) {
+		report.Missing = append(report.Missing, f.sys)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		harvestLog.Warnf("Skipping %s: not a regular file (%s)", f.sys, info.Mode().Type())
+		report.Skipped = append(report.Skipped, f.sys)
+		return nil
+	}
+
+	sysHash, err := utils.FileSHA256(f.sys)
+	if err != nil {
+		return err
+	}
+	repoHash, err := utils.FileSHA256(f.repo)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	changed := repoHash != sysHash
+	if changed {
+		report.Harvested = append(report.Harvested, f.rel)
+		if !h.DryRun {
+			harvestLog.Infof("Harvesting %s -> %s", f.sys, f.repo)
+			if err := os.MkdirAll(filepath.Dir(f.repo), 0755); err != nil {
+				return err
+			}
+			if err := copyFile(f.sys, f.repo, info.Mode().Perm()); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The repo file keeps the system file's permissions, so that git sees
+	// the exec bit it has on the system
+	if !h.DryRun {
+		if repoInfo, err := os.Lstat(f.repo); err == nil && repoInfo.Mode().IsRegular() && repoInfo.Mode().Perm() != info.Mode().Perm() {
+			if err := os.Chmod(f.repo, info.Mode().Perm()); err != nil {
+				return err
+			}
+		}
+	}
+
+	rec := statestore.Record{Path: f.rel, Mode: statestore.FormatMode(info.Mode()), SHA256: sysHash}
+	if uid, gid, ok := fileOwner(info); ok {
+		rec.Owner = utils.OwnerName(uid, gid)
+	}
+
+	// Without a record, compare against what provision would apply
+	old, known := store.Get(f.rel)
+	modeChanged := info.Mode().Perm() != f.mode
+	if known {
+		modeChanged = old.Mode != rec.Mode || old.Owner != rec.Owner
+	}
+	if modeChanged {
+		report.ModeChanged = append(report.ModeChanged, f.rel)
+	}
+	if store.Set(rec) {
+		*dirty = true
+	}
+
+	if !changed && !modeChanged {
+		report.Unchanged++
+	}
+	return nil
+}
+
+// files pairs every repo file with its system path. Directory items are
+// walked on both sides, so files created on the system are harvested too.
+func (h *Harvest) files() ([]harvestFile, error) {
+	seen := make(map[string]bool)
+	var files []harvestFile
+
+	add := func(item SyncItem, repo, sys string, mode os.FileMode) error {
+		rel, err := filepath.Rel(h.BaseDir, repo)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if seen[rel] {
+			return nil
+		}
+		seen[rel] = true
+		files = append(files, harvestFile{rel: rel, repo: repo, sys: sys, mode: modeOr(item.Mode, mode)})
+		return nil
+	}
+
+	for _, item := range h.Items {
+		if !isDir(item.Src) && !isDir(item.Dest) {
+			mode := item.Mode
+			if info, err := os.Stat(item.Src); err == nil {
+				mode = modeOr(item.Mode, info.Mode())
+			}
+			if err := add(item, item.Src, item.Dest, mode); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// Repo side first (known files), then the system side (new files)
+		for _, root := range []string{item.Src, item.Dest} {
+			repoSide := root == item.Src
+			err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					if os.IsNotExist(err) {
+						return nil
+					}
+					return err
+				}
+				if d.IsDir() {
+					return nil
+				}
+				if !d.Type().IsRegular() {
+					harvestLog.Warnf("Skipping non-regular file: %s", path)
+					return nil
+				}
+
+				rel, err := filepath.Rel(root, path)
+				if err != nil {
+					return err
+				}
+				info, err := d.Info()
+				if err != nil {
+					return err
+				}
+				if repoSide {
+					return add(item, path, filepath.Join(item.Dest, rel), info.Mode())
+				}
+				return add(item, filepath.Join(item.Src, rel), path, info.Mode())
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to walk %s: %w", root, err)
+			}
+		}
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].rel < files[j].rel })
+	return files, nil
+}
+
+// isDir reports whether path exists and is a directory.
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
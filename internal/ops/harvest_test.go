@@ -0,0 +1,82 @@
+package ops
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestHarvestKeepsExecutableBit(t *testing.T) {
+	repo := t.TempDir()
+	home := t.TempDir()
+	script := filepath.Join(home, "bin", "backup")
+	if err := os.MkdirAll(filepath.Dir(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nrestic backup ~\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &Harvest{BaseDir: repo, Items: []SyncItem{{Src: filepath.Join(repo, "bin"), Dest: filepath.Join(home, "bin")}}}
+	report, err := h.Run()
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if want := []string{"bin/backup"}; !slices.Equal(report.Harvested, want) {
+		t.Errorf("harvested = %q, want %q", report.Harvested, want)
+	}
+	info, err := os.Stat(filepath.Join(repo, "bin", "backup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("harvested mode = %v, want 0755", info.Mode().Perm())
+	}
+}
+
+func TestHarvestRestoresModeOfUnchangedFile(t *testing.T) {
+	repo := t.TempDir()
+	home := t.TempDir()
+	for path, mode := range map[string]os.FileMode{filepath.Join(repo, "deploy"): 0644, filepath.Join(home, "deploy"): 0755} {
+		if err := os.WriteFile(path, []byte("#!/bin/sh\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := &Harvest{BaseDir: repo, Items: []SyncItem{{Src: filepath.Join(repo, "deploy"), Dest: filepath.Join(home, "deploy")}}}
+	if _, err := h.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if info, err := os.Stat(filepath.Join(repo, "deploy")); err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("repo mode = %v (%v), want 0755", info.Mode().Perm(), err)
+	}
+}
+
+func TestHarvestSkipsSymlinks(t *testing.T) {
+	repo := t.TempDir()
+	home := t.TempDir()
+	target := filepath.Join(t.TempDir(), "zshrc")
+	if err := os.WriteFile(target, []byte("export EDITOR=nano\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	zshrc := filepath.Join(home, ".zshrc")
+	if err := os.Symlink(target, zshrc); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &Harvest{BaseDir: repo, Items: []SyncItem{{Src: filepath.Join(repo, "zshrc"), Dest: zshrc}}}
+	report, err := h.Run()
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !slices.Equal(report.Skipped, []string{zshrc}) || report.Changed() {
+		t.Errorf("report = %+v, want only %s skipped", report, zshrc)
+	}
+	if _, err := os.Lstat(filepath.Join(repo, "zshrc")); !os.IsNotExist(err) {
+		t.Errorf("symlink target harvested into the repo (%v)", err)
+	}
+}
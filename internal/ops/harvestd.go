@@ -0,0 +1,192 @@
+package ops
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/acker1019/fedora-phoenix/internal/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// HarvestUnit is the systemd user unit that runs `phoenix harvest --watch` (ADR-0007).
+const HarvestUnit = "phoenix-harvest.service"
+
+// HarvestService manages the harvest daemon in the user's systemd scope.
+// All systemctl calls use --user, so no root is involved.
+type HarvestService struct {
+	UnitDir    string // Usually ~/.config/systemd/user
+	Executable string // Absolute path of the phoenix binary
+	Blueprint  string // Absolute path of the blueprint passed to the daemon
+}
+
+// UnitPath is where the generated unit file lives.
+func (s *HarvestService) UnitPath() string {
+	return filepath.Join(s.UnitDir, HarvestUnit)
+}
+
+// unit renders the unit file. Paths are quoted for systemd's ExecStart parser.
+func (s *HarvestService) unit() string {
+	return fmt.Sprintf(`# Generated by phoenix harvest --daemon, removed by phoenix harvest --shutdown
+[Unit]
+Description=Fedora Phoenix dotfiles harvest (System -> Repo)
+
+[Service]
+Type=simple
+ExecStart=%q harvest --watch --blueprint %q
+Restart=on-failure
+RestartSec=30
+
+[Install]
+WantedBy=default.target
+`, s.Executable, s.Blueprint)
+}
+
+// Install writes the unit file and enables the service with `enable --now`.
+func (s *HarvestService) Install(r utils.Runner) error {
+	harvestLog.Infof("Writing unit file: %s", s.UnitPath())
+	if err := os.MkdirAll(s.UnitDir, 0755); err != nil {
+		return fail(CategoryPermission, "Check that ~/.config is writable", err)
+	}
+	if err := os.WriteFile(s.UnitPath(), []byte(s.unit()), 0644); err != nil {
+		return fail(CategoryPermission, "Check that ~/.config is writable", err)
+	}
+
+	if err := r.Run("systemctl", "--user", "daemon-reload"); err != nil {
+		return fail(CategoryService, "Run harvest --daemon from a desktop session with a user systemd instance", err)
+	}
+	if err := r.Run("systemctl", "--user", "enable", "--now", HarvestUnit); err != nil {
+		return fail(CategoryService, fmt.Sprintf("Inspect the unit with `systemctl --user status %s`", HarvestUnit), err)
+	}
+
+	harvestLog.Infof("%s enabled and started", HarvestUnit)
+	return nil
+}
+
+// Remove stops and disables the service and deletes the unit file.
+// It is a no-op when the daemon was never installed.
+func (s *HarvestService) Remove(r utils.Runner) error {
+	if _, err := os.Stat(s.UnitPath()); os.IsNotExist(err) {
+		harvestLog.Infof("No unit file at %s, nothing to remove", s.UnitPath())
+		return nil
+	}
+
+	if err := r.Run("systemctl", "--user", "disable", "--now", HarvestUnit); err != nil {
+		return fail(CategoryService, fmt.Sprintf("Inspect the unit with `systemctl --user status %s`", HarvestUnit), err)
+	}
+	if err := os.Remove(s.UnitPath()); err != nil {
+		return fail(CategoryPermission, "Remove the unit file manually", err)
+	}
+	if err := r.Run("systemctl", "--user", "daemon-reload"); err != nil {
+		return fail(CategoryService, "Run `systemctl --user daemon-reload` manually", err)
+	}
+
+	harvestLog.Infof("%s stopped and removed", HarvestUnit)
+	return nil
+}
+
+// State returns the unit's is-enabled and is-active answers (e.g. "enabled", "active").
+func (s *HarvestService) State(r utils.Runner) (enabled, active string) {
+	return systemctlState(r, "is-enabled"), systemctlState(r, "is-active")
+}
+
+// systemctlState asks a --user is-* query; a non-zero exit still prints the state.
+func systemctlState(r utils.Runner, query string) string {
+	out, err := r.Output("systemctl", "--user", query, HarvestUnit)
+	if state := strings.TrimSpace(string(out)); state != "" {
+		return state
+	}
+	if err != nil {
+		return "unknown"
+	}
+	return ""
+}
+
+// HarvestStatus is the daemon's last scan, persisted for `harvest --check`.
+type HarvestStatus struct {
+	LastScan    time.Time `yaml:"last_scan"`
+	Harvested   int       `yaml:"harvested"`
+	ModeChanged int       `yaml:"mode_changed"`
+	Missing     int       `yaml:"missing"`
+	Error       string    `yaml:"error,omitempty"`
+}
+
+// HarvestStatusPath follows the XDG state directory convention.
+func HarvestStatusPath(home string) string {
+	return filepath.Join(home, ".local", "state", "phoenix", "harvest-status.yml")
+}
+
+// LoadHarvestStatus reads the status file; it returns nil if the daemon never ran.
+func LoadHarvestStatus(path string) (*HarvestStatus, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var s HarvestStatus
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse harvest status %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// Save writes the status file, creating its directory.
+func (s *HarvestStatus) Save(path string) error {
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Watch harvests once immediately and then on every tick until ctx is done.
+// A failed scan is recorded in the status file and the loop keeps going;
+// cancellation (SIGTERM from systemd) only takes effect between scans.
+func (h *Harvest) Watch(ctx context.Context, interval time.Duration, statusPath string) error {
+	harvestLog.Infof("Watching %d sync items every %s", len(h.Items), interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		h.scan(statusPath)
+
+		select {
+		case <-ctx.Done():
+			harvestLog.Info("Harvest watch stopped")
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// scan runs one harvest and records its outcome.
+func (h *Harvest) scan(statusPath string) {
+	status := &HarvestStatus{LastScan: time.Now().UTC()}
+
+	report, err := h.Run()
+	if err != nil {
+		harvestLog.Errorf("Harvest failed: %v", err)
+		status.Error = err.Error()
+	} else {
+		status.Harvested = len(report.Harvested)
+		status.ModeChanged = len(report.ModeChanged)
+		status.Missing = len(report.Missing)
+		if report.Changed() {
+			harvestLog.Infof("Harvested %d files, %d mode changes", status.Harvested, status.ModeChanged)
+		}
+	}
+
+	if err := status.Save(statusPath); err != nil {
+		harvestLog.Warnf("Failed to write harvest status: %v", err)
+	}
+}
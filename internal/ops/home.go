@@ -9,11 +9,16 @@ import (
 
 var homeLog = logging.WithSource("ops/home")
 
+// HomeDir returns the conventional home directory path for username.
+func HomeDir(username string) string {
+	return fmt.Sprintf("/home/%s", username)
+}
+
 // EnsureUserHome ensures the user's home directory exists with correct permissions.
 // Returns the home directory path.
 // Follows Check-Diff-Act pattern for idempotency.
 func EnsureUserHome(username string, uid, gid int) (string, error) {
-	homeDir := fmt.Sprintf("/home/%s", username)
+	homeDir := HomeDir(username)
 	homeLog.Infof("Ensuring home directory: %s", homeDir)
 
 	// Check: Does home directory exist?
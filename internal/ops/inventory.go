@@ -0,0 +1,266 @@
+package ops
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/acker1019/fedora-phoenix/internal/utils"
+)
+
+// rpmQueryFormat prints one line per installed package: name, epoch,
+// version, release and arch, then every provide, separated by tabs.
+const rpmQueryFormat = `%{NAME}\t%|EPOCH?{%{EPOCH}}:{0}|\t%{VERSION}\t%{RELEASE}\t%{ARCH}[\t%{PROVIDENAME}]\n`
+
+// rpmFilesFormat prints every file of every installed package, one per line.
+const rpmFilesFormat = `[%{FILENAMES}\n]`
+
+// InstalledPackage is one installed rpm.
+type InstalledPackage struct {
+	Name    string
+	Epoch   string // "0" when the package has none
+	Version string
+	Release string
+	Arch    string
+}
+
+// NEVRA renders the package like dnf does, e.g. "vim-enhanced-2:9.1.0-1.fc41.x86_64".
+func (p InstalledPackage) NEVRA() string {
+	evr := p.Version + "-" + p.Release
+	if p.Epoch != "0" {
+		evr = p.Epoch + ":" + evr
+	}
+	return fmt.Sprintf("%s-%s.%s", p.Name, evr, p.Arch)
+}
+
+// specs lists the forms rpm and dnf accept for the package: name,
+// name.arch, name-version, name-version-release and NEVRA, with and
+// without epoch.
+func (p InstalledPackage) specs() []string {
+	vr := p.Version + "-" + p.Release
+	evr := p.Epoch + ":" + vr
+	return []string{
+		p.Name,
+		p.Name + "." + p.Arch,
+		p.Name + "-" + p.Version,
+		p.Name + "-" + vr,
+		p.Name + "-" + vr + "." + p.Arch,
+		p.Name + "-" + evr,
+		p.Name + "-" + evr + "." + p.Arch,
+	}
+}
+
+// PackageInventory is the installed-package set of a run. It is loaded
+// with a single rpm query on first use and answers whether package specs
+// are satisfied, instead of one `rpm -q` per package. Acts that run a dnf
+// transaction call Invalidate, so that the next question reloads it.
+//
+// A spec is satisfied by a package name, name.arch or (E)VR form, by a
+// Provides of any installed package, by an installed file path, or by a
+// glob over package names and forms, like `dnf install` accepts them.
+type PackageInventory struct {
+	loaded   bool
+	packages []InstalledPackage
+	forms    map[string]bool // Every InstalledPackage.specs form
+	provides map[string]bool // Provide names, including explicit file provides
+	files    map[string]bool // File paths asked about so far, and whether a package owns them
+
+	locksLoaded bool
+	locks       []packageSpec // Entries of `dnf versionlock list`
+}
+
+// NewPackageInventory returns an inventory that loads on first use.
+func NewPackageInventory() *PackageInventory {
+	return &PackageInventory{}
+}
+
+// Invalidate drops everything loaded, after a dnf transaction changed it.
+func (inv *PackageInventory) Invalidate() {
+	*inv = PackageInventory{}
+}
+
+// Packages returns the installed packages.
+func (inv *PackageInventory) Packages(r utils.Runner) ([]InstalledPackage, error) {
+	if err := inv.load(r); err != nil {
+		return nil, err
+	}
+	return inv.packages, nil
+}
+
+// Satisfied reports for every spec whether the installed packages
+// satisfy it. File paths need one more rpm query, made only for paths
+// that are not explicit provides, and only once for all of them.
+func (inv *PackageInventory) Satisfied(r utils.Runner, specs []string) (map[string]bool, error) {
+	if err := inv.load(r); err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]bool, len(specs))
+	var paths []string
+	for _, spec := range specs {
+		switch {
+		case inv.forms[spec] || inv.provides[spec]:
+			result[spec] = true
+		case strings.HasPrefix(spec, "/"):
+			if _, known := inv.files[spec]; !known {
+				paths = append(paths, spec)
+			}
+		case strings.ContainsAny(spec, "*?["):
+			result[spec] = inv.matchGlob(spec)
+		}
+	}
+
+	if len(paths) > 0 {
+		if err := inv.loadFiles(r, paths); err != nil {
+			return nil, err
+		}
+	}
+	for _, spec := range specs {
+		if inv.files[spec] {
+			result[spec] = true
+		}
+	}
+	return result, nil
+}
+
+// Locked reports whether an entry of `dnf versionlock list`, which is run
+// once, locks the package of spec: same name, and the same version,
+// release and epoch as far as spec gives them. Without the versionlock
+// plugin nothing is locked.
+func (inv *PackageInventory) Locked(r utils.Runner, spec string) bool {
+	if !inv.locksLoaded {
+		output, err := r.Output("dnf", "versionlock", "list")
+		if err != nil {
+			output = nil
+		}
+		inv.locks = parseVersionLocks(output)
+		inv.locksLoaded = true
+	}
+
+	want := parsePackageSpec(spec)
+	for _, lock := range inv.locks {
+		if lock.locks(want) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseVersionLocks parses the output of `dnf versionlock list`: one
+// name-epoch:version-release.* line per lock with dnf4, and "Package name:"
+// blocks with an "evr = " condition with dnf5. Excludes (lines starting
+// with '!'), comments and messages such as the metadata check are skipped.
+func parseVersionLocks(output []byte) []packageSpec {
+	var locks []packageSpec
+	var block *packageSpec // The dnf5 block being read
+	for line := range bytes.Lines(output) {
+		text := strings.TrimSpace(string(line))
+		switch {
+		case text == "" || strings.HasPrefix(text, "#") || strings.HasPrefix(text, "!"):
+		case strings.HasPrefix(text, "Package name:"):
+			locks = append(locks, packageSpec{name: strings.TrimSpace(strings.TrimPrefix(text, "Package name:"))})
+			block = &locks[len(locks)-1]
+		case strings.HasPrefix(text, "evr = ") && block != nil:
+			evr := parsePackageSpec(block.name + "-" + strings.TrimPrefix(text, "evr = "))
+			block.epoch, block.version, block.release = evr.epoch, evr.version, evr.release
+		case !strings.ContainsAny(text, " \t"):
+			lock := parsePackageSpec(strings.TrimSuffix(text, ".*"))
+			if lock.version != "" {
+				locks = append(locks, lock)
+			}
+		}
+	}
+	return locks
+}
+
+// locks reports whether the lock covers the package of spec, whose name
+// may be a glob. Fields that either of them leaves empty match anything,
+// and a missing epoch is epoch 0.
+func (lock packageSpec) locks(spec packageSpec) bool {
+	if ok, _ := path.Match(spec.name, lock.name); !ok {
+		return false
+	}
+	match := func(a, b string) bool { return a == "" || b == "" || a == b }
+	epoch := func(e string) string {
+		if e == "" {
+			return "0"
+		}
+		return e
+	}
+	return match(lock.version, spec.version) && match(lock.release, spec.release) && match(lock.arch, spec.arch) &&
+		(spec.epoch == "" || lock.version == "" || epoch(lock.epoch) == spec.epoch)
+}
+
+// load runs the rpm query, unless the inventory is already loaded.
+func (inv *PackageInventory) load(r utils.Runner) error {
+	if inv.loaded {
+		return nil
+	}
+
+	pkgLog.Info("Loading installed package inventory...")
+	output, err := r.Output("rpm", "-qa", "--queryformat", rpmQueryFormat)
+	if err != nil {
+		return fail(CategoryPackage, "Check that the rpm database is readable (`rpm -qa`)",
+			fmt.Errorf("failed to query installed packages: %w", err))
+	}
+
+	inv.forms = make(map[string]bool)
+	inv.provides = make(map[string]bool)
+	inv.files = make(map[string]bool)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	scanner.Buffer(nil, 1024*1024) // Packages with many provides make long lines
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 5 {
+			continue
+		}
+		p := InstalledPackage{Name: fields[0], Epoch: fields[1], Version: fields[2], Release: fields[3], Arch: fields[4]}
+		inv.packages = append(inv.packages, p)
+		for _, form := range p.specs() {
+			inv.forms[form] = true
+		}
+		for _, provide := range fields[5:] {
+			inv.provides[provide] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fail(CategoryPackage, "", fmt.Errorf("failed to parse installed packages: %w", err))
+	}
+
+	inv.loaded = true
+	pkgLog.Infof("Found %d installed packages", len(inv.packages))
+	return nil
+}
+
+// loadFiles records which of paths belong to an installed package.
+func (inv *PackageInventory) loadFiles(r utils.Runner, paths []string) error {
+	wanted := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		wanted[p] = true
+		inv.files[p] = false
+	}
+
+	output, err := r.Output("rpm", "-qa", "--queryformat", rpmFilesFormat)
+	if err != nil {
+		return fail(CategoryPackage, "Check that the rpm database is readable (`rpm -qa`)",
+			fmt.Errorf("failed to query installed files: %w", err))
+	}
+	for line := range bytes.Lines(output) {
+		if file := string(bytes.TrimSuffix(line, []byte("\n"))); wanted[file] {
+			inv.files[file] = true
+		}
+	}
+	return nil
+}
+
+// matchGlob reports whether a glob spec matches any package form.
+func (inv *PackageInventory) matchGlob(spec string) bool {
+	for form := range inv.forms {
+		if ok, _ := path.Match(spec, form); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,209 @@
+package ops
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/acker1019/fedora-phoenix/internal/utils/utilstest"
+)
+
+// rpmFilesQuery is the file query as the FakeRunner renders it
+const rpmFilesQuery = "rpm -qa --queryformat " + rpmFilesFormat
+
+func TestPackageInventoryLoad(t *testing.T) {
+	r := utilstest.NewFakeRunner()
+	r.Expect(rpmQuery).Stdout(installedDB + "truncated\t0\n\n")
+
+	got, err := NewPackageInventory().Packages(r)
+	if err != nil {
+		t.Fatalf("Packages() error = %v", err)
+	}
+	want := []InstalledPackage{
+		{Name: "vim-enhanced", Epoch: "2", Version: "9.1.0", Release: "1.fc41", Arch: "x86_64"},
+		{Name: "kernel", Epoch: "0", Version: "6.11.4", Release: "301.fc41", Arch: "x86_64"},
+		{Name: "htop", Epoch: "0", Version: "3.3.0", Release: "1.fc41", Arch: "x86_64"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Packages() = %+v, want %+v", got, want)
+	}
+	if nevra := got[0].NEVRA(); nevra != "vim-enhanced-2:9.1.0-1.fc41.x86_64" {
+		t.Errorf("NEVRA() = %q", nevra)
+	}
+	if nevra := got[1].NEVRA(); nevra != "kernel-6.11.4-301.fc41.x86_64" {
+		t.Errorf("NEVRA() without epoch = %q", nevra)
+	}
+}
+
+func TestInstalledPackageSpecs(t *testing.T) {
+	p := InstalledPackage{Name: "vim-enhanced", Epoch: "2", Version: "9.1.0", Release: "1.fc41", Arch: "x86_64"}
+	want := []string{
+		"vim-enhanced",
+		"vim-enhanced.x86_64",
+		"vim-enhanced-9.1.0",
+		"vim-enhanced-9.1.0-1.fc41",
+		"vim-enhanced-9.1.0-1.fc41.x86_64",
+		"vim-enhanced-2:9.1.0-1.fc41",
+		"vim-enhanced-2:9.1.0-1.fc41.x86_64",
+	}
+	if got := p.specs(); !slices.Equal(got, want) {
+		t.Errorf("specs() = %q, want %q", got, want)
+	}
+}
+
+func TestPackageInventorySatisfied(t *testing.T) {
+	r := utilstest.NewFakeRunner()
+	r.Expect(rpmQuery).Stdout(installedDB)
+	r.Expect(rpmFilesQuery).Stdout("/usr/bin/vim\n/usr/bin/htop\n/usr/share/doc/htop/README\n")
+
+	inv := NewPackageInventory()
+	specs := []string{
+		"vim",                         // Provide
+		"kernel-6.11.4-301.fc41",      // Version-release form
+		"htop.x86_64",                 // Arch form
+		"vim-enhanced-9.1.0*",         // Glob over forms
+		"/usr/bin/htop",               // Owned file
+		"/usr/bin/tmux",               // File nobody owns
+		"tmux",                        // Not installed
+		"kernel-6.12.0",               // Other version
+		"vim-enhanced-1:9.1.0-1.fc41", // Other epoch
+	}
+	got, err := inv.Satisfied(r, specs)
+	if err != nil {
+		t.Fatalf("Satisfied() error = %v", err)
+	}
+	want := map[string]bool{
+		"vim":                    true,
+		"kernel-6.11.4-301.fc41": true,
+		"htop.x86_64":            true,
+		"vim-enhanced-9.1.0*":    true,
+		"/usr/bin/htop":          true,
+	}
+	for _, spec := range specs {
+		if got[spec] != want[spec] {
+			t.Errorf("Satisfied()[%q] = %v, want %v", spec, got[spec], want[spec])
+		}
+	}
+
+	// Paths asked about before are answered without another query
+	got, err = inv.Satisfied(r, []string{"/usr/bin/htop", "/usr/bin/tmux"})
+	if err != nil {
+		t.Fatalf("Satisfied() error = %v", err)
+	}
+	if want := map[string]bool{"/usr/bin/htop": true}; !maps.Equal(got, want) {
+		t.Errorf("Satisfied() of known paths = %v, want %v", got, want)
+	}
+	if unmet := r.Unmet(); unmet != nil {
+		t.Errorf("runner script not followed: %q", unmet)
+	}
+}
+
+func TestPackageInventoryInvalidate(t *testing.T) {
+	r := utilstest.NewFakeRunner()
+	r.Expect(rpmQuery).Stdout(installedDB)
+	r.Expect(rpmQuery).Stdout(pluginDB)
+
+	inv := NewPackageInventory()
+	const plugin = "python3-dnf-plugin-versionlock"
+	if got, _ := inv.Satisfied(r, []string{plugin}); got[plugin] {
+		t.Fatalf("%s satisfied before it was installed", plugin)
+	}
+	inv.Invalidate()
+	if got, _ := inv.Satisfied(r, []string{plugin}); !got[plugin] {
+		t.Errorf("%s not satisfied after Invalidate", plugin)
+	}
+	if unmet := r.Unmet(); unmet != nil {
+		t.Errorf("runner script not followed: %q", unmet)
+	}
+}
+
+// dnf4VersionLocks is `dnf versionlock list` with the dnf4 plugin
+const dnf4VersionLocks = `Last metadata expiration check: 0:12:03 ago on Wed 16 Oct 2024 10:00:00 AM CEST.
+# Added lock on Wed Oct 16 10:01:00 2024
+kernel-0:6.11.4-301.fc41.*
+vim-enhanced-2:9.1.0-1.fc41.*
+!htop-0:3.3.0-1.fc41.*
+`
+
+// dnf5VersionLocks is `dnf versionlock list` with dnf5
+const dnf5VersionLocks = `# Added by 'versionlock add' command on 2024-10-16 10:01:00
+Package name: kernel
+evr = 6.11.4-301.fc41
+
+# Added by 'versionlock add' command on 2024-10-16 10:02:00
+Package name: vim-enhanced
+evr = 2:9.1.0-1.fc41
+`
+
+func TestParseVersionLocks(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []packageSpec
+	}{
+		{
+			name:   "dnf4",
+			output: dnf4VersionLocks,
+			want: []packageSpec{
+				{name: "kernel", epoch: "0", version: "6.11.4", release: "301.fc41"},
+				{name: "vim-enhanced", epoch: "2", version: "9.1.0", release: "1.fc41"},
+			},
+		},
+		{
+			name:   "dnf5",
+			output: dnf5VersionLocks,
+			want: []packageSpec{
+				{name: "kernel", version: "6.11.4", release: "301.fc41"},
+				{name: "vim-enhanced", epoch: "2", version: "9.1.0", release: "1.fc41"},
+			},
+		},
+		{name: "no locks", output: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseVersionLocks([]byte(tt.output)); !slices.Equal(got, tt.want) {
+				t.Errorf("parseVersionLocks() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPackageInventoryLocked(t *testing.T) {
+	tests := []struct {
+		spec string
+		want bool
+	}{
+		{spec: "kernel", want: true},
+		{spec: "kernel-6.11.4-301.fc41", want: true},
+		{spec: "kernel-0:6.11.4-301.fc41.x86_64", want: true},
+		{spec: "kernel-6.12.0", want: false},
+		{spec: "vim-enhanced-9.1.0-1.fc41", want: true},
+		{spec: "vim-enhanced-1:9.1.0-1.fc41", want: false},
+		{spec: "vim", want: false}, // Only vim-enhanced is locked
+		{spec: "kernel-core", want: false},
+		{spec: "htop", want: false}, // Excluded, not locked
+		{spec: "vim-*", want: true},
+	}
+	for _, output := range []string{dnf4VersionLocks, dnf5VersionLocks} {
+		inv := NewPackageInventory()
+		r := utilstest.NewFakeRunner()
+		r.Expect("dnf versionlock list").Stdout(output)
+		for _, tt := range tests {
+			if got := inv.Locked(r, tt.spec); got != tt.want {
+				t.Errorf("Locked(%q) = %v, want %v, with locks:\n%s", tt.spec, got, tt.want, output)
+			}
+		}
+		if unmet := r.Unmet(); unmet != nil {
+			t.Errorf("lock list not loaded once: %q", unmet)
+		}
+	}
+}
+
+func TestPackageInventoryLockedWithoutPlugin(t *testing.T) {
+	r := utilstest.NewFakeRunner()
+	r.Expect("dnf versionlock list").Exit(1).Stderr("No such command: versionlock")
+
+	if NewPackageInventory().Locked(r, "kernel") {
+		t.Error("Locked() = true without the versionlock plugin")
+	}
+}
@@ -0,0 +1,95 @@
+package ops
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/acker1019/fedora-phoenix/internal/utils"
+)
+
+// Keyfile is a LUKS key stored on removable media (e.g. a USB stick),
+// found by the filesystem label of the medium.
+type Keyfile struct {
+	Label  string // Filesystem label of the medium
+	Path   string // Key file, relative to the medium's root
+	Offset int64  // cryptsetup --keyfile-offset (0: none)
+	Size   int64  // cryptsetup --keyfile-size (0: whole file)
+}
+
+// String describes the key for logs and plans.
+func (k *Keyfile) String() string {
+	return fmt.Sprintf("keyfile %s on label:%s", k.Path, k.Label)
+}
+
+// Present reports whether the medium is attached.
+func (k *Keyfile) Present() bool {
+	return devicePresent(k.device())
+}
+
+func (k *Keyfile) device() string {
+	return "label:" + k.Label
+}
+
+// args are the cryptsetup options that read the key from path.
+func (k *Keyfile) args(path string) []string {
+	args := []string{"--key-file", path}
+	if k.Offset > 0 {
+		args = append(args, "--keyfile-offset", strconv.FormatInt(k.Offset, 10))
+	}
+	if k.Size > 0 {
+		args = append(args, "--keyfile-size", strconv.FormatInt(k.Size, 10))
+	}
+	return args
+}
+
+// use calls fn with the absolute path of the key file. A medium that is
+// already mounted (e.g. by the desktop) is used in place; otherwise it is
+// mounted read-only on a private directory for the duration of fn.
+func (k *Keyfile) use(r utils.Runner, fn func(path string) error) error {
+	dev, err := utils.ResolveDevice(k.device())
+	if err != nil {
+		return fail(CategoryDevice, "Plug in the key medium", err)
+	}
+
+	mounts, err := utils.ReadMountInfo()
+	if err != nil {
+		return fail(CategoryDevice, "", err)
+	}
+	for _, m := range mounts {
+		if m.Root == "/" && sameDevice(m, dev) {
+			return k.withKey(filepath.Join(m.MountPoint, k.Path), fn)
+		}
+	}
+
+	dir, err := os.MkdirTemp("/run", "phoenix-key-")
+	if err != nil {
+		return fail(CategoryPermission, "", fmt.Errorf("failed to create key mount point: %w", err))
+	}
+	defer os.Remove(dir)
+
+	luksLog.Infof("Mounting key medium %s read-only", describeDevice(k.device(), dev))
+	if err := r.Run("mount", "-o", "ro,nosuid,nodev,noexec", dev, dir); err != nil {
+		return fail(CategoryDevice, "Check the key medium's filesystem", fmt.Errorf("failed to mount key medium %s: %w", dev, err))
+	}
+	defer func() {
+		if err := r.Run("umount", dir); err != nil {
+			luksLog.Warnf("Failed to unmount key medium %s: %v", dir, err)
+		}
+	}()
+
+	return k.withKey(filepath.Join(dir, k.Path), fn)
+}
+
+// withKey checks that the key file exists before handing it to fn.
+func (k *Keyfile) withKey(path string, fn func(path string) error) error {
+	if _, err := os.Stat(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fail(CategoryConfig, "Check keyfile.path of this volume", fmt.Errorf("%s not found on label:%s: %w", k.Path, k.Label, os.ErrNotExist))
+		}
+		return fail(CategoryPermission, "", err)
+	}
+	return fn(path)
+}
@@ -1,84 +1,409 @@
 package ops
 
 import (
+	"errors"
 	"fmt"
-	"io"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"strings"
 
 	"github.com/acker1019/fedora-phoenix/internal/logging"
+	"github.com/acker1019/fedora-phoenix/internal/secret"
+	"github.com/acker1019/fedora-phoenix/internal/utils"
+	"golang.org/x/sys/unix"
 )
 
 var luksLog = logging.WithSource("ops/luks")
 
-// UnlockLuks unlocks the device using the provided password string.
-// Updated signature: accepts 'password' as the 3rd argument.
-func UnlockLuks(devicePath, mapperName, password string) error {
-	// Idempotency check: if /dev/mapper/xxx exists, we are good.
-	mapperPath := fmt.Sprintf("/dev/mapper/%s", mapperName)
-	if _, err := os.Stat(mapperPath); err == nil {
-		luksLog.Infof("Device %s is already unlocked. Skipping.", mapperName)
-		return nil
-	}
+// UnlockLuks unlocks the device using the provided password string, or
+// with Keyfile when its medium is attached (falling back to the password).
+// Idempotent: skips if /dev/mapper/<MapperName> already exists.
+//
+// Device is a path or a uuid:/partuuid:/partlabel:/label: selector. Before
+// the password is sent, the resolved device must carry a LUKS header, and
+// its LUKS UUID must match LuksUUID (or the uuid: selector) when one is given.
+//
+// With Prompt set, a missing password is asked for, and a wrong one
+// (cryptsetup exit code 2) is asked for again, up to MaxPasswordAttempts.
+type UnlockLuks struct {
+	Device     string         // LUKS partition (e.g. /dev/nvme0n1p4 or uuid:...)
+	MapperName string         // Name under /dev/mapper
+	Password   *secret.Value  // Piped to cryptsetup via stdin, never via arguments
+	LuksUUID   string         // Expected LUKS header UUID; empty skips the check
+	Keyfile    *Keyfile       // Preferred over Password when its medium is present
+	Prompt     PasswordPrompt // Asks the user for the password; nil disables prompting
+	Optional   bool           // Skip instead of failing when Device is absent
+}
+
+// PasswordPrompt asks for the password of a volume. attempt starts at 1.
+// UnlockLuks destroys the returned value once it has been tried.
+type PasswordPrompt func(mapperName string, attempt int) (*secret.Value, error)
+
+// MaxPasswordAttempts bounds the password tries of UnlockLuks.
+const MaxPasswordAttempts = 3
+
+// cryptsetup exit code for "no key available with this passphrase"
+const cryptsetupBadPassphrase = 2
 
-	luksLog.Infof("Unlocking %s with injected credentials...", devicePath)
+func (a *UnlockLuks) Name() string { return "UnlockLuks" }
+func (a *UnlockLuks) Block() Block { return BlockInfrastructure }
 
-	// Command: cryptsetup open <device> <name> --type luks -
-	cmd := exec.Command("cryptsetup", "open", devicePath, mapperName, "--type", "luks")
+// Check reports whether the mapper device is already unlocked.
+// It never touches the password or the underlying device.
+func (a *UnlockLuks) Check(r utils.Runner) ([]Diff, error) {
+	item := fmt.Sprintf("LUKS %s", a.MapperName)
 
-	// Security: Pipe password to stdin
-	stdin, err := cmd.StdinPipe()
+	// Idempotency check: if /dev/mapper/xxx exists, we are good.
+	if isLuksUnlocked(a.MapperName) {
+		luksLog.Infof("Device %s is already unlocked. Skipping.", a.MapperName)
+		return []Diff{satisfied(a.MapperName, item, "already unlocked")}, nil
+	}
+
+	dev, err := utils.ResolveDevice(a.Device)
+	if errors.Is(err, os.ErrNotExist) && a.Optional {
+		luksLog.Warnf("Optional device %s is not present. Skipping.", a.Device)
+		return []Diff{satisfied(a.MapperName, item, fmt.Sprintf("optional device %s not present, skipped", a.Device))}, nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to open stdin pipe: %w", err)
+		return nil, fail(CategoryDevice, "Check infrastructure.luks device against `ls -l /dev/disk/by-*`", err)
+	}
+	detail := fmt.Sprintf("locked, would unlock %s", describeDevice(a.Device, dev))
+	switch {
+	case a.Keyfile != nil && a.Keyfile.Present():
+		detail += " with " + a.Keyfile.String()
+	case a.Keyfile != nil:
+		detail += fmt.Sprintf(" with the password (key medium label:%s not present)", a.Keyfile.Label)
 	}
+	return []Diff{pending(a.MapperName, item, detail)}, nil
+}
 
-	// Capture output
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+// Apply opens the LUKS device with cryptsetup.
+func (a *UnlockLuks) Apply(r utils.Runner, pending []Diff) error {
+	// Safety: never send the password to a device that is not the expected LUKS volume
+	dev, err := ResolveLuksDevice(r, a.Device, a.LuksUUID)
+	if err != nil {
+		return err
+	}
 
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start cryptsetup: %w", err)
+	if a.Keyfile != nil {
+		done, err := a.unlockWithKeyfile(r, dev)
+		if done || err != nil {
+			return err
+		}
 	}
 
-	// Write password and close pipe
-	go func() {
-		defer stdin.Close()
-		io.WriteString(stdin, password)
-	}()
+	password := a.Password
+	for attempt := 1; ; attempt++ {
+		if password.Empty() {
+			if a.Prompt == nil {
+				return fail(CategoryAuth, "Add the volume's password to the secrets file",
+					fmt.Errorf("no password available for %s", a.MapperName))
+			}
+			if password, err = a.Prompt(a.MapperName, attempt); err != nil {
+				return fail(CategoryAuth, "", fmt.Errorf("failed to read password for %s: %w", a.MapperName, err))
+			}
+			if password.Empty() {
+				return fail(CategoryAuth, "", fmt.Errorf("no password entered for %s", a.MapperName))
+			}
+		}
+
+		luksLog.Infof("Unlocking %s with injected credentials...", describeDevice(a.Device, dev))
 
-	if err := cmd.Wait(); err != nil {
-		return fmt.Errorf("cryptsetup failed (invalid password?): %w", err)
+		// Command: cryptsetup open <device> <name> --type luks
+		// Security: Pipe password to stdin straight from locked memory
+		err = r.RunWithStdin(password.Reader(), "cryptsetup", "open", dev, a.MapperName, "--type", "luks")
+		if password != a.Password {
+			password.Destroy() // Prompted, owned by this Act
+		}
+		if err == nil {
+			break
+		}
+
+		var cmdErr *utils.CommandError
+		badPassphrase := errors.As(err, &cmdErr) && cmdErr.ExitCode == cryptsetupBadPassphrase
+		if a.Prompt == nil || !badPassphrase || attempt >= MaxPasswordAttempts {
+			return openError(dev, err)
+		}
+		luksLog.Warnf("Wrong password for %s (attempt %d/%d)", a.MapperName, attempt, MaxPasswordAttempts)
+		password = nil
 	}
 
 	luksLog.Info("LUKS unlocked successfully")
 	return nil
 }
 
-// MountDevice mounts the unlocked mapper device to the target path.
-func MountDevice(mapperName, mountPoint string) error {
-	// Construct full device path from mapper name
-	devicePath := fmt.Sprintf("/dev/mapper/%s", mapperName)
-
-	// Check if already mounted
-	// Using `mountpoint -q` is the easiest way in shell, usually safe to exec.
-	if err := exec.Command("mountpoint", "-q", mountPoint).Run(); err == nil {
-		luksLog.Infof("%s is already mounted. Skipping.", mountPoint)
+// unlockWithKeyfile tries the keyfile. It reports done when the volume is
+// unlocked, or false (without error) when the password should be tried instead.
+func (a *UnlockLuks) unlockWithKeyfile(r utils.Runner, dev string) (bool, error) {
+	if !a.Keyfile.Present() {
+		if a.Password.Empty() && a.Prompt == nil {
+			return false, fail(CategoryDevice, "Plug in the key medium, or add the volume's password to the secrets file",
+				fmt.Errorf("key medium label:%s is not present and no password is available for %s", a.Keyfile.Label, a.MapperName))
+		}
+		luksLog.Warnf("Key medium label:%s is not present. Falling back to the password.", a.Keyfile.Label)
+		return false, nil
+	}
+
+	err := a.Keyfile.use(r, func(path string) error {
+		luksLog.Infof("Unlocking %s with %s...", describeDevice(a.Device, dev), a.Keyfile)
+		args := append([]string{"open", dev, a.MapperName, "--type", "luks"}, a.Keyfile.args(path)...)
+		if err := r.Run("cryptsetup", args...); err != nil {
+			return openError(dev, err)
+		}
 		return nil
+	})
+	if err == nil {
+		luksLog.Info("LUKS unlocked successfully")
+		return true, nil
+	}
+	if a.Password.Empty() && a.Prompt == nil {
+		return false, err
+	}
+	luksLog.Warnf("Unlocking with %s failed: %v. Falling back to the password.", a.Keyfile, err)
+	return false, nil
+}
+
+// openError categorizes a failed `cryptsetup open` by its exit code.
+func openError(dev string, err error) error {
+	var cmdErr *utils.CommandError
+	exitCode := -1
+	if errors.As(err, &cmdErr) {
+		exitCode = cmdErr.ExitCode
+	}
+	category, hint := cryptsetupHint(exitCode)
+	return fail(category, hint, fmt.Errorf("failed to unlock LUKS device %s: %w", dev, err))
+}
+
+// ResolveLuksDevice resolves a blueprint device spec to its device node and
+// checks that it is the expected LUKS volume (see VerifyLuksHeader).
+func ResolveLuksDevice(r utils.Runner, device, luksUUID string) (string, error) {
+	dev, err := utils.ResolveDevice(device)
+	if err != nil {
+		return "", fail(CategoryDevice, "Check infrastructure.luks device against `ls -l /dev/disk/by-*`", err)
+	}
+	if err := VerifyLuksHeader(r, dev, expectedUUID(device, luksUUID)); err != nil {
+		return "", err
+	}
+	return dev, nil
+}
+
+// expectedUUID is luksUUID, or the value of a uuid: selector
+// (udev's by-uuid link of a LUKS partition is its header UUID).
+func expectedUUID(device, luksUUID string) string {
+	if luksUUID != "" {
+		return luksUUID
+	}
+	if uuid, ok := strings.CutPrefix(device, "uuid:"); ok {
+		return uuid
+	}
+	return ""
+}
+
+// VerifyLuksHeader checks that dev carries a LUKS header and, if
+// expectedUUID is set, that the header UUID matches it.
+func VerifyLuksHeader(r utils.Runner, dev, expectedUUID string) error {
+	if _, err := r.Output("cryptsetup", "isLuks", dev); err != nil {
+		return fail(CategoryDevice, "The device has no LUKS header: check that infrastructure.luks points to the right partition",
+			fmt.Errorf("%s is not a LUKS device: %w", dev, err))
+	}
+	if expectedUUID == "" {
+		return nil
+	}
+
+	out, err := r.Output("cryptsetup", "luksUUID", dev)
+	if err != nil {
+		return fail(CategoryDevice, "Run `cryptsetup luksUUID` manually to inspect the header",
+			fmt.Errorf("failed to read LUKS UUID of %s: %w", dev, err))
+	}
+	if got := strings.TrimSpace(string(out)); !strings.EqualFold(got, expectedUUID) {
+		return fail(CategoryDevice, "Another LUKS volume is at this path: check infrastructure.luks device and luks_uuid",
+			fmt.Errorf("LUKS UUID of %s is %s, expected %s", dev, got, expectedUUID))
+	}
+	return nil
+}
+
+// describeDevice shows a selector together with the node it resolved to.
+func describeDevice(spec, dev string) string {
+	if spec == dev {
+		return dev
+	}
+	return fmt.Sprintf("%s (%s)", spec, dev)
+}
+
+// isLuksUnlocked reports whether /dev/mapper/<mapperName> exists.
+func isLuksUnlocked(mapperName string) bool {
+	_, err := os.Stat(fmt.Sprintf("/dev/mapper/%s", mapperName))
+	return err == nil
+}
+
+// devicePresent reports whether a device spec resolves to an existing device.
+func devicePresent(spec string) bool {
+	_, err := utils.ResolveDevice(spec)
+	return err == nil
+}
+
+// MountDevice mounts the unlocked mapper device to the target path.
+// Idempotent: skips if the mount point already holds this mapper device
+// (and subvolume); any other filesystem mounted there is an error.
+type MountDevice struct {
+	MapperName string
+	MountPoint string
+	FSType     string // mount -t; empty lets mount probe
+	Options    string // mount -o (e.g. "noatime,compress=zstd")
+	Subvolume  string // btrfs subvolume, added as subvol=
+	Fsck       bool   // Read-only health check before mounting
+	Device     string // LUKS partition spec, only probed for optional volumes
+	Optional   bool   // Skip when the volume was skipped by UnlockLuks
+}
+
+func (a *MountDevice) Name() string { return "MountDevice" }
+func (a *MountDevice) Block() Block { return BlockInfrastructure }
+
+// Check reports whether the mount point already has the mapper device mounted.
+func (a *MountDevice) Check(r utils.Runner) ([]Diff, error) {
+	item := fmt.Sprintf("mount %s", a.MountPoint)
+
+	mounts, err := utils.ReadMountInfo()
+	if err != nil {
+		return nil, fail(CategoryDevice, "", err)
+	}
+	if m, ok := utils.FindMount(mounts, a.MountPoint); ok {
+		if err := a.verifyMount(m); err != nil {
+			return nil, err
+		}
+		luksLog.Infof("%s is already mounted. Skipping.", a.MountPoint)
+		return []Diff{satisfied(a.MountPoint, item, "already mounted")}, nil
+	}
+	if a.Optional && !isLuksUnlocked(a.MapperName) && !devicePresent(a.Device) {
+		return []Diff{satisfied(a.MountPoint, item, fmt.Sprintf("optional device %s not present, skipped", a.Device))}, nil
 	}
 
+	detail := fmt.Sprintf("not mounted, would mount %s", a.devicePath())
+	if args := a.mountArgs(); len(args) > 2 {
+		detail += fmt.Sprintf(" (%s)", strings.Join(args[:len(args)-2], " "))
+	}
+	if a.Fsck {
+		detail += " after a read-only fsck"
+	}
+	return []Diff{pending(a.MountPoint, item, detail)}, nil
+}
+
+// Apply creates the mount point, optionally checks the filesystem and mounts the mapper device.
+func (a *MountDevice) Apply(r utils.Runner, pending []Diff) error {
 	// Ensure directory exists
-	if err := os.MkdirAll(mountPoint, 0755); err != nil {
-		return fmt.Errorf("failed to mkdir %s: %w", mountPoint, err)
+	if err := os.MkdirAll(a.MountPoint, 0755); err != nil {
+		return fail(CategoryPermission, "Check that the mount point's parent directory is writable by root",
+			fmt.Errorf("failed to mkdir %s: %w", a.MountPoint, err))
 	}
 
-	luksLog.Infof("Mounting %s -> %s", devicePath, mountPoint)
-	cmd := exec.Command("mount", devicePath, mountPoint)
-	cmd.Stderr = os.Stderr
+	if a.Fsck {
+		if err := a.fsck(r); err != nil {
+			return err
+		}
+	}
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("mount failed: %w", err)
+	luksLog.Infof("Mounting %s -> %s", a.devicePath(), a.MountPoint)
+	if err := r.Run("mount", a.mountArgs()...); err != nil {
+		return fail(CategoryDevice, "Check that the LUKS device is unlocked and contains a valid filesystem (fs_type, mount_options)",
+			fmt.Errorf("mount failed: %w", err))
 	}
 
 	luksLog.Info("Mount completed successfully")
 	return nil
 }
+
+// mountArgs builds `[-t type] [-o options] device mountpoint`.
+func (a *MountDevice) mountArgs() []string {
+	var args []string
+	if a.FSType != "" {
+		args = append(args, "-t", a.FSType)
+	}
+	if options := MountOptions(a.Options, a.Subvolume); options != "" {
+		args = append(args, "-o", options)
+	}
+	return append(args, a.devicePath(), a.MountPoint)
+}
+
+// MountOptions joins the blueprint options with a btrfs subvol= option.
+func MountOptions(options, subvolume string) string {
+	var opts []string
+	if options != "" {
+		opts = append(opts, options)
+	}
+	if subvolume != "" {
+		opts = append(opts, "subvol="+subvolume)
+	}
+	return strings.Join(opts, ",")
+}
+
+// verifyMount checks that the filesystem mounted at MountPoint is the mapper
+// device (and subvolume) rather than some unrelated filesystem.
+func (a *MountDevice) verifyMount(m utils.MountInfo) error {
+	if !sameDevice(m, a.devicePath()) {
+		return fail(CategoryDevice, fmt.Sprintf("Unmount it with `umount %s` or choose another mount_point", a.MountPoint),
+			fmt.Errorf("%s is already mounted from %s, expected %s", a.MountPoint, m.Source, a.devicePath()))
+	}
+	if a.Subvolume != "" && strings.Trim(m.Root, "/") != strings.Trim(a.Subvolume, "/") {
+		return fail(CategoryDevice, fmt.Sprintf("Unmount it with `umount %s` and run provision again", a.MountPoint),
+			fmt.Errorf("%s has subvolume %s mounted, expected %s", a.MountPoint, m.Root, a.Subvolume))
+	}
+	return nil
+}
+
+// sameDevice compares a mount's source with dev, by resolved path or by
+// device number (btrfs reports an anonymous device number, hence both).
+func sameDevice(m utils.MountInfo, dev string) bool {
+	want, err := filepath.EvalSymlinks(dev)
+	if err != nil {
+		return false
+	}
+	if src, err := filepath.EvalSymlinks(m.Source); err == nil && src == want {
+		return true
+	}
+
+	var st unix.Stat_t
+	if err := unix.Stat(want, &st); err != nil {
+		return false
+	}
+	return m.MajorMinor == fmt.Sprintf("%d:%d", unix.Major(st.Rdev), unix.Minor(st.Rdev))
+}
+
+// fsck runs a read-only filesystem check. fsck -n is a no-op for btrfs and
+// xfs, so their own read-only checkers are used instead.
+func (a *MountDevice) fsck(r utils.Runner) error {
+	dev := a.devicePath()
+	fsType := a.FSType
+	if fsType == "" {
+		out, _ := r.Output("blkid", "-s", "TYPE", "-o", "value", dev)
+		fsType = strings.TrimSpace(string(out))
+	}
+
+	var args []string
+	switch fsType {
+	case "btrfs":
+		args = []string{"btrfs", "check", "--readonly", dev}
+	case "xfs":
+		args = []string{"xfs_repair", "-n", dev}
+	default:
+		args = []string{"fsck", "-n", dev}
+	}
+
+	luksLog.Infof("Checking filesystem on %s (read-only)", dev)
+	if err := r.Run(args[0], args[1:]...); err != nil {
+		return fail(CategoryDevice, fmt.Sprintf("The filesystem reports errors: repair it manually before mounting (`%s`)", strings.Join(args[:len(args)-1], " ")),
+			fmt.Errorf("filesystem check of %s failed: %w", dev, err))
+	}
+	return nil
+}
+
+// devicePath constructs the full device path from the mapper name.
+func (a *MountDevice) devicePath() string {
+	return fmt.Sprintf("/dev/mapper/%s", a.MapperName)
+}
+
+// IsMounted reports whether mountPoint is an active mount point.
+// Using `mountpoint -q` is the easiest way in shell, usually safe to exec.
+func IsMounted(r utils.Runner, mountPoint string) bool {
+	_, err := r.Output("mountpoint", "-q", mountPoint)
+	return err == nil
+}
@@ -0,0 +1,189 @@
+package ops
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/acker1019/fedora-phoenix/internal/secret"
+	"github.com/acker1019/fedora-phoenix/internal/utils/utilstest"
+)
+
+// fakeDevice creates a regular file standing in for a LUKS partition and
+// returns the path ResolveDevice resolves it to.
+func fakeDevice(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "nvme0n1p4")
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	dev, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dev
+}
+
+// testMapper is a mapper name that is never unlocked on the test machine.
+const testMapper = "phoenix-test-vault"
+
+func TestUnlockLuks(t *testing.T) {
+	dev := fakeDevice(t)
+	const uuid = "0b5e7a2c-41f3-4c52-9a0e-3d6f1c2b8e11"
+	open := "cryptsetup open " + dev + " " + testMapper + " --type luks"
+
+	runActCases(t, []actCase{
+		{
+			name: "optional device absent",
+			act:  constAct(&UnlockLuks{Device: "/dev/disk/by-id/phoenix-missing", MapperName: testMapper, Optional: true}),
+		},
+		{
+			name: "missing device",
+			act:  constAct(&UnlockLuks{Device: "/dev/disk/by-id/phoenix-missing", MapperName: testMapper}),
+			fail: CategoryDevice,
+		},
+		{
+			name: "unlocked with the password",
+			act: func(t *testing.T) Act {
+				return &UnlockLuks{Device: dev, MapperName: testMapper, LuksUUID: uuid, Password: secret.FromString("hunter2")}
+			},
+			script: func(r *utilstest.FakeRunner) {
+				r.Expect("cryptsetup isLuks " + dev)
+				r.Expect("cryptsetup luksUUID " + dev).Stdout(uuid + "\n")
+				r.Expect(open)
+			},
+			pending: []string{testMapper},
+		},
+		{
+			name: "not a LUKS device",
+			act: func(t *testing.T) Act {
+				return &UnlockLuks{Device: dev, MapperName: testMapper, Password: secret.FromString("hunter2")}
+			},
+			script: func(r *utilstest.FakeRunner) { r.Expect("cryptsetup isLuks " + dev).Exit(1) },
+			fail:   CategoryDevice,
+			exit:   1,
+		},
+		{
+			name: "another LUKS volume at the path",
+			act: func(t *testing.T) Act {
+				return &UnlockLuks{Device: dev, MapperName: testMapper, LuksUUID: uuid, Password: secret.FromString("hunter2")}
+			},
+			script: func(r *utilstest.FakeRunner) {
+				r.Expect("cryptsetup isLuks " + dev)
+				r.Expect("cryptsetup luksUUID " + dev).Stdout("7d1f0c4e-0000-4000-8000-000000000000\n")
+			},
+			fail: CategoryDevice,
+		},
+		{
+			name: "wrong password",
+			act: func(t *testing.T) Act {
+				return &UnlockLuks{Device: dev, MapperName: testMapper, Password: secret.FromString("hunter3")}
+			},
+			script: func(r *utilstest.FakeRunner) {
+				r.Expect("cryptsetup isLuks " + dev)
+				r.Expect(open).Exit(2).Stderr("No key available with this passphrase.")
+			},
+			fail: CategoryAuth,
+			exit: 2,
+		},
+		{
+			name: "no password",
+			act:  constAct(&UnlockLuks{Device: dev, MapperName: testMapper}),
+			script: func(r *utilstest.FakeRunner) {
+				r.Expect("cryptsetup isLuks " + dev)
+			},
+			fail: CategoryAuth,
+		},
+	})
+}
+
+func TestUnlockLuksPromptsAgainAfterWrongPassword(t *testing.T) {
+	dev := fakeDevice(t)
+	open := "cryptsetup open " + dev + " " + testMapper + " --type luks"
+	r := utilstest.NewFakeRunner()
+	r.Expect("cryptsetup isLuks " + dev)
+	r.Expect(open).Exit(2)
+	r.Expect(open)
+
+	var attempts []int
+	act := &UnlockLuks{
+		Device:     dev,
+		MapperName: testMapper,
+		Prompt: func(mapperName string, attempt int) (*secret.Value, error) {
+			attempts = append(attempts, attempt)
+			return secret.FromString([]string{"hunter3", "hunter2"}[attempt-1]), nil
+		},
+	}
+	if err := act.Apply(r, nil); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if unmet := r.Unmet(); unmet != nil {
+		t.Fatalf("runner script not followed: %q", unmet)
+	}
+
+	if len(attempts) != 2 || attempts[1] != 2 {
+		t.Errorf("prompt attempts = %v, want [1 2]", attempts)
+	}
+	if got := r.Calls[1].Stdin + "," + r.Calls[2].Stdin; got != "hunter3,hunter2" {
+		t.Errorf("passwords sent on stdin = %q, want %q", got, "hunter3,hunter2")
+	}
+	for _, c := range r.Calls {
+		for _, arg := range c.Args {
+			if arg == "hunter2" || arg == "hunter3" {
+				t.Errorf("password passed as an argument: %s", c)
+			}
+		}
+	}
+}
+
+func TestMountDevice(t *testing.T) {
+	dir := t.TempDir()
+	mnt := filepath.Join(dir, "vault")
+	dev := "/dev/mapper/" + testMapper
+
+	runActCases(t, []actCase{
+		{
+			name: "optional volume skipped",
+			act: constAct(&MountDevice{
+				MapperName: testMapper, MountPoint: mnt, Device: "/dev/disk/by-id/phoenix-missing", Optional: true,
+			}),
+		},
+		{
+			name:    "mounted with type and options",
+			act:     constAct(&MountDevice{MapperName: testMapper, MountPoint: mnt, FSType: "btrfs", Options: "noatime", Subvolume: "@data"}),
+			script:  func(r *utilstest.FakeRunner) { r.Expect("mount -t btrfs -o noatime,subvol=@data " + dev + " " + mnt) },
+			pending: []string{mnt},
+		},
+		{
+			name: "probed filesystem is checked before mounting",
+			act:  constAct(&MountDevice{MapperName: testMapper, MountPoint: mnt, Fsck: true}),
+			script: func(r *utilstest.FakeRunner) {
+				r.Expect("blkid -s TYPE -o value " + dev).Stdout("xfs\n")
+				r.Expect("xfs_repair -n " + dev)
+				r.Expect("mount " + dev + " " + mnt)
+			},
+			pending: []string{mnt},
+		},
+		{
+			name: "filesystem check reports errors",
+			act:  constAct(&MountDevice{MapperName: testMapper, MountPoint: mnt, FSType: "ext4", Fsck: true}),
+			script: func(r *utilstest.FakeRunner) {
+				r.Expect("fsck -n " + dev).Exit(4)
+			},
+			fail: CategoryDevice,
+			exit: 4,
+		},
+		{
+			name:   "mount fails",
+			act:    constAct(&MountDevice{MapperName: testMapper, MountPoint: mnt}),
+			script: func(r *utilstest.FakeRunner) { r.Expect("mount " + dev + " " + mnt).Exit(32) },
+			fail:   CategoryDevice,
+			exit:   32,
+		},
+		{
+			name: "another filesystem mounted there",
+			act:  constAct(&MountDevice{MapperName: testMapper, MountPoint: "/"}),
+			fail: CategoryDevice,
+		},
+	})
+}
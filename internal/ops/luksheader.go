@@ -0,0 +1,269 @@
+package ops
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/acker1019/fedora-phoenix/internal/logging"
+	"github.com/acker1019/fedora-phoenix/internal/utils"
+)
+
+var luksHeaderLog = logging.WithSource("ops/luksheader")
+
+// HeaderBackup is the result of BackupLuksHeader.
+type HeaderBackup struct {
+	Device       string
+	Path         string
+	ChecksumPath string // sha256sum(1) compatible, next to Path
+	SHA256       string
+	Changed      bool // False when the existing backup already matched the header
+}
+
+// BackupLuksHeader writes `cryptsetup luksHeaderBackup` of device to dest,
+// with a sha256sum file at dest.sha256. The backup is created under a
+// temporary name and renamed into place, so an existing backup is only
+// replaced by a complete one, and not at all when it is identical.
+//
+// The backup holds the keyslots: anyone with it and an old passphrase can
+// decrypt the volume, so it is written with mode 0600.
+func BackupLuksHeader(r utils.Runner, device, luksUUID, dest string) (*HeaderBackup, error) {
+	dev, err := ResolveLuksDevice(r, device, luksUUID)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return nil, fail(CategoryPermission, "", fmt.Errorf("failed to create %s: %w", filepath.Dir(dest), err))
+	}
+
+	// cryptsetup refuses to overwrite, so the temporary file must not exist yet
+	tmp := fmt.Sprintf("%s.phoenix-tmp-%d", dest, os.Getpid())
+	os.Remove(tmp)
+	defer os.Remove(tmp) // no-op after a successful rename
+
+	luksHeaderLog.Infof("Backing up LUKS header of %s", describeDevice(device, dev))
+	if err := r.Run("cryptsetup", "luksHeaderBackup", dev, "--header-backup-file", tmp); err != nil {
+		return nil, fail(CategoryDevice, "", fmt.Errorf("failed to back up LUKS header of %s: %w", dev, err))
+	}
+	if err := os.Chmod(tmp, 0600); err != nil {
+		return nil, fail(CategoryPermission, "", err)
+	}
+
+	sum, err := utils.FileSHA256(tmp)
+	if err != nil {
+		return nil, fail(CategoryPermission, "", err)
+	}
+	backup := &HeaderBackup{Device: dev, Path: dest, ChecksumPath: dest + ".sha256", SHA256: sum}
+
+	if old, err := utils.FileSHA256(dest); err == nil && old == sum {
+		luksHeaderLog.Infof("%s is up to date", dest)
+	} else {
+		if err := os.Rename(tmp, dest); err != nil {
+			return nil, fail(CategoryPermission, "", fmt.Errorf("failed to write %s: %w", dest, err))
+		}
+		backup.Changed = true
+	}
+
+	line := fmt.Sprintf("%s  %s\n", sum, filepath.Base(dest))
+	if err := os.WriteFile(backup.ChecksumPath, []byte(line), 0644); err != nil {
+		return nil, fail(CategoryPermission, "", fmt.Errorf("failed to write %s: %w", backup.ChecksumPath, err))
+	}
+	return backup, nil
+}
+
+// LuksReport is the header summary printed by `phoenix luks status`.
+type LuksReport struct {
+	Device   string        `json:"device"`
+	Version  int           `json:"version"`
+	UUID     string        `json:"uuid"`
+	Label    string        `json:"label,omitempty"`
+	Cipher   string        `json:"cipher"`
+	Keyslots []LuksKeyslot `json:"keyslots"` // Used keyslots only
+	Tokens   []LuksToken   `json:"tokens"`
+	Warnings []string      `json:"warnings"`
+}
+
+// LuksKeyslot is one used keyslot.
+type LuksKeyslot struct {
+	ID     int    `json:"id"`
+	Type   string `json:"type"`  // e.g. "luks2", or "luks1"
+	PBKDF  string `json:"pbkdf"` // e.g. "argon2id", "pbkdf2"
+	Cipher string `json:"cipher,omitempty"`
+}
+
+// LuksToken is a LUKS2 token (e.g. systemd-tpm2, systemd-fido2) and the keyslots it unlocks.
+type LuksToken struct {
+	ID       int    `json:"id"`
+	Type     string `json:"type"`
+	Keyslots []int  `json:"keyslots"`
+}
+
+// LuksStatus reads the header of device with `cryptsetup luksDump` and
+// flags weak or fragile setups.
+func LuksStatus(r utils.Runner, device, luksUUID string) (*LuksReport, error) {
+	dev, err := ResolveLuksDevice(r, device, luksUUID)
+	if err != nil {
+		return nil, err
+	}
+	out, err := r.Output("cryptsetup", "luksDump", dev)
+	if err != nil {
+		return nil, fail(CategoryDevice, "", fmt.Errorf("failed to read LUKS header of %s: %w", dev, err))
+	}
+
+	report, err := ParseLuksDump(string(out))
+	if err != nil {
+		return nil, fail(CategoryDevice, "", fmt.Errorf("failed to parse LUKS header of %s: %w", dev, err))
+	}
+	report.Device = dev
+	report.Warnings = luksWarnings(report)
+	return report, nil
+}
+
+// ParseLuksDump parses the LUKS1 or LUKS2 text output of `cryptsetup luksDump`.
+//
+// LUKS2 lists sections ("Keyslots:", "Tokens:", ...) of entries ("  0: luks2")
+// with tab-indented properties; LUKS1 has flat "Key Slot N: ENABLED" lines.
+func ParseLuksDump(dump string) (*LuksReport, error) {
+	report := &LuksReport{Keyslots: []LuksKeyslot{}, Tokens: []LuksToken{}}
+	var section string
+	var slot *LuksKeyslot
+	var token *LuksToken
+	var luks1Cipher, luks1Mode string
+
+	scanner := bufio.NewScanner(strings.NewReader(dump))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+
+		// LUKS1 keyslots: "Key Slot 0: ENABLED"
+		if rest, ok := strings.CutPrefix(trimmed, "Key Slot "); ok {
+			id, state, _ := strings.Cut(rest, ":")
+			n, err := strconv.Atoi(id)
+			if err == nil && strings.TrimSpace(state) == "ENABLED" {
+				report.Keyslots = append(report.Keyslots, LuksKeyslot{ID: n, Type: "luks1", PBKDF: "pbkdf2"})
+			}
+			continue
+		}
+
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch {
+		case !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t"):
+			// Top-level field or section header
+			section, slot, token = "", nil, nil
+			switch key {
+			case "Version":
+				report.Version, _ = strconv.Atoi(value)
+			case "UUID":
+				report.UUID = value
+			case "Label":
+				if value != "(no label)" {
+					report.Label = value
+				}
+			case "Cipher name":
+				luks1Cipher = value
+			case "Cipher mode":
+				luks1Mode = value
+			case "Data segments", "Keyslots", "Tokens", "Digests":
+				section = key
+			}
+
+		case strings.HasPrefix(line, "  ") && !strings.HasPrefix(line, "\t"):
+			// Entry of a LUKS2 section: "  0: luks2"
+			id, err := strconv.Atoi(key)
+			if err != nil {
+				continue
+			}
+			switch section {
+			case "Keyslots":
+				report.Keyslots = append(report.Keyslots, LuksKeyslot{ID: id, Type: value})
+				slot, token = &report.Keyslots[len(report.Keyslots)-1], nil
+			case "Tokens":
+				report.Tokens = append(report.Tokens, LuksToken{ID: id, Type: value, Keyslots: []int{}})
+				slot, token = nil, &report.Tokens[len(report.Tokens)-1]
+			default:
+				slot, token = nil, nil
+			}
+
+		default:
+			// Tab-indented property of the current entry
+			switch {
+			case section == "Data segments" && key == "cipher" && report.Cipher == "":
+				report.Cipher = value
+			case slot != nil && key == "PBKDF":
+				slot.PBKDF = value
+			case slot != nil && key == "Cipher":
+				slot.Cipher = value
+			case token != nil && key == "Keyslot":
+				// "Keyslot:    1" (one line per keyslot)
+				for _, f := range strings.Fields(value) {
+					if n, err := strconv.Atoi(f); err == nil {
+						token.Keyslots = append(token.Keyslots, n)
+					}
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if report.Version == 0 {
+		return nil, fmt.Errorf("no LUKS version in luksDump output")
+	}
+	if report.Version == 1 && luks1Cipher != "" {
+		report.Cipher = luks1Cipher + "-" + luks1Mode
+	}
+	return report, nil
+}
+
+// luksWarnings flags setups that are weak or one mistake away from data loss.
+func luksWarnings(report *LuksReport) []string {
+	warnings := []string{}
+	if report.Version == 1 {
+		warnings = append(warnings, "LUKS1 header: convert with `cryptsetup convert --type luks2` for argon2id keyslots")
+	}
+
+	passphrases := 0
+	for _, slot := range report.Keyslots {
+		// Token keyslots hold a random key, for which systemd-cryptenroll picks pbkdf2 on purpose
+		if tokenBound(report.Tokens, slot.ID) {
+			continue
+		}
+		passphrases++
+		if report.Version == 2 && slot.PBKDF == "pbkdf2" {
+			warnings = append(warnings, fmt.Sprintf("keyslot %d uses pbkdf2: re-enroll it with `cryptsetup luksConvertKey --pbkdf argon2id`", slot.ID))
+		}
+	}
+	switch {
+	case len(report.Keyslots) == 0:
+		warnings = append(warnings, "no keyslot in use: the volume cannot be unlocked")
+	case passphrases == 0:
+		warnings = append(warnings, "every keyslot is bound to a token: add a recovery passphrase with `cryptsetup luksAddKey`")
+	case len(report.Keyslots) == 1:
+		warnings = append(warnings, "single keyslot: a recovery passphrase (`cryptsetup luksAddKey`) and a header backup guard against losing it")
+	}
+	return warnings
+}
+
+// tokenBound reports whether a token (TPM2, FIDO2, ...) unlocks the keyslot.
+func tokenBound(tokens []LuksToken, slot int) bool {
+	for _, t := range tokens {
+		for _, s := range t.Keyslots {
+			if s == slot {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package ops
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseLuksDump(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		want     LuksReport
+		warnings []string
+	}{
+		{
+			fixture: "luksdump-luks1.txt",
+			want: LuksReport{
+				Version: 1,
+				UUID:    "3f1c9e27-5d4a-4b86-a0e2-7c58d1f3b940",
+				Cipher:  "aes-xts-plain64",
+				Keyslots: []LuksKeyslot{
+					{ID: 0, Type: "luks1", PBKDF: "pbkdf2"},
+					{ID: 1, Type: "luks1", PBKDF: "pbkdf2"},
+				},
+				Tokens: []LuksToken{},
+			},
+			warnings: []string{
+				"LUKS1 header: convert with `cryptsetup convert --type luks2` for argon2id keyslots",
+			},
+		},
+		{
+			fixture: "luksdump-luks2-tpm2.txt",
+			want: LuksReport{
+				Version: 2,
+				UUID:    "0b5e7a2c-41f3-4c52-9a0e-3d6f1c2b8e11",
+				Label:   "vault",
+				Cipher:  "aes-xts-plain64",
+				Keyslots: []LuksKeyslot{
+					{ID: 0, Type: "luks2", PBKDF: "argon2id", Cipher: "aes-xts-plain64"},
+					{ID: 1, Type: "luks2", PBKDF: "pbkdf2", Cipher: "aes-xts-plain64"},
+				},
+				Tokens: []LuksToken{{ID: 0, Type: "systemd-tpm2", Keyslots: []int{1}}},
+			},
+			// The TPM2 keyslot's pbkdf2 is not flagged, keyslot 0 is the recovery passphrase
+			warnings: []string{},
+		},
+		{
+			fixture: "luksdump-luks2-pbkdf2.txt",
+			want: LuksReport{
+				Version:  2,
+				UUID:     "7d1f0c4e-92b8-4e35-a6d0-51c3f8e2b794",
+				Cipher:   "aes-xts-plain64",
+				Keyslots: []LuksKeyslot{{ID: 0, Type: "luks2", PBKDF: "pbkdf2", Cipher: "aes-xts-plain64"}},
+				Tokens:   []LuksToken{},
+			},
+			warnings: []string{
+				"keyslot 0 uses pbkdf2: re-enroll it with `cryptsetup luksConvertKey --pbkdf argon2id`",
+				"single keyslot: a recovery passphrase (`cryptsetup luksAddKey`) and a header backup guard against losing it",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			dump, err := os.ReadFile(filepath.Join("testdata", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			report, err := ParseLuksDump(string(dump))
+			if err != nil {
+				t.Fatalf("ParseLuksDump() error = %v", err)
+			}
+			if !reflect.DeepEqual(*report, tt.want) {
+				t.Errorf("ParseLuksDump() =\n%+v\nwant\n%+v", *report, tt.want)
+			}
+			if got := luksWarnings(report); !reflect.DeepEqual(got, tt.warnings) {
+				t.Errorf("luksWarnings() = %q, want %q", got, tt.warnings)
+			}
+		})
+	}
+}
+
+func TestParseLuksDumpRejectsOtherOutput(t *testing.T) {
+	if _, err := ParseLuksDump("Device /dev/sda3 is not a valid LUKS device.\n"); err == nil {
+		t.Error("ParseLuksDump() succeeded without a LUKS version")
+	}
+}
+
+func TestLuksWarningsKeyslotCoverage(t *testing.T) {
+	tests := []struct {
+		name   string
+		report LuksReport
+		want   []string
+	}{
+		{
+			name:   "no keyslot",
+			report: LuksReport{Version: 2},
+			want:   []string{"no keyslot in use: the volume cannot be unlocked"},
+		},
+		{
+			name: "only token keyslots",
+			report: LuksReport{
+				Version:  2,
+				Keyslots: []LuksKeyslot{{ID: 1, Type: "luks2", PBKDF: "pbkdf2"}, {ID: 2, Type: "luks2", PBKDF: "pbkdf2"}},
+				Tokens: []LuksToken{
+					{ID: 0, Type: "systemd-tpm2", Keyslots: []int{1}},
+					{ID: 1, Type: "systemd-fido2", Keyslots: []int{2}},
+				},
+			},
+			want: []string{"every keyslot is bound to a token: add a recovery passphrase with `cryptsetup luksAddKey`"},
+		},
+		{
+			name: "passphrase next to a token",
+			report: LuksReport{
+				Version:  2,
+				Keyslots: []LuksKeyslot{{ID: 0, Type: "luks2", PBKDF: "argon2id"}, {ID: 1, Type: "luks2", PBKDF: "pbkdf2"}},
+				Tokens:   []LuksToken{{ID: 0, Type: "systemd-tpm2", Keyslots: []int{1}}},
+			},
+			want: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := luksWarnings(&tt.report); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: luksWarnings() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
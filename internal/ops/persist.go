@@ -0,0 +1,255 @@
+package ops
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/acker1019/fedora-phoenix/internal/logging"
+	"github.com/acker1019/fedora-phoenix/internal/utils"
+)
+
+var persistLog = logging.WithSource("ops/persist")
+
+// Default locations of the boot-time tables
+const (
+	CrypttabPath = "/etc/crypttab"
+	FstabPath    = "/etc/fstab"
+)
+
+// PersistLuks makes a volume survive reboots by writing its /etc/crypttab
+// and /etc/fstab entries, referenced by UUID and marked nofail so that a
+// missing disk never blocks boot.
+//
+// Phoenix only edits the block between its own marker comments
+// ("# BEGIN phoenix: <mapper>" ... "# END phoenix: <mapper>");
+// every other line of both files is preserved as is.
+type PersistLuks struct {
+	Device        string // LUKS partition spec (path or selector)
+	MapperName    string
+	MountPoint    string
+	FSType        string // fstab type column; empty means "auto"
+	MountOptions  string // Extra fstab options (e.g. "noatime,compress=zstd")
+	DeviceTimeout string // x-systemd.device-timeout (e.g. "10s")
+	CrypttabPath  string
+	FstabPath     string
+	Optional      bool // Leave the tables alone while an optional volume is absent
+}
+
+func (a *PersistLuks) Name() string { return "PersistLuks" }
+func (a *PersistLuks) Block() Block { return BlockInfrastructure }
+
+// Check compares the managed blocks with the entries the volume needs.
+// UUIDs can only be read from an unlocked volume; while it is locked an
+// existing block is trusted as is.
+func (a *PersistLuks) Check(r utils.Runner) ([]Diff, error) {
+	var diffs []Diff
+
+	if !isLuksUnlocked(a.MapperName) {
+		for _, path := range []string{a.CrypttabPath, a.FstabPath} {
+			item := fmt.Sprintf("%s %s", filepath.Base(path), a.MapperName)
+			content, _ := os.ReadFile(path)
+			switch _, ok := managedBlock(string(content), a.MapperName); {
+			case ok:
+				diffs = append(diffs, satisfied(path, item, "entry present (volume locked, UUIDs not re-checked)"))
+			case a.Optional:
+				diffs = append(diffs, satisfied(path, item, "optional volume not unlocked, skipped"))
+			default:
+				diffs = append(diffs, pending(path, item, "would add entry after unlock"))
+			}
+		}
+		return diffs, nil
+	}
+
+	crypttab, fstab, err := a.entries(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return blockDiffs(a.MapperName, a.MapperName, [][2]string{{a.CrypttabPath, crypttab}, {a.FstabPath, fstab}})
+}
+
+// Apply rewrites the managed block of every pending file and reloads systemd
+// so that its fstab/crypttab generators pick up the change.
+func (a *PersistLuks) Apply(r utils.Runner, pending []Diff) error {
+	crypttab, fstab, err := a.entries(r)
+	if err != nil {
+		return err
+	}
+	lines := map[string]string{a.CrypttabPath: crypttab, a.FstabPath: fstab}
+
+	for _, d := range pending {
+		persistLog.Infof("Writing %s entry for %s", d.Key, a.MapperName)
+		if err := writeManagedBlock(d.Key, a.MapperName, lines[d.Key]); err != nil {
+			return fail(CategoryPermission, fmt.Sprintf("Check that %s is writable by root", d.Key),
+				fmt.Errorf("failed to update %s: %w", d.Key, err))
+		}
+	}
+
+	if err := r.Run("systemctl", "daemon-reload"); err != nil {
+		return fail(CategoryService, "Run `systemctl daemon-reload` manually", err)
+	}
+	return nil
+}
+
+// entries builds the crypttab and fstab lines from the live UUIDs:
+// the LUKS header UUID of the partition and the filesystem UUID inside it.
+func (a *PersistLuks) entries(r utils.Runner) (crypttab, fstab string, err error) {
+	dev, err := utils.ResolveDevice(a.Device)
+	if err != nil {
+		return "", "", fail(CategoryDevice, "", err)
+	}
+	out, err := r.Output("cryptsetup", "luksUUID", dev)
+	if err != nil {
+		return "", "", fail(CategoryDevice, "Run `cryptsetup luksUUID` manually to inspect the header",
+			fmt.Errorf("failed to read LUKS UUID of %s: %w", dev, err))
+	}
+	luksUUID := strings.TrimSpace(string(out))
+
+	mapper := fmt.Sprintf("/dev/mapper/%s", a.MapperName)
+	out, err = r.Output("blkid", "-s", "UUID", "-o", "value", mapper)
+	if err != nil || strings.TrimSpace(string(out)) == "" {
+		return "", "", fail(CategoryDevice, "Unlock the volume first",
+			fmt.Errorf("failed to read filesystem UUID of %s: %w", mapper, err))
+	}
+	fsUUID := strings.TrimSpace(string(out))
+
+	boot := []string{"nofail"}
+	if a.DeviceTimeout != "" {
+		boot = append(boot, "x-systemd.device-timeout="+a.DeviceTimeout)
+	}
+
+	fsType := a.FSType
+	if fsType == "" {
+		fsType = "auto"
+	}
+	options := boot
+	if a.MountOptions != "" {
+		options = append(strings.Split(a.MountOptions, ","), boot...)
+	}
+
+	crypttab = fmt.Sprintf("%s UUID=%s none luks,%s", a.MapperName, luksUUID, strings.Join(boot, ","))
+	fstab = fmt.Sprintf("UUID=%s %s %s %s 0 2", fsUUID, fstabEscape(a.MountPoint), fsType, strings.Join(options, ","))
+	return crypttab, fstab, nil
+}
+
+// blockDiffs compares the managed block id of each {path, line} entry with its line.
+func blockDiffs(name, id string, entries [][2]string) ([]Diff, error) {
+	var diffs []Diff
+	for _, entry := range entries {
+		path, line := entry[0], entry[1]
+		item := fmt.Sprintf("%s %s", filepath.Base(path), name)
+		changed, err := needsBlockUpdate(path, id, line)
+		if err != nil {
+			return nil, fail(CategoryPermission, "", err)
+		}
+		if changed {
+			diffs = append(diffs, pending(path, item, fmt.Sprintf("would write %q", line)))
+		} else {
+			diffs = append(diffs, satisfied(path, item, "entry up to date"))
+		}
+	}
+	return diffs, nil
+}
+
+// needsBlockUpdate reports whether the managed block id at path differs from body.
+func needsBlockUpdate(path, id, body string) (bool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	current, ok := managedBlock(string(content), id)
+	return !ok || current != body, nil
+}
+
+// Marker comments around the lines Phoenix owns
+func beginMarker(id string) string { return "# BEGIN phoenix: " + id }
+func endMarker(id string) string   { return "# END phoenix: " + id }
+
+// managedBlock returns the body of the block with the given id.
+func managedBlock(content, id string) (string, bool) {
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		if line != beginMarker(id) {
+			continue
+		}
+		for j := i + 1; j < len(lines); j++ {
+			if lines[j] == endMarker(id) {
+				return strings.Join(lines[i+1:j], "\n"), true
+			}
+		}
+	}
+	return "", false
+}
+
+// writeManagedBlock replaces (or appends) the block with the given id and
+// writes the file atomically, keeping its mode. Everything outside the
+// markers is kept byte for byte.
+func writeManagedBlock(path, id, body string) error {
+	content, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	block := []string{beginMarker(id), body, endMarker(id)}
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	if len(content) == 0 {
+		lines = nil
+	}
+
+	var out []string
+	replaced := false
+	for i := 0; i < len(lines); i++ {
+		if lines[i] == beginMarker(id) && !replaced {
+			end := i + 1
+			for end < len(lines) && lines[end] != endMarker(id) {
+				end++
+			}
+			// A begin marker without its end is not ours to rewrite
+			if end < len(lines) {
+				out = append(out, block...)
+				replaced = true
+				i = end
+				continue
+			}
+		}
+		out = append(out, lines[i])
+	}
+	if !replaced {
+		out = append(out, block...)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".phoenix-"+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	if _, err := tmp.WriteString(strings.Join(out, "\n") + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	// A crypttab or fstab lost to a crash would break the next boot
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// fstabEscape encodes spaces and tabs the way fstab(5) expects.
+func fstabEscape(path string) string {
+	return strings.NewReplacer(" ", `\040`, "\t", `\011`).Replace(path)
+}
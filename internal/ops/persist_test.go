@@ -0,0 +1,126 @@
+package ops
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/acker1019/fedora-phoenix/internal/utils/utilstest"
+)
+
+const (
+	testLuksUUID = "0b5e7a2c-41f3-4c52-9a0e-3d6f1c2b8e11"
+	testFSUUID   = "5f7c9d3e-8a21-4b6f-b0c4-2e9d1a7f6c38"
+)
+
+// newPersistLuks returns a PersistLuks of testMapper that writes to a
+// crypttab and fstab in a temporary directory.
+func newPersistLuks(t *testing.T) *PersistLuks {
+	t.Helper()
+	dir := t.TempDir()
+	return &PersistLuks{
+		Device: fakeDevice(t), MapperName: testMapper, MountPoint: "/mnt/my vault", FSType: "btrfs",
+		MountOptions: "noatime", DeviceTimeout: "10s",
+		CrypttabPath: filepath.Join(dir, "crypttab"), FstabPath: filepath.Join(dir, "fstab"),
+	}
+}
+
+func TestPersistLuksWritesEntries(t *testing.T) {
+	a := newPersistLuks(t)
+	if err := os.WriteFile(a.FstabPath, []byte("UUID=1234 / btrfs defaults 0 0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := utilstest.NewFakeRunner()
+	r.Expect("cryptsetup luksUUID " + a.Device).Stdout(testLuksUUID + "\n")
+	r.Expect("blkid -s UUID -o value /dev/mapper/" + testMapper).Stdout(testFSUUID + "\n")
+	r.Expect("systemctl daemon-reload")
+
+	diffs, err := a.Check(r)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if got, want := diffKeys(pendingDiffs(diffs)), []string{a.CrypttabPath, a.FstabPath}; !slices.Equal(got, want) {
+		t.Fatalf("pending = %q, want %q", got, want)
+	}
+	if err := a.Apply(r, pendingDiffs(diffs)); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if unmet := r.Unmet(); unmet != nil {
+		t.Errorf("runner script not followed: %q", unmet)
+	}
+
+	content, err := os.ReadFile(a.FstabPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "UUID=1234 / btrfs defaults 0 0\n" +
+		beginMarker(testMapper) + "\n" +
+		"UUID=" + testFSUUID + ` /mnt/my\040vault btrfs noatime,nofail,x-systemd.device-timeout=10s 0 2` + "\n" +
+		endMarker(testMapper) + "\n"
+	if string(content) != want {
+		t.Errorf("fstab =\n%s\nwant\n%s", content, want)
+	}
+
+	// Written entries are trusted while the volume stays locked
+	if diffs, err := a.Check(r); err != nil || len(pendingDiffs(diffs)) != 0 {
+		t.Errorf("Check() after Apply = %+v (%v), want nothing pending", diffs, err)
+	}
+}
+
+func TestPersistLuksOptionalVolumeLocked(t *testing.T) {
+	a := newPersistLuks(t)
+	a.Optional = true
+
+	diffs, err := a.Check(utilstest.NewFakeRunner())
+	if err != nil || len(pendingDiffs(diffs)) != 0 {
+		t.Errorf("Check() = %+v (%v), want the volume skipped", diffs, err)
+	}
+}
+
+func TestPersistLuksFilesystemUUIDUnreadable(t *testing.T) {
+	a := newPersistLuks(t)
+	r := utilstest.NewFakeRunner()
+	r.Expect("cryptsetup luksUUID " + a.Device).Stdout(testLuksUUID + "\n")
+	r.Expect("blkid -s UUID -o value /dev/mapper/" + testMapper).Exit(2)
+
+	diffs, err := a.Check(r)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	checkCategory(t, a.Apply(r, pendingDiffs(diffs)), CategoryDevice)
+	if _, err := os.Stat(a.CrypttabPath); !os.IsNotExist(err) {
+		t.Errorf("crypttab written without UUIDs (%v)", err)
+	}
+}
+
+func TestWriteManagedBlock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "crypttab")
+	original := "# /etc/crypttab\n" +
+		beginMarker("vault") + "\nvault UUID=old none luks\n" + endMarker("vault") + "\n" +
+		"swap /dev/sda2 /dev/urandom swap\n"
+	if err := os.WriteFile(path, []byte(original), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writeManagedBlock(path, "vault", "vault UUID=new none luks"); err != nil {
+		t.Fatalf("writeManagedBlock() error = %v", err)
+	}
+	if err := writeManagedBlock(path, "data", "data UUID=data none luks"); err != nil {
+		t.Fatalf("writeManagedBlock() error = %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Replace(original, "UUID=old", "UUID=new", 1) +
+		beginMarker("data") + "\ndata UUID=data none luks\n" + endMarker("data") + "\n"
+	if string(content) != want {
+		t.Errorf("content =\n%s\nwant\n%s", content, want)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v (%v), want 0600 kept", info.Mode().Perm(), err)
+	}
+}
@@ -2,39 +2,56 @@ package ops
 
 import (
 	"fmt"
-	"os"
-	"os/exec"
-	"strings"
 
 	"github.com/acker1019/fedora-phoenix/internal/logging"
+	"github.com/acker1019/fedora-phoenix/internal/utils"
 )
 
 var pkgLog = logging.WithSource("ops/pkg")
 
-// EnsurePackages is the idempotent function to install packages.
-// It filters out already installed packages using rpm -q for speed.
-func EnsurePackages(pkgs []string) error {
-	if len(pkgs) == 0 {
-		return nil
-	}
+// EnsurePackages is the idempotent Act to install packages.
+// It filters out already installed packages through the package inventory.
+type EnsurePackages struct {
+	Packages  []string
+	Inventory *PackageInventory // Shared with the other package Acts of the run; nil uses its own
+}
 
-	pkgLog.Infof("Checking status for %d packages...", len(pkgs))
+func (a *EnsurePackages) Name() string { return "EnsurePackages" }
+func (a *EnsurePackages) Block() Block { return BlockSystem }
+
+// Check reports which packages are installed and which would be installed.
+func (a *EnsurePackages) Check(r utils.Runner) ([]Diff, error) {
+	if len(a.Packages) == 0 {
+		return nil, nil
+	}
 
-	// Filter out already installed packages
-	// Use rpm -q to check each package individually for idempotency
-	var missingPkgs []string
+	pkgLog.Infof("Checking status for %d packages...", len(a.Packages))
+	installed, err := inventory(&a.Inventory).Satisfied(r, a.Packages)
+	if err != nil {
+		return nil, err
+	}
 
-	for _, pkg := range pkgs {
-		// rpm -q returns exit code 0 if installed, non-zero if not
-		cmd := exec.Command("rpm", "-q", pkg)
-		if err := cmd.Run(); err != nil {
-			missingPkgs = append(missingPkgs, pkg)
+	diffs := make([]Diff, 0, len(a.Packages))
+	for _, pkg := range a.Packages {
+		item := fmt.Sprintf("package %s", pkg)
+		if installed[pkg] {
+			diffs = append(diffs, satisfied(pkg, item, "already installed"))
+		} else {
+			diffs = append(diffs, pending(pkg, item, "would install"))
 		}
 	}
 
-	if len(missingPkgs) == 0 {
+	if len(pendingDiffs(diffs)) == 0 {
 		pkgLog.Info("All packages are already installed")
-		return nil
+	}
+	return diffs, nil
+}
+
+// Apply installs the missing packages in a single DNF transaction.
+func (a *EnsurePackages) Apply(r utils.Runner, pending []Diff) error {
+	missingPkgs := make([]string, 0, len(pending))
+	for _, d := range pending {
+		missingPkgs = append(missingPkgs, d.Key)
 	}
 
 	pkgLog.Infof("Found %d missing packages: %v", len(missingPkgs), missingPkgs)
@@ -44,13 +61,12 @@ func EnsurePackages(pkgs []string) error {
 	// --refresh: force metadata update
 	args := append([]string{"install", "-y", "--refresh"}, missingPkgs...)
 
-	cmd := exec.Command("dnf", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
 	pkgLog.Info("Starting DNF transaction...")
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("dnf install failed: %w", err)
+	err := r.Run("dnf", args...)
+	inventory(&a.Inventory).Invalidate()
+	if err != nil {
+		return fail(CategoryPackage, "Check network connectivity and package names, then rerun",
+			fmt.Errorf("dnf install failed: %w", err))
 	}
 
 	pkgLog.Info("Packages installed successfully")
@@ -59,57 +75,97 @@ func EnsurePackages(pkgs []string) error {
 
 // EnsurePinnedPackages installs and locks specific package versions.
 // Follows Check-Diff-Act pattern for idempotency.
-func EnsurePinnedPackages(pkgs []string) error {
-	if len(pkgs) == 0 {
-		return nil
+type EnsurePinnedPackages struct {
+	Packages  []string
+	Inventory *PackageInventory // Shared with the other package Acts of the run; nil uses its own
+}
+
+func (a *EnsurePinnedPackages) Name() string { return "EnsurePinnedPackages" }
+func (a *EnsurePinnedPackages) Block() Block { return BlockSystem }
+
+// Check reports which pinned packages would be installed and/or locked.
+func (a *EnsurePinnedPackages) Check(r utils.Runner) ([]Diff, error) {
+	if len(a.Packages) == 0 {
+		return nil, nil
 	}
 
-	pkgLog.Infof("Processing %d pinned packages...", len(pkgs))
+	pkgLog.Infof("Processing %d pinned packages...", len(a.Packages))
+	inv := inventory(&a.Inventory)
+	installed, err := inv.Satisfied(r, a.Packages)
+	if err != nil {
+		return nil, err
+	}
+
+	diffs := make([]Diff, 0, len(a.Packages))
+	for _, pkg := range a.Packages {
+		item := fmt.Sprintf("pinned %s", pkg)
+		isInstalled := installed[pkg]
+		isLocked := inv.Locked(r, pkg)
 
+		switch {
+		case isInstalled && isLocked:
+			pkgLog.Infof("Package %s already installed and locked. Skipping.", pkg)
+			diffs = append(diffs, satisfied(pkg, item, "already installed and locked"))
+		case isInstalled:
+			diffs = append(diffs, pending(pkg, item, "would lock version"))
+		case isLocked:
+			diffs = append(diffs, pending(pkg, item, "would install"))
+		default:
+			diffs = append(diffs, pending(pkg, item, "would install and lock version"))
+		}
+	}
+	return diffs, nil
+}
+
+// Apply ensures the versionlock plugin, then installs and locks each pending package.
+func (a *EnsurePinnedPackages) Apply(r utils.Runner, pending []Diff) error {
 	// Ensure versionlock plugin is installed
 	pkgLog.Info("Ensuring dnf-plugin-versionlock is installed...")
-	if err := EnsurePackages([]string{"python3-dnf-plugin-versionlock"}); err != nil {
-		return fmt.Errorf("failed to install versionlock plugin: %w", err)
+	inv := inventory(&a.Inventory)
+	if err := Ensure(r, &EnsurePackages{Packages: []string{"python3-dnf-plugin-versionlock"}, Inventory: inv}); err != nil {
+		return fail(CategoryPackage, "Install python3-dnf-plugin-versionlock manually and rerun",
+			fmt.Errorf("failed to install versionlock plugin: %w", err))
+	}
+
+	// Check once more: state may have changed since the plugin was installed.
+	// The transactions below only touch their own package, so this state
+	// stays valid for the rest of the loop.
+	keys := make([]string, 0, len(pending))
+	for _, d := range pending {
+		keys = append(keys, d.Key)
+	}
+	installed, err := inv.Satisfied(r, keys)
+	if err != nil {
+		return err
+	}
+	locked := make(map[string]bool, len(keys))
+	for _, pkg := range keys {
+		locked[pkg] = inv.Locked(r, pkg)
 	}
 
 	// Process each pinned package
-	for _, pkg := range pkgs {
+	for _, pkg := range keys {
 		pkgLog.Infof("Checking pinned package: %s", pkg)
 
-		// Check: Is package already installed?
-		checkCmd := exec.Command("rpm", "-q", pkg)
-		isInstalled := checkCmd.Run() == nil
-
-		// Check: Is package already locked?
-		listCmd := exec.Command("dnf", "versionlock", "list")
-		output, err := listCmd.Output()
-		isLocked := err == nil && strings.Contains(string(output), pkg)
-
-		// Diff: If both installed and locked, skip
-		if isInstalled && isLocked {
-			pkgLog.Infof("Package %s already installed and locked. Skipping.", pkg)
-			continue
-		}
-
 		// Act: Install if needed
-		if !isInstalled {
+		if !installed[pkg] {
 			pkgLog.Infof("Installing pinned package: %s", pkg)
-			cmd := exec.Command("dnf", "install", "-y", pkg)
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			if err := cmd.Run(); err != nil {
-				return fmt.Errorf("failed to install pinned package %s: %w", pkg, err)
+			err := r.Run("dnf", "install", "-y", pkg)
+			inv.Invalidate()
+			if err != nil {
+				return fail(CategoryPackage, "Check that the pinned version is still available in the enabled repositories",
+					fmt.Errorf("failed to install pinned package %s: %w", pkg, err))
 			}
 		}
 
 		// Act: Lock if needed
-		if !isLocked {
+		if !locked[pkg] {
 			pkgLog.Infof("Locking package version: %s", pkg)
-			cmd := exec.Command("dnf", "versionlock", "add", pkg)
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			if err := cmd.Run(); err != nil {
-				return fmt.Errorf("failed to lock version for %s: %w", pkg, err)
+			err := r.Run("dnf", "versionlock", "add", pkg)
+			inv.Invalidate()
+			if err != nil {
+				return fail(CategoryPackage, "Run `dnf versionlock list` to inspect existing locks",
+					fmt.Errorf("failed to lock version for %s: %w", pkg, err))
 			}
 		}
 	}
@@ -117,3 +173,11 @@ func EnsurePinnedPackages(pkgs []string) error {
 	pkgLog.Info("All pinned packages verified")
 	return nil
 }
+
+// inventory returns the Act's package inventory, creating it on first use.
+func inventory(inv **PackageInventory) *PackageInventory {
+	if *inv == nil {
+		*inv = NewPackageInventory()
+	}
+	return *inv
+}
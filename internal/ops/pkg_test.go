@@ -0,0 +1,137 @@
+package ops
+
+import (
+	"testing"
+
+	"github.com/acker1019/fedora-phoenix/internal/utils/utilstest"
+)
+
+// rpmQuery is the inventory query as the FakeRunner renders it
+const rpmQuery = "rpm -qa --queryformat " + rpmQueryFormat
+
+// installedDB is rpmQuery output: vim-enhanced (which provides vim),
+// kernel and htop, without the versionlock plugin.
+const installedDB = "vim-enhanced\t2\t9.1.0\t1.fc41\tx86_64\tvim-enhanced\tvim\tvim-enhanced(x86-64)\n" +
+	"kernel\t0\t6.11.4\t301.fc41\tx86_64\tkernel\tkernel(x86-64)\n" +
+	"htop\t0\t3.3.0\t1.fc41\tx86_64\thtop\n"
+
+// pluginDB is installedDB once the versionlock plugin is installed.
+const pluginDB = installedDB + "python3-dnf-plugin-versionlock\t0\t4.9.0\t1.fc41\tnoarch\tpython3-dnf-plugin-versionlock\n"
+
+func TestEnsurePackages(t *testing.T) {
+	runActCases(t, []actCase{
+		{
+			name: "nothing declared",
+			act:  constAct(&EnsurePackages{}),
+		},
+		{
+			name:   "installed packages and provides are skipped",
+			act:    constAct(&EnsurePackages{Packages: []string{"vim-enhanced", "vim", "htop"}}),
+			script: func(r *utilstest.FakeRunner) { r.Expect(rpmQuery).Stdout(installedDB) },
+		},
+		{
+			name: "missing packages are installed in one transaction",
+			act:  constAct(&EnsurePackages{Packages: []string{"vim", "tmux", "zsh"}}),
+			script: func(r *utilstest.FakeRunner) {
+				r.Expect(rpmQuery).Stdout(installedDB)
+				r.Expect("dnf install -y --refresh tmux zsh")
+			},
+			pending: []string{"tmux", "zsh"},
+		},
+		{
+			name: "failed transaction",
+			act:  constAct(&EnsurePackages{Packages: []string{"tmux"}}),
+			script: func(r *utilstest.FakeRunner) {
+				r.Expect(rpmQuery).Stdout(installedDB)
+				r.Expect("dnf install -y --refresh tmux").Exit(1).Stderr("No match for argument: tmux")
+			},
+			fail: CategoryPackage,
+			exit: 1,
+		},
+		{
+			name:   "unreadable rpm database",
+			act:    constAct(&EnsurePackages{Packages: []string{"tmux"}}),
+			script: func(r *utilstest.FakeRunner) { r.Expect(rpmQuery).Exit(1) },
+			fail:   CategoryPackage,
+			exit:   1,
+		},
+	})
+}
+
+func TestEnsurePinnedPackages(t *testing.T) {
+	runActCases(t, []actCase{
+		{
+			name: "installed and locked",
+			act:  constAct(&EnsurePinnedPackages{Packages: []string{"kernel"}}),
+			script: func(r *utilstest.FakeRunner) {
+				r.Expect(rpmQuery).Stdout(pluginDB)
+				r.Expect("dnf versionlock list").Stdout("kernel-0:6.11.4-301.fc41.*\n")
+			},
+		},
+		{
+			name: "plugin, package and lock are all missing",
+			act:  constAct(&EnsurePinnedPackages{Packages: []string{"tmux-3.5a-1.fc41"}}),
+			script: func(r *utilstest.FakeRunner) {
+				r.Expect(rpmQuery).Stdout(installedDB)
+				r.Expect("dnf versionlock list").Exit(1)
+				r.Expect("dnf install -y --refresh python3-dnf-plugin-versionlock")
+				// The plugin transaction invalidated the inventory
+				r.Expect(rpmQuery).Stdout(pluginDB)
+				r.Expect("dnf versionlock list")
+				r.Expect("dnf install -y tmux-3.5a-1.fc41")
+				r.Expect("dnf versionlock add tmux-3.5a-1.fc41")
+			},
+			pending: []string{"tmux-3.5a-1.fc41"},
+		},
+		{
+			name: "installed package is only locked",
+			act:  constAct(&EnsurePinnedPackages{Packages: []string{"htop-3.3.0-1.fc41"}}),
+			script: func(r *utilstest.FakeRunner) {
+				r.Expect(rpmQuery).Stdout(pluginDB)
+				r.Expect("dnf versionlock list")
+				r.Expect("dnf versionlock add htop-3.3.0-1.fc41")
+			},
+			pending: []string{"htop-3.3.0-1.fc41"},
+		},
+		{
+			name: "pinned version no longer available",
+			act:  constAct(&EnsurePinnedPackages{Packages: []string{"tmux-3.5a-1.fc41"}}),
+			script: func(r *utilstest.FakeRunner) {
+				r.Expect(rpmQuery).Stdout(pluginDB)
+				r.Expect("dnf versionlock list")
+				r.Expect("dnf install -y tmux-3.5a-1.fc41").Exit(1)
+			},
+			fail: CategoryPackage,
+			exit: 1,
+		},
+		{
+			name: "failed lock",
+			act:  constAct(&EnsurePinnedPackages{Packages: []string{"htop-3.3.0-1.fc41"}}),
+			script: func(r *utilstest.FakeRunner) {
+				r.Expect(rpmQuery).Stdout(pluginDB)
+				r.Expect("dnf versionlock list")
+				r.Expect("dnf versionlock add htop-3.3.0-1.fc41").Exit(1)
+			},
+			fail: CategoryPackage,
+			exit: 1,
+		},
+	})
+}
+
+func TestPackageActsShareInventory(t *testing.T) {
+	inv := NewPackageInventory()
+	r := utilstest.NewFakeRunner()
+	r.Expect(rpmQuery).Stdout(pluginDB)
+	r.Expect("dnf versionlock list").Stdout("kernel-0:6.11.4-301.fc41.*\n")
+
+	acts := []Act{
+		&EnsurePackages{Packages: []string{"vim", "htop"}, Inventory: inv},
+		&EnsurePinnedPackages{Packages: []string{"kernel"}, Inventory: inv},
+	}
+	if _, err := (&Playbook{Runner: r}).Run(acts); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if unmet := r.Unmet(); unmet != nil {
+		t.Errorf("inventory not shared: %q", unmet)
+	}
+}
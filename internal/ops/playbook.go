@@ -0,0 +1,88 @@
+package ops
+
+import (
+	"slices"
+	"time"
+
+	"github.com/acker1019/fedora-phoenix/internal/logging"
+	"github.com/acker1019/fedora-phoenix/internal/utils"
+)
+
+var playbookLog = logging.WithSource("ops/playbook")
+
+// Playbook walks a list of Acts in order, running Check and then Apply.
+// Execution stops at the first failing Act (Fail Fast, see anti-requirements.md).
+type Playbook struct {
+	Runner utils.Runner // Executes every external command (see Session.Runner)
+	DryRun bool         // Only run the Check half of every Act
+	Only   []string     // If set, run only Acts with these names
+	Skip   []string     // Acts with these names are not run
+}
+
+// Result records what happened to a single Act during a Playbook run.
+type Result struct {
+	Act      Checker
+	Diffs    []Diff        // Everything Check reported
+	Filtered bool          // Excluded by Only/Skip
+	Applied  bool          // Apply was called with pending Diffs
+	Duration time.Duration // Time spent in Check and Apply
+	Err      error
+}
+
+// Pending returns the Diffs that were (or would be) changed.
+func (r Result) Pending() []Diff {
+	return pendingDiffs(r.Diffs)
+}
+
+// Run executes the Acts in order and returns one Result per Act reached.
+// On failure, the returned slice ends with the failing Act's Result and
+// the error is always an *Error.
+func (p *Playbook) Run(acts []Act) ([]Result, error) {
+	results := make([]Result, 0, len(acts))
+
+	for _, act := range acts {
+		if !p.selected(act.Name()) {
+			playbookLog.Debugf("Act %s filtered out. Skipping.", act.Name())
+			results = append(results, Result{Act: act, Filtered: true})
+			continue
+		}
+
+		res := p.runAct(act)
+		results = append(results, res)
+		if res.Err != nil {
+			return results, res.Err
+		}
+	}
+
+	return results, nil
+}
+
+// runAct runs the Check-Diff-Act cycle for one Act and times it.
+func (p *Playbook) runAct(act Act) Result {
+	start := time.Now()
+	res := Result{Act: act}
+
+	res.Diffs, res.Err = act.Check(p.Runner)
+	if res.Err == nil && !p.DryRun {
+		if todo := res.Pending(); len(todo) > 0 {
+			res.Applied = true
+			res.Err = act.Apply(p.Runner, todo)
+		}
+	}
+
+	if res.Err != nil {
+		res.Err = asError(act, res.Err)
+	}
+
+	res.Duration = time.Since(start)
+	playbookLog.Debugf("Act %s finished in %s", act.Name(), res.Duration)
+	return res
+}
+
+// selected applies the Only/Skip filters to an Act name.
+func (p *Playbook) selected(name string) bool {
+	if len(p.Only) > 0 && !slices.Contains(p.Only, name) {
+		return false
+	}
+	return !slices.Contains(p.Skip, name)
+}
@@ -0,0 +1,89 @@
+package ops
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/acker1019/fedora-phoenix/internal/utils/utilstest"
+)
+
+// playbookActs is a System Block act that needs work, followed by a User Space one.
+func playbookActs() []Act {
+	return []Act{
+		&EnsurePackages{Packages: []string{"tmux"}},
+		&RunStow{SourceDir: "/home/ack/dotfiles", TargetDir: "/home/ack", Packages: []string{"zsh"}, Username: "ack"},
+	}
+}
+
+func TestPlaybookStopsAtFirstFailure(t *testing.T) {
+	r := utilstest.NewFakeRunner()
+	r.Expect(rpmQuery).Stdout(installedDB)
+	r.Expect("dnf install -y --refresh tmux").Exit(1)
+
+	results, err := (&Playbook{Runner: r}).Run(playbookActs())
+	if len(results) != 1 || results[0].Err != err || !results[0].Applied {
+		t.Errorf("results = %+v, want only the applied, failed EnsurePackages", results)
+	}
+	checkActError(t, playbookActs()[0], err, CategoryPackage, 1)
+	if e := err.(*Error); e.Block.ExitCode() != 30 {
+		t.Errorf("exit code = %d, want 30 for Block III", e.Block.ExitCode())
+	}
+	if unmet := r.Unmet(); unmet != nil {
+		t.Errorf("runner script not followed: %q", unmet)
+	}
+}
+
+func TestPlaybookDryRun(t *testing.T) {
+	r := utilstest.NewFakeRunner()
+	r.Expect(rpmQuery).Stdout(installedDB)
+
+	results, err := (&Playbook{Runner: r, DryRun: true}).Run(playbookActs())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	for _, res := range results {
+		if res.Applied || len(res.Pending()) != 1 {
+			t.Errorf("%s: applied = %v, pending = %v; want one unapplied Diff", res.Act.Name(), res.Applied, res.Pending())
+		}
+	}
+	if unmet := r.Unmet(); unmet != nil {
+		t.Errorf("dry run ran commands: %q", unmet)
+	}
+}
+
+func TestPlaybookFilters(t *testing.T) {
+	tests := []struct {
+		name     string
+		playbook Playbook
+		filtered []bool
+	}{
+		{"only", Playbook{DryRun: true, Only: []string{"RunStow"}}, []bool{true, false}},
+		{"skip", Playbook{DryRun: true, Skip: []string{"RunStow"}}, []bool{false, true}},
+		{"skip wins over only", Playbook{DryRun: true, Only: []string{"RunStow"}, Skip: []string{"RunStow"}}, []bool{true, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := utilstest.NewFakeRunner()
+			if !tt.filtered[0] {
+				r.Expect(rpmQuery).Stdout(installedDB)
+			}
+			tt.playbook.Runner = r
+
+			results, err := tt.playbook.Run(playbookActs())
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			var filtered []bool
+			for _, res := range results {
+				filtered = append(filtered, res.Filtered)
+			}
+			if !slices.Equal(filtered, tt.filtered) {
+				t.Errorf("filtered = %v, want %v", filtered, tt.filtered)
+			}
+			if unmet := r.Unmet(); unmet != nil {
+				t.Errorf("runner script not followed: %q", unmet)
+			}
+		})
+	}
+}
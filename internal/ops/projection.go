@@ -0,0 +1,203 @@
+package ops
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/acker1019/fedora-phoenix/internal/logging"
+	"github.com/acker1019/fedora-phoenix/internal/utils"
+)
+
+var projectionLog = logging.WithSource("ops/projection")
+
+// Projection methods
+const (
+	ProjectBind    = "bind"    // mount --bind Src Dest
+	ProjectSymlink = "symlink" // Dest -> Src
+)
+
+// ProjectDirectory maps a directory of a LUKS volume into the home directory
+// (ADR-0004 "Data Projection Rules"), either by bind mount or by symlink.
+//
+// A non-empty Dest is never shadowed unless AllowShadow is set: a bind mount
+// would hide its content, a symlink moves it aside to Dest.phoenix-shadow-*.
+type ProjectDirectory struct {
+	Src         string // Absolute path inside the volume's mount point
+	Dest        string // Absolute path in $HOME
+	Method      string // ProjectBind or ProjectSymlink
+	VolumeMount string // Mount point of the volume that holds Src
+	Optional    bool   // Skip when the (optional) volume is not mounted
+	AllowShadow bool
+	UID         int // Owner of created directories and symlinks
+	GID         int
+}
+
+func (a *ProjectDirectory) Name() string { return "ProjectDirectory" }
+func (a *ProjectDirectory) Block() Block { return BlockUserSpace }
+
+// Check reports whether Dest already projects Src.
+// Bind mounts are detected through /proc/self/mountinfo and compared by
+// device and inode, so a bind of the wrong directory is not mistaken for ours.
+func (a *ProjectDirectory) Check(r utils.Runner) ([]Diff, error) {
+	item := fmt.Sprintf("projection %s", a.Dest)
+
+	if a.Optional && !IsMounted(r, a.VolumeMount) {
+		projectionLog.Warnf("Volume %s is not mounted. Skipping %s.", a.VolumeMount, a.Dest)
+		return []Diff{satisfied(a.Dest, item, fmt.Sprintf("optional volume %s not mounted, skipped", a.VolumeMount))}, nil
+	}
+
+	done, err := a.projected()
+	if err != nil {
+		return nil, err
+	}
+	if done {
+		projectionLog.Infof("%s already projects %s. Skipping.", a.Dest, a.Src)
+		return []Diff{satisfied(a.Dest, item, fmt.Sprintf("already %s -> %s", a.Method, a.Src))}, nil
+	}
+
+	n, err := a.destEntries()
+	if err != nil {
+		return nil, err
+	}
+	if n > 0 && !a.AllowShadow {
+		return nil, fail(CategoryConfig, "Move the content into the volume, or set allow_shadow: true on this projection",
+			fmt.Errorf("refusing to shadow non-empty %s (%d entries)", a.Dest, n))
+	}
+
+	detail := fmt.Sprintf("would %s -> %s", a.Method, a.Src)
+	if n > 0 {
+		detail += fmt.Sprintf(" (shadowing %d entries)", n)
+	}
+	return []Diff{pending(a.Dest, item, detail)}, nil
+}
+
+// Apply creates Src and the mount target as the user and projects Src onto Dest.
+func (a *ProjectDirectory) Apply(r utils.Runner, pending []Diff) error {
+	if err := ensureUserDir(a.Src, 0755, a.UID, a.GID); err != nil {
+		return fail(CategoryPermission, "Check that the LUKS volume is mounted read-write",
+			fmt.Errorf("failed to create %s: %w", a.Src, err))
+	}
+	if err := ensureUserDir(filepath.Dir(a.Dest), 0755, a.UID, a.GID); err != nil {
+		return fail(CategoryPermission, "Check ownership of the home directory",
+			fmt.Errorf("failed to create %s: %w", filepath.Dir(a.Dest), err))
+	}
+
+	switch a.Method {
+	case ProjectSymlink:
+		return a.symlink()
+	default:
+		return a.bind(r)
+	}
+}
+
+// bind mounts Src onto Dest, creating Dest owned by the user.
+func (a *ProjectDirectory) bind(r utils.Runner) error {
+	if err := ensureUserDir(a.Dest, 0755, a.UID, a.GID); err != nil {
+		return fail(CategoryPermission, "Check ownership of the home directory",
+			fmt.Errorf("failed to create mount target %s: %w", a.Dest, err))
+	}
+
+	projectionLog.Infof("Bind-mounting %s -> %s", a.Src, a.Dest)
+	if err := r.Run("mount", "--bind", a.Src, a.Dest); err != nil {
+		return fail(CategoryDevice, "Check that the LUKS volume is mounted",
+			fmt.Errorf("bind mount failed: %w", err))
+	}
+
+	projectionLog.Info("Bind mount completed successfully")
+	return nil
+}
+
+// symlink replaces Dest with a symlink to Src. An empty directory is removed,
+// a non-empty one (AllowShadow) is moved aside.
+func (a *ProjectDirectory) symlink() error {
+	if info, err := os.Lstat(a.Dest); err == nil {
+		n, _ := a.destEntries()
+		switch {
+		case info.Mode()&os.ModeSymlink != 0 || (info.IsDir() && n == 0):
+			err = os.Remove(a.Dest)
+		default:
+			backup := fmt.Sprintf("%s.phoenix-shadow-%s", a.Dest, time.Now().Format("20060102-150405"))
+			projectionLog.Warnf("Moving %s aside to %s", a.Dest, backup)
+			err = os.Rename(a.Dest, backup)
+		}
+		if err != nil {
+			return fail(CategoryPermission, "Move the existing path away manually",
+				fmt.Errorf("failed to replace %s: %w", a.Dest, err))
+		}
+	}
+
+	projectionLog.Infof("Linking %s -> %s", a.Dest, a.Src)
+	if err := os.Symlink(a.Src, a.Dest); err != nil {
+		return fail(CategoryPermission, "Check ownership of the home directory",
+			fmt.Errorf("failed to create symlink: %w", err))
+	}
+	if err := os.Lchown(a.Dest, a.UID, a.GID); err != nil {
+		return fail(CategoryPermission, "Check ownership of the home directory", err)
+	}
+
+	projectionLog.Info("Symlink created successfully")
+	return nil
+}
+
+// projected reports whether Dest already projects Src. A mount or link
+// that points elsewhere is an error: Phoenix never replaces it silently.
+func (a *ProjectDirectory) projected() (bool, error) {
+	if a.Method == ProjectSymlink {
+		target, err := os.Readlink(a.Dest)
+		if err != nil {
+			return false, nil
+		}
+		if target != a.Src {
+			return false, fail(CategoryConfig, "Remove the symlink manually if the projection changed",
+				fmt.Errorf("%s is a symlink to %s, expected %s", a.Dest, target, a.Src))
+		}
+		return true, nil
+	}
+
+	mounts, err := utils.ReadMountInfo()
+	if err != nil {
+		return false, fail(CategoryDevice, "", err)
+	}
+	m, ok := utils.FindMount(mounts, a.Dest)
+	if !ok {
+		return false, nil
+	}
+
+	destInfo, err := os.Stat(a.Dest)
+	if err != nil {
+		return false, err
+	}
+	srcInfo, err := os.Stat(a.Src)
+	if err == nil && os.SameFile(srcInfo, destInfo) {
+		return true, nil
+	}
+	return false, fail(CategoryConfig, fmt.Sprintf("Unmount it with `umount %s` if the projection changed", a.Dest),
+		fmt.Errorf("%s is already a mount point of %s (root %s), not %s", a.Dest, m.Source, m.Root, a.Src))
+}
+
+// destEntries counts what a projection would hide at Dest. A symlink counts
+// as nothing to hide; a regular file can never be projected over.
+func (a *ProjectDirectory) destEntries() (int, error) {
+	info, err := os.Lstat(a.Dest)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		if a.Method == ProjectBind {
+			return 0, fail(CategoryConfig, "Remove the symlink before bind-mounting over it",
+				fmt.Errorf("%s is a symlink", a.Dest))
+		}
+		return 0, nil
+	}
+	if !info.IsDir() {
+		return 0, fail(CategoryConfig, "Move the file away, projections only replace directories",
+			fmt.Errorf("%s exists and is not a directory", a.Dest))
+	}
+	return countEntries(a.Dest), nil
+}
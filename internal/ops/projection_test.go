@@ -0,0 +1,127 @@
+package ops
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/acker1019/fedora-phoenix/internal/utils/utilstest"
+)
+
+// newProjection returns a projection of notes on a volume mounted at a
+// temporary directory onto dest.
+func newProjection(t *testing.T, dest, method string) *ProjectDirectory {
+	t.Helper()
+	vault := t.TempDir()
+	return &ProjectDirectory{
+		Src: filepath.Join(vault, "notes"), Dest: dest, Method: method, VolumeMount: vault,
+		UID: os.Getuid(), GID: os.Getgid(),
+	}
+}
+
+func TestProjectDirectorySymlink(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "notes")
+	a := newProjection(t, dest, ProjectSymlink)
+	r := utilstest.NewFakeRunner()
+
+	diffs, err := a.Check(r)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if len(pendingDiffs(diffs)) != 1 {
+		t.Fatalf("Check() = %+v, want one pending Diff", diffs)
+	}
+	if err := a.Apply(r, pendingDiffs(diffs)); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if target, err := os.Readlink(dest); err != nil || target != a.Src {
+		t.Errorf("%s -> %q (%v), want %s", dest, target, err, a.Src)
+	}
+	if info, err := os.Stat(a.Src); err != nil || !info.IsDir() {
+		t.Errorf("source directory not created: %v", err)
+	}
+
+	// Applied projections are satisfied
+	if diffs, err := a.Check(r); err != nil || len(pendingDiffs(diffs)) != 0 {
+		t.Errorf("Check() after Apply = %+v (%v), want nothing pending", diffs, err)
+	}
+}
+
+func TestProjectDirectorySymlinkToAnotherDirectory(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "notes")
+	if err := os.Symlink("/data/elsewhere", dest); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := newProjection(t, dest, ProjectSymlink).Check(utilstest.NewFakeRunner())
+	checkCategory(t, err, CategoryConfig)
+}
+
+func TestProjectDirectoryBind(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "notes")
+	a := newProjection(t, dest, ProjectBind)
+	r := utilstest.NewFakeRunner()
+	r.Expect("mount --bind " + a.Src + " " + dest)
+
+	diffs, err := a.Check(r)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if err := a.Apply(r, pendingDiffs(diffs)); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if unmet := r.Unmet(); unmet != nil {
+		t.Errorf("runner script not followed: %q", unmet)
+	}
+	if info, err := os.Stat(dest); err != nil || !info.IsDir() {
+		t.Errorf("mount target not created: %v", err)
+	}
+}
+
+func TestProjectDirectoryBindFails(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "notes")
+	a := newProjection(t, dest, ProjectBind)
+	r := utilstest.NewFakeRunner()
+	r.Expect("mount --bind " + a.Src + " " + dest).Exit(32)
+
+	checkCategory(t, a.Apply(r, nil), CategoryDevice)
+}
+
+func TestProjectDirectoryShadowing(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "notes")
+	if err := os.MkdirAll(filepath.Join(dest, "todo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	a := newProjection(t, dest, ProjectBind)
+
+	// A non-empty directory is not shadowed by default
+	_, err := a.Check(utilstest.NewFakeRunner())
+	checkCategory(t, err, CategoryConfig)
+
+	a.AllowShadow = true
+	diffs, err := a.Check(utilstest.NewFakeRunner())
+	if err != nil {
+		t.Fatalf("Check() with AllowShadow error = %v", err)
+	}
+	if len(pendingDiffs(diffs)) != 1 {
+		t.Errorf("Check() with AllowShadow = %+v, want one pending Diff", diffs)
+	}
+}
+
+func TestProjectDirectoryOptionalVolume(t *testing.T) {
+	a := newProjection(t, filepath.Join(t.TempDir(), "notes"), ProjectBind)
+	a.Optional = true
+	r := utilstest.NewFakeRunner()
+	r.Expect("mountpoint -q " + a.VolumeMount).Exit(32)
+
+	diffs, err := a.Check(r)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if len(pendingDiffs(diffs)) != 0 {
+		t.Errorf("Check() = %+v, want the projection skipped", diffs)
+	}
+	if unmet := r.Unmet(); unmet != nil {
+		t.Errorf("runner script not followed: %q", unmet)
+	}
+}
@@ -0,0 +1,129 @@
+package ops
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/acker1019/fedora-phoenix/internal/logging"
+	"github.com/acker1019/fedora-phoenix/internal/utils"
+)
+
+var sealLog = logging.WithSource("ops/seal")
+
+// The seal Acts reverse ProjectDirectory, MountDevice and UnlockLuks.
+// `phoenix seal` runs them in reverse blueprint order.
+
+// TeardownProjection removes a projection: a bind mount is unmounted,
+// a symlink that still points to Src is removed. Anything else is left alone.
+type TeardownProjection struct {
+	Src    string
+	Dest   string
+	Method string // ProjectBind or ProjectSymlink
+}
+
+func (a *TeardownProjection) Name() string { return "TeardownProjection" }
+func (a *TeardownProjection) Block() Block { return BlockUserSpace }
+
+// Check reports whether the projection is still in place.
+func (a *TeardownProjection) Check(r utils.Runner) ([]Diff, error) {
+	item := fmt.Sprintf("projection %s", a.Dest)
+
+	if a.Method == ProjectSymlink {
+		if target, err := os.Readlink(a.Dest); err == nil && target == a.Src {
+			return []Diff{pending(a.Dest, item, "would remove symlink")}, nil
+		}
+		return []Diff{satisfied(a.Dest, item, "no symlink")}, nil
+	}
+
+	if IsMounted(r, a.Dest) {
+		return []Diff{pending(a.Dest, item, "would unmount bind mount")}, nil
+	}
+	return []Diff{satisfied(a.Dest, item, "not mounted")}, nil
+}
+
+// Apply unmounts or unlinks the projection.
+func (a *TeardownProjection) Apply(r utils.Runner, pending []Diff) error {
+	if a.Method == ProjectSymlink {
+		sealLog.Infof("Removing symlink %s", a.Dest)
+		if err := os.Remove(a.Dest); err != nil {
+			return fail(CategoryPermission, "Remove the symlink manually", err)
+		}
+		return nil
+	}
+	return unmount(r, a.Dest)
+}
+
+// UnmountDevice unmounts a LUKS volume's mount point.
+type UnmountDevice struct {
+	MountPoint string
+}
+
+func (a *UnmountDevice) Name() string { return "UnmountDevice" }
+func (a *UnmountDevice) Block() Block { return BlockInfrastructure }
+
+// Check reports whether the mount point is still mounted.
+func (a *UnmountDevice) Check(r utils.Runner) ([]Diff, error) {
+	item := fmt.Sprintf("mount %s", a.MountPoint)
+	if IsMounted(r, a.MountPoint) {
+		return []Diff{pending(a.MountPoint, item, "would unmount")}, nil
+	}
+	return []Diff{satisfied(a.MountPoint, item, "not mounted")}, nil
+}
+
+// Apply unmounts the volume.
+func (a *UnmountDevice) Apply(r utils.Runner, pending []Diff) error {
+	return unmount(r, a.MountPoint)
+}
+
+// LockLuks closes the mapper device with `cryptsetup close`.
+type LockLuks struct {
+	MapperName string
+}
+
+func (a *LockLuks) Name() string { return "LockLuks" }
+func (a *LockLuks) Block() Block { return BlockInfrastructure }
+
+// Check reports whether /dev/mapper/<MapperName> still exists.
+func (a *LockLuks) Check(r utils.Runner) ([]Diff, error) {
+	item := fmt.Sprintf("LUKS %s", a.MapperName)
+	if isLuksUnlocked(a.MapperName) {
+		return []Diff{pending(a.MapperName, item, "would lock")}, nil
+	}
+	return []Diff{satisfied(a.MapperName, item, "already locked")}, nil
+}
+
+// Apply closes the mapper device.
+func (a *LockLuks) Apply(r utils.Runner, pending []Diff) error {
+	sealLog.Infof("Locking %s", a.MapperName)
+	if err := r.Run("cryptsetup", "close", a.MapperName); err != nil {
+		return fail(CategoryDevice, fmt.Sprintf("Check `dmsetup info %s` for remaining holders (open files, swap, LVM)", a.MapperName),
+			fmt.Errorf("failed to lock %s: %w", a.MapperName, err))
+	}
+
+	sealLog.Infof("%s locked", a.MapperName)
+	return nil
+}
+
+// unmount runs umount on path. When it fails, the processes keeping
+// path busy are listed in the error instead of umount's bare "target is busy".
+func unmount(r utils.Runner, path string) error {
+	sealLog.Infof("Unmounting %s", path)
+	err := r.Run("umount", path)
+	if err == nil {
+		return nil
+	}
+
+	uses, scanErr := utils.ProcessesUsing(path)
+	if scanErr != nil || len(uses) == 0 {
+		return fail(CategoryDevice, fmt.Sprintf("Find the holders with `fuser -vm %s`", path),
+			fmt.Errorf("failed to unmount %s: %w", path, err))
+	}
+
+	lines := make([]string, 0, len(uses))
+	for _, use := range uses {
+		lines = append(lines, "  "+use.String())
+	}
+	return fail(CategoryDevice, "Close these processes (or leave the directory in your shells) and run seal again",
+		fmt.Errorf("failed to unmount %s, busy by %d processes:\n%s", path, len(uses), strings.Join(lines, "\n")))
+}
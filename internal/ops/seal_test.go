@@ -0,0 +1,98 @@
+package ops
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/acker1019/fedora-phoenix/internal/utils/utilstest"
+)
+
+func TestTeardownProjectionRemovesSymlink(t *testing.T) {
+	link := filepath.Join(t.TempDir(), "notes")
+	if err := os.Symlink("/data/notes", link); err != nil {
+		t.Fatal(err)
+	}
+	a := &TeardownProjection{Src: "/data/notes", Dest: link, Method: ProjectSymlink}
+
+	diffs, err := a.Check(nil)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if len(pendingDiffs(diffs)) != 1 {
+		t.Fatalf("Check() = %+v, want one pending Diff", diffs)
+	}
+	if err := a.Apply(nil, pendingDiffs(diffs)); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if _, err := os.Lstat(link); !os.IsNotExist(err) {
+		t.Errorf("symlink %s still present (%v)", link, err)
+	}
+}
+
+func TestTeardownProjectionKeepsForeignSymlink(t *testing.T) {
+	link := filepath.Join(t.TempDir(), "notes")
+	if err := os.Symlink("/data/elsewhere", link); err != nil {
+		t.Fatal(err)
+	}
+
+	diffs, err := (&TeardownProjection{Src: "/data/notes", Dest: link, Method: ProjectSymlink}).Check(nil)
+	if err != nil || len(pendingDiffs(diffs)) != 0 {
+		t.Errorf("Check() = %+v (%v), want a foreign symlink left alone", diffs, err)
+	}
+}
+
+func TestTeardownProjectionUnmountsBind(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "notes")
+	a := &TeardownProjection{Src: "/data/notes", Dest: dest, Method: ProjectBind}
+	r := utilstest.NewFakeRunner()
+	r.Expect("mountpoint -q " + dest)
+	r.Expect("umount " + dest)
+
+	diffs, err := a.Check(r)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if err := a.Apply(r, pendingDiffs(diffs)); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if unmet := r.Unmet(); unmet != nil {
+		t.Errorf("runner script not followed: %q", unmet)
+	}
+}
+
+func TestUnmountDevice(t *testing.T) {
+	mnt := filepath.Join(t.TempDir(), "vault")
+
+	r := utilstest.NewFakeRunner()
+	r.Expect("mountpoint -q " + mnt).Exit(32)
+	if diffs, err := (&UnmountDevice{MountPoint: mnt}).Check(r); err != nil || len(pendingDiffs(diffs)) != 0 {
+		t.Errorf("Check() of an unmounted volume = %+v (%v), want nothing pending", diffs, err)
+	}
+
+	r = utilstest.NewFakeRunner()
+	r.Expect("umount " + mnt)
+	if err := (&UnmountDevice{MountPoint: mnt}).Apply(r, nil); err != nil {
+		t.Errorf("Apply() error = %v", err)
+	}
+
+	r = utilstest.NewFakeRunner()
+	r.Expect("umount " + mnt).Exit(32).Stderr("target is busy")
+	checkCategory(t, (&UnmountDevice{MountPoint: mnt}).Apply(r, nil), CategoryDevice)
+}
+
+func TestLockLuks(t *testing.T) {
+	if diffs, err := (&LockLuks{MapperName: testMapper}).Check(nil); err != nil || len(pendingDiffs(diffs)) != 0 {
+		t.Errorf("Check() of a locked volume = %+v (%v), want nothing pending", diffs, err)
+	}
+
+	r := utilstest.NewFakeRunner()
+	r.Expect("cryptsetup close " + testMapper)
+	if err := (&LockLuks{MapperName: testMapper}).Apply(r, nil); err != nil {
+		t.Errorf("Apply() error = %v", err)
+	}
+
+	r = utilstest.NewFakeRunner()
+	r.Expect("cryptsetup close " + testMapper).Exit(5)
+	checkCategory(t, (&LockLuks{MapperName: testMapper}).Apply(r, nil), CategoryDevice)
+}
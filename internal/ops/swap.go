@@ -0,0 +1,426 @@
+package ops
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/acker1019/fedora-phoenix/internal/logging"
+	"github.com/acker1019/fedora-phoenix/internal/utils"
+	"golang.org/x/sys/unix"
+)
+
+var swapLog = logging.WithSource("ops/swap")
+
+// zram-generator configuration: the /etc file overrides the packaged default
+const (
+	ZramGeneratorPath        = "/etc/systemd/zram-generator.conf"
+	zramGeneratorDefaultPath = "/usr/lib/systemd/zram-generator.conf"
+)
+
+// Random-key swap cipher, as in the crypttab(5) swap example
+const (
+	swapCipher  = "aes-xts-plain64"
+	swapKeySize = "512"
+)
+
+// EncryptedSwap sets up a swap partition encrypted with a random key
+// (plain dm-crypt keyed from /dev/urandom). The key is never stored, so the
+// partition is re-created on every boot by the crypttab "swap" option and
+// its content is unreadable after power-off.
+//
+// Because the partition is overwritten, Device must be addressed by
+// something that survives mkswap (a path, partuuid: or partlabel:), and
+// a device that still carries another signature (LUKS, a filesystem) is refused.
+type EncryptedSwap struct {
+	Device       string // Partition spec
+	MapperName   string
+	CrypttabPath string
+	FstabPath    string
+}
+
+func (a *EncryptedSwap) Name() string { return "EncryptedSwap" }
+func (a *EncryptedSwap) Block() Block { return BlockInfrastructure }
+
+// Check reports whether the swap mapper is active and both table entries are in place.
+func (a *EncryptedSwap) Check(r utils.Runner) ([]Diff, error) {
+	dev, err := utils.ResolveDevice(a.Device)
+	if err != nil {
+		return nil, fail(CategoryDevice, "Check infrastructure.swap device against `ls -l /dev/disk/by-*`", err)
+	}
+
+	var diffs []Diff
+	item := fmt.Sprintf("swap %s", a.MapperName)
+	active, err := swapActive(a.mapperPath())
+	if err != nil {
+		return nil, fail(CategoryDevice, "", err)
+	}
+	if active {
+		diffs = append(diffs, satisfied(a.MapperName, item, "already active"))
+	} else {
+		// Once open, the raw partition is random data; only probe it while closed
+		if !isLuksUnlocked(a.MapperName) {
+			if err := refuseForeignSignature(r, dev); err != nil {
+				return nil, err
+			}
+		}
+		diffs = append(diffs, pending(a.MapperName, item, fmt.Sprintf("would format %s as random-key swap", describeDevice(a.Device, dev))))
+	}
+
+	crypttab, fstab, err := a.entries()
+	if err != nil {
+		return nil, err
+	}
+	entryDiffs, err := blockDiffs(a.MapperName, "swap:"+a.MapperName, [][2]string{{a.CrypttabPath, crypttab}, {a.FstabPath, fstab}})
+	if err != nil {
+		return nil, err
+	}
+	return append(diffs, entryDiffs...), nil
+}
+
+// Apply opens the partition with a random key, formats and enables it,
+// and writes the entries that repeat this on every boot.
+func (a *EncryptedSwap) Apply(r utils.Runner, pending []Diff) error {
+	crypttab, fstab, err := a.entries()
+	if err != nil {
+		return err
+	}
+	lines := map[string]string{a.CrypttabPath: crypttab, a.FstabPath: fstab}
+
+	tablesChanged := false
+	for _, d := range pending {
+		if d.Key == a.MapperName {
+			if err := a.activate(r); err != nil {
+				return err
+			}
+			continue
+		}
+		swapLog.Infof("Writing %s entry for %s", d.Key, a.MapperName)
+		if err := writeManagedBlock(d.Key, "swap:"+a.MapperName, lines[d.Key]); err != nil {
+			return fail(CategoryPermission, fmt.Sprintf("Check that %s is writable by root", d.Key),
+				fmt.Errorf("failed to update %s: %w", d.Key, err))
+		}
+		tablesChanged = true
+	}
+
+	if tablesChanged {
+		if err := r.Run("systemctl", "daemon-reload"); err != nil {
+			return fail(CategoryService, "Run `systemctl daemon-reload` manually", err)
+		}
+	}
+	return nil
+}
+
+// activate does now what systemd-cryptsetup does at boot.
+func (a *EncryptedSwap) activate(r utils.Runner) error {
+	dev, err := utils.ResolveDevice(a.Device)
+	if err != nil {
+		return fail(CategoryDevice, "", err)
+	}
+
+	if !isLuksUnlocked(a.MapperName) {
+		swapLog.Infof("Opening %s as %s with a random key", dev, a.MapperName)
+		err := r.Run("cryptsetup", "open", "--type", "plain", "--key-file", "/dev/urandom",
+			"--cipher", swapCipher, "--key-size", swapKeySize, dev, a.MapperName)
+		if err != nil {
+			return fail(CategoryDevice, "Check that the partition is not in use", fmt.Errorf("failed to open swap %s: %w", dev, err))
+		}
+	}
+
+	if err := r.Run("mkswap", a.mapperPath()); err != nil {
+		return fail(CategoryDevice, "", fmt.Errorf("mkswap %s failed: %w", a.mapperPath(), err))
+	}
+	if err := r.Run("swapon", a.mapperPath()); err != nil {
+		return fail(CategoryDevice, "", fmt.Errorf("swapon %s failed: %w", a.mapperPath(), err))
+	}
+
+	swapLog.Infof("Encrypted swap %s active", a.MapperName)
+	return nil
+}
+
+// entries builds the crypttab and fstab lines. The crypttab source is the
+// stable /dev/disk/by-* link, never the kernel name, which may change between boots.
+func (a *EncryptedSwap) entries() (crypttab, fstab string, err error) {
+	source, err := utils.DeviceSpecPath(a.Device)
+	if err != nil {
+		return "", "", fail(CategoryConfig, "", err)
+	}
+	crypttab = fmt.Sprintf("%s %s /dev/urandom swap,plain,cipher=%s,size=%s", a.MapperName, fstabEscape(source), swapCipher, swapKeySize)
+	fstab = fmt.Sprintf("%s none swap defaults 0 0", a.mapperPath())
+	return crypttab, fstab, nil
+}
+
+func (a *EncryptedSwap) mapperPath() string {
+	return fmt.Sprintf("/dev/mapper/%s", a.MapperName)
+}
+
+// blkid exit code when it finds no signature on the device
+const blkidNothingFound = 2
+
+// refuseForeignSignature fails if blkid finds anything but swap on dev:
+// formatting it would destroy a LUKS volume or a filesystem. A probe that
+// fails for any other reason is an error too, never a blank device.
+func refuseForeignSignature(r utils.Runner, dev string) error {
+	out, err := r.Output("blkid", "-p", "-s", "TYPE", "-o", "value", dev)
+	var cmdErr *utils.CommandError
+	if errors.As(err, &cmdErr) && cmdErr.ExitCode == blkidNothingFound {
+		return nil // A blank partition, which is what we hope for
+	}
+	if err != nil {
+		return fail(CategoryDevice, fmt.Sprintf("Run `blkid -p %s` manually to see why the probe fails", dev),
+			fmt.Errorf("failed to probe %s for signatures: %w", dev, err))
+	}
+	if sig := strings.TrimSpace(string(out)); sig != "" && sig != "swap" {
+		return fail(CategoryDevice, "Point infrastructure.swap device to a spare partition, or wipe it yourself with `wipefs -a` if it is really unused",
+			fmt.Errorf("refusing to format %s as swap: it contains %s", dev, sig))
+	}
+	return nil
+}
+
+// SwapFile creates and enables a swap file on a (LUKS-encrypted) volume
+// and lists it in /etc/fstab. On btrfs the file is created with
+// `btrfs filesystem mkswapfile`, which disables copy-on-write and compression.
+type SwapFile struct {
+	Path        string // Absolute path inside the volume's mount point
+	Size        string // e.g. "8G"
+	VolumeMount string
+	Optional    bool // Skip when the (optional) volume is not mounted
+	FstabPath   string
+}
+
+func (a *SwapFile) Name() string { return "SwapFile" }
+func (a *SwapFile) Block() Block { return BlockInfrastructure }
+
+// Check reports whether the swap file exists and is active, and whether its fstab entry is in place.
+func (a *SwapFile) Check(r utils.Runner) ([]Diff, error) {
+	item := fmt.Sprintf("swap %s", a.Path)
+
+	if a.Optional && !IsMounted(r, a.VolumeMount) {
+		swapLog.Warnf("Volume %s is not mounted. Skipping swap file %s.", a.VolumeMount, a.Path)
+		return []Diff{satisfied(a.Path, item, fmt.Sprintf("optional volume %s not mounted, skipped", a.VolumeMount))}, nil
+	}
+
+	var diffs []Diff
+	active, err := swapActive(a.Path)
+	if err != nil {
+		return nil, fail(CategoryDevice, "", err)
+	}
+	switch info, err := os.Stat(a.Path); {
+	case active:
+		diffs = append(diffs, satisfied(a.Path, item, "already active"))
+	case err == nil && info.Mode().IsRegular():
+		diffs = append(diffs, pending(a.Path, item, "would enable existing swap file"))
+	case err == nil:
+		return nil, fail(CategoryConfig, "Choose another infrastructure.swap path",
+			fmt.Errorf("%s exists and is not a regular file", a.Path))
+	default:
+		diffs = append(diffs, pending(a.Path, item, fmt.Sprintf("would create %s swap file", a.Size)))
+	}
+
+	entryDiffs, err := blockDiffs(a.Path, "swap:"+a.Path, [][2]string{{a.FstabPath, a.entry()}})
+	if err != nil {
+		return nil, err
+	}
+	return append(diffs, entryDiffs...), nil
+}
+
+// Apply creates the swap file if needed, enables it and writes the fstab entry.
+func (a *SwapFile) Apply(r utils.Runner, pending []Diff) error {
+	for _, d := range pending {
+		if d.Key == a.FstabPath {
+			swapLog.Infof("Writing %s entry for %s", d.Key, a.Path)
+			if err := writeManagedBlock(d.Key, "swap:"+a.Path, a.entry()); err != nil {
+				return fail(CategoryPermission, fmt.Sprintf("Check that %s is writable by root", d.Key),
+					fmt.Errorf("failed to update %s: %w", d.Key, err))
+			}
+			if err := r.Run("systemctl", "daemon-reload"); err != nil {
+				return fail(CategoryService, "Run `systemctl daemon-reload` manually", err)
+			}
+			continue
+		}
+
+		if _, err := os.Stat(a.Path); os.IsNotExist(err) {
+			if err := a.create(r); err != nil {
+				return err
+			}
+		}
+		// swapon refuses (or warns about) swap files readable by others
+		if err := os.Chmod(a.Path, 0600); err != nil {
+			return fail(CategoryPermission, "", err)
+		}
+		swapLog.Infof("Enabling swap file %s", a.Path)
+		if err := r.Run("swapon", a.Path); err != nil {
+			return fail(CategoryDevice, fmt.Sprintf("Check the file with `file -s %s`; remove it to let provision re-create it", a.Path),
+				fmt.Errorf("swapon %s failed: %w", a.Path, err))
+		}
+	}
+	return nil
+}
+
+// create allocates the swap file and writes its swap signature.
+func (a *SwapFile) create(r utils.Runner) error {
+	if err := os.MkdirAll(filepath.Dir(a.Path), 0755); err != nil {
+		return fail(CategoryPermission, "Check that the volume is mounted read-write", err)
+	}
+
+	var st unix.Statfs_t
+	if err := unix.Statfs(filepath.Dir(a.Path), &st); err != nil {
+		return fail(CategoryDevice, "", fmt.Errorf("failed to stat filesystem of %s: %w", a.Path, err))
+	}
+
+	swapLog.Infof("Creating %s swap file %s", a.Size, a.Path)
+	if st.Type == unix.BTRFS_SUPER_MAGIC {
+		if err := r.Run("btrfs", "filesystem", "mkswapfile", "--size", a.Size, a.Path); err != nil {
+			return fail(CategoryCommand, "btrfs-progs 6.1 or newer is required for `btrfs filesystem mkswapfile`",
+				fmt.Errorf("failed to create swap file %s: %w", a.Path, err))
+		}
+		return nil
+	}
+
+	if err := r.Run("fallocate", "-l", a.Size, a.Path); err != nil {
+		return fail(CategoryDevice, "Check free space on the volume", fmt.Errorf("failed to allocate %s: %w", a.Path, err))
+	}
+	if err := os.Chmod(a.Path, 0600); err != nil {
+		return fail(CategoryPermission, "", err)
+	}
+	if err := r.Run("mkswap", a.Path); err != nil {
+		return fail(CategoryDevice, "", fmt.Errorf("mkswap %s failed: %w", a.Path, err))
+	}
+	return nil
+}
+
+// entry is the fstab line; nofail keeps boot going while the volume is locked.
+func (a *SwapFile) entry() string {
+	return fmt.Sprintf("%s none swap defaults,nofail 0 0", fstabEscape(a.Path))
+}
+
+// DisableZram turns off zram swap: the zram-generator configuration is
+// replaced by one without devices (the documented way to opt out on Fedora)
+// and active /dev/zram* swaps are switched off.
+type DisableZram struct {
+	ConfigPath string
+}
+
+func (a *DisableZram) Name() string { return "DisableZram" }
+func (a *DisableZram) Block() Block { return BlockInfrastructure }
+
+// Check reports whether zram-generator would still create a device and which zram swaps are active.
+func (a *DisableZram) Check(r utils.Runner) ([]Diff, error) {
+	var diffs []Diff
+
+	configured, err := a.configured()
+	if err != nil {
+		return nil, fail(CategoryPermission, "", err)
+	}
+	if configured {
+		diffs = append(diffs, pending(a.ConfigPath, "zram-generator", "would disable zram devices"))
+	} else {
+		diffs = append(diffs, satisfied(a.ConfigPath, "zram-generator", "no zram device configured"))
+	}
+
+	swaps, err := utils.ActiveSwaps()
+	if err != nil {
+		return nil, fail(CategoryDevice, "", err)
+	}
+	for _, s := range swaps {
+		if strings.HasPrefix(s.Filename, "/dev/zram") {
+			diffs = append(diffs, pending(s.Filename, fmt.Sprintf("swap %s", s.Filename), "would swapoff"))
+		}
+	}
+	return diffs, nil
+}
+
+// Apply writes the empty configuration and switches zram swaps off.
+func (a *DisableZram) Apply(r utils.Runner, pending []Diff) error {
+	for _, d := range pending {
+		if d.Key == a.ConfigPath {
+			swapLog.Infof("Disabling zram devices in %s", a.ConfigPath)
+			content := "# Managed by phoenix (infrastructure.swap.disable_zram): no zram devices\n"
+			if err := os.WriteFile(a.ConfigPath, []byte(content), 0644); err != nil {
+				return fail(CategoryPermission, "", fmt.Errorf("failed to write %s: %w", a.ConfigPath, err))
+			}
+			continue
+		}
+
+		swapLog.Infof("Switching off %s", d.Key)
+		if err := r.Run("swapoff", d.Key); err != nil {
+			return fail(CategoryDevice, "Check free memory: swapoff needs room for the swapped-out pages",
+				fmt.Errorf("swapoff %s failed: %w", d.Key, err))
+		}
+	}
+	return nil
+}
+
+// configured reports whether the effective zram-generator configuration
+// (ConfigPath, or the packaged default when it is absent) defines a device.
+func (a *DisableZram) configured() (bool, error) {
+	content, err := os.ReadFile(a.ConfigPath)
+	if errors.Is(err, os.ErrNotExist) {
+		content, err = os.ReadFile(zramGeneratorDefaultPath)
+		if errors.Is(err, os.ErrNotExist) {
+			return false, nil
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "[zram") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SwapOff disables a swap file before its volume is unmounted by seal.
+type SwapOff struct {
+	Path string
+}
+
+func (a *SwapOff) Name() string { return "SwapOff" }
+func (a *SwapOff) Block() Block { return BlockInfrastructure }
+
+// Check reports whether the swap file is still active.
+func (a *SwapOff) Check(r utils.Runner) ([]Diff, error) {
+	item := fmt.Sprintf("swap %s", a.Path)
+	active, err := swapActive(a.Path)
+	if err != nil {
+		return nil, fail(CategoryDevice, "", err)
+	}
+	if active {
+		return []Diff{pending(a.Path, item, "would swapoff")}, nil
+	}
+	return []Diff{satisfied(a.Path, item, "not active")}, nil
+}
+
+// Apply switches the swap file off.
+func (a *SwapOff) Apply(r utils.Runner, pending []Diff) error {
+	sealLog.Infof("Switching off swap file %s", a.Path)
+	if err := r.Run("swapoff", a.Path); err != nil {
+		return fail(CategoryDevice, "Check free memory: swapoff needs room for the swapped-out pages",
+			fmt.Errorf("swapoff %s failed: %w", a.Path, err))
+	}
+	return nil
+}
+
+// swapActive reports whether path (a swap file, or a device link such as
+// /dev/mapper/x that /proc/swaps lists as /dev/dm-N) is an active swap area.
+func swapActive(path string) (bool, error) {
+	swaps, err := utils.ActiveSwaps()
+	if err != nil {
+		return false, err
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		resolved = path
+	}
+	for _, s := range swaps {
+		if s.Filename == path || s.Filename == resolved {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,268 @@
+package ops
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/acker1019/fedora-phoenix/internal/utils"
+	"github.com/acker1019/fedora-phoenix/internal/utils/utilstest"
+)
+
+const testSwapMapper = "phoenix-test-swap"
+
+// newEncryptedSwap returns an EncryptedSwap of a fake partition that writes
+// to a crypttab and fstab in a temporary directory.
+func newEncryptedSwap(t *testing.T) *EncryptedSwap {
+	t.Helper()
+	dir := t.TempDir()
+	return &EncryptedSwap{
+		Device: fakeDevice(t), MapperName: testSwapMapper,
+		CrypttabPath: filepath.Join(dir, "crypttab"), FstabPath: filepath.Join(dir, "fstab"),
+	}
+}
+
+// expectSwapOpen scripts opening the partition of a with a random key.
+func expectSwapOpen(r *utilstest.FakeRunner, a *EncryptedSwap) {
+	r.Expect("cryptsetup open --type plain --key-file /dev/urandom --cipher aes-xts-plain64 --key-size 512 " +
+		a.Device + " " + testSwapMapper)
+}
+
+func TestEncryptedSwapBlankPartition(t *testing.T) {
+	a := newEncryptedSwap(t)
+	r := utilstest.NewFakeRunner()
+	r.Expect("blkid -p -s TYPE -o value " + a.Device).Exit(2)
+	expectSwapOpen(r, a)
+	r.Expect("mkswap /dev/mapper/" + testSwapMapper)
+	r.Expect("swapon /dev/mapper/" + testSwapMapper)
+	r.Expect("systemctl daemon-reload")
+
+	diffs, err := a.Check(r)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if got, want := diffKeys(pendingDiffs(diffs)), []string{testSwapMapper, a.CrypttabPath, a.FstabPath}; !slices.Equal(got, want) {
+		t.Fatalf("pending = %q, want %q", got, want)
+	}
+	if err := a.Apply(r, pendingDiffs(diffs)); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if unmet := r.Unmet(); unmet != nil {
+		t.Errorf("runner script not followed: %q", unmet)
+	}
+
+	want := testSwapMapper + " " + a.Device + " /dev/urandom swap,plain,cipher=aes-xts-plain64,size=512"
+	if content, err := os.ReadFile(a.CrypttabPath); err != nil || !strings.Contains(string(content), want) {
+		t.Errorf("crypttab = %q (%v), want entry %q", content, err, want)
+	}
+}
+
+func TestEncryptedSwapReusesSwapSignature(t *testing.T) {
+	a := newEncryptedSwap(t)
+	crypttab, fstab, err := a.entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeManagedBlock(a.CrypttabPath, "swap:"+testSwapMapper, crypttab); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeManagedBlock(a.FstabPath, "swap:"+testSwapMapper, fstab); err != nil {
+		t.Fatal(err)
+	}
+
+	// A swap area from the previous boot is random data under a new key
+	r := utilstest.NewFakeRunner()
+	r.Expect("blkid -p -s TYPE -o value " + a.Device).Stdout("swap\n")
+	expectSwapOpen(r, a)
+	r.Expect("mkswap /dev/mapper/" + testSwapMapper)
+	r.Expect("swapon /dev/mapper/" + testSwapMapper)
+
+	diffs, err := a.Check(r)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if got, want := diffKeys(pendingDiffs(diffs)), []string{testSwapMapper}; !slices.Equal(got, want) {
+		t.Fatalf("pending = %q, want %q", got, want)
+	}
+	if err := a.Apply(r, pendingDiffs(diffs)); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if unmet := r.Unmet(); unmet != nil {
+		t.Errorf("runner script not followed: %q", unmet)
+	}
+}
+
+func TestEncryptedSwapRefusesLuksPartition(t *testing.T) {
+	a := newEncryptedSwap(t)
+	r := utilstest.NewFakeRunner()
+	r.Expect("blkid -p -s TYPE -o value " + a.Device).Stdout("crypto_LUKS\n")
+
+	_, err := a.Check(r)
+	checkCategory(t, err, CategoryDevice)
+}
+
+func TestEncryptedSwapProbeFails(t *testing.T) {
+	a := newEncryptedSwap(t)
+	r := utilstest.NewFakeRunner()
+	r.Expect("blkid -p -s TYPE -o value " + a.Device).Exit(4).Stderr("ambivalent result")
+
+	_, err := a.Check(r)
+	checkCategory(t, err, CategoryDevice)
+}
+
+func TestEncryptedSwapMkswapFails(t *testing.T) {
+	a := newEncryptedSwap(t)
+	r := utilstest.NewFakeRunner()
+	expectSwapOpen(r, a)
+	r.Expect("mkswap /dev/mapper/" + testSwapMapper).Exit(1)
+
+	err := a.Apply(r, []Diff{pending(testSwapMapper, "swap "+testSwapMapper, "")})
+	checkCategory(t, err, CategoryDevice)
+	if _, err := os.Stat(a.CrypttabPath); !os.IsNotExist(err) {
+		t.Errorf("crypttab written for a swap that failed to format (%v)", err)
+	}
+}
+
+// newSwapFile returns a SwapFile of an existing, empty file on a volume
+// mounted at a temporary directory.
+func newSwapFile(t *testing.T) *SwapFile {
+	t.Helper()
+	dir := t.TempDir()
+	a := &SwapFile{Path: filepath.Join(dir, "swapfile"), Size: "8G", VolumeMount: dir, FstabPath: filepath.Join(dir, "fstab")}
+	if err := os.WriteFile(a.Path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeManagedBlock(a.FstabPath, "swap:"+a.Path, a.entry()); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestSwapFileEnablesExistingFile(t *testing.T) {
+	a := newSwapFile(t)
+	r := utilstest.NewFakeRunner()
+	r.Expect("swapon " + a.Path)
+
+	diffs, err := a.Check(r)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if got, want := diffKeys(pendingDiffs(diffs)), []string{a.Path}; !slices.Equal(got, want) {
+		t.Fatalf("pending = %q, want %q", got, want)
+	}
+	if err := a.Apply(r, pendingDiffs(diffs)); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if unmet := r.Unmet(); unmet != nil {
+		t.Errorf("runner script not followed: %q", unmet)
+	}
+	if info, err := os.Stat(a.Path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("swap file mode = %v (%v), want 0600", info.Mode().Perm(), err)
+	}
+}
+
+func TestSwapFileOptionalVolumeNotMounted(t *testing.T) {
+	a := newSwapFile(t)
+	a.VolumeMount = "/mnt/phoenix-missing"
+	a.Optional = true
+	r := utilstest.NewFakeRunner()
+	r.Expect("mountpoint -q /mnt/phoenix-missing").Exit(32)
+
+	diffs, err := a.Check(r)
+	if err != nil || len(pendingDiffs(diffs)) != 0 {
+		t.Errorf("Check() = %+v (%v), want the swap file skipped", diffs, err)
+	}
+}
+
+func TestSwapFilePathIsDirectory(t *testing.T) {
+	a := newSwapFile(t)
+	a.Path = a.VolumeMount
+
+	_, err := a.Check(utilstest.NewFakeRunner())
+	checkCategory(t, err, CategoryConfig)
+}
+
+func TestSwapFileSwaponFails(t *testing.T) {
+	a := newSwapFile(t)
+	r := utilstest.NewFakeRunner()
+	r.Expect("swapon " + a.Path).Exit(255)
+
+	checkCategory(t, a.Apply(r, []Diff{pending(a.Path, "swap "+a.Path, "")}), CategoryDevice)
+}
+
+// activeZram returns the zram swaps of the test machine, which DisableZram
+// switches off too.
+func activeZram(t *testing.T) []string {
+	t.Helper()
+	swaps, err := utils.ActiveSwaps()
+	if err != nil {
+		t.Skipf("no swap table: %v", err)
+	}
+	var zram []string
+	for _, s := range swaps {
+		if strings.HasPrefix(s.Filename, "/dev/zram") {
+			zram = append(zram, s.Filename)
+		}
+	}
+	return zram
+}
+
+func TestDisableZram(t *testing.T) {
+	zram := activeZram(t)
+	config := filepath.Join(t.TempDir(), "zram-generator.conf")
+	if err := os.WriteFile(config, []byte("[zram0]\nzram-size = min(ram, 8192)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a := &DisableZram{ConfigPath: config}
+	r := utilstest.NewFakeRunner()
+	for _, dev := range zram {
+		r.Expect("swapoff " + dev)
+	}
+
+	diffs, err := a.Check(r)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if got, want := diffKeys(pendingDiffs(diffs)), append([]string{config}, zram...); !slices.Equal(got, want) {
+		t.Fatalf("pending = %q, want %q", got, want)
+	}
+	if err := a.Apply(r, pendingDiffs(diffs)); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if unmet := r.Unmet(); unmet != nil {
+		t.Errorf("runner script not followed: %q", unmet)
+	}
+	if content, err := os.ReadFile(config); err != nil || strings.Contains(string(content), "[zram") {
+		t.Errorf("configuration after Apply = %q (%v), want no zram device", content, err)
+	}
+
+	// The written configuration defines no device
+	diffs, err = a.Check(r)
+	if err != nil {
+		t.Fatalf("Check() after Apply error = %v", err)
+	}
+	if got := diffKeys(pendingDiffs(diffs)); slices.Contains(got, config) {
+		t.Errorf("pending after Apply = %q, want %s satisfied", got, config)
+	}
+}
+
+func TestDisableZramUnreadableConfig(t *testing.T) {
+	// A directory cannot be read as a configuration file
+	_, err := (&DisableZram{ConfigPath: t.TempDir()}).Check(utilstest.NewFakeRunner())
+	checkCategory(t, err, CategoryPermission)
+}
+
+func TestSwapOff(t *testing.T) {
+	swapfile := filepath.Join(t.TempDir(), "swapfile")
+
+	diffs, err := (&SwapOff{Path: swapfile}).Check(utilstest.NewFakeRunner())
+	if err != nil || len(pendingDiffs(diffs)) != 0 {
+		t.Errorf("Check() of an inactive swap file = %+v (%v), want nothing pending", diffs, err)
+	}
+
+	r := utilstest.NewFakeRunner()
+	r.Expect("swapoff " + swapfile).Exit(1)
+	checkCategory(t, (&SwapOff{Path: swapfile}).Apply(r, nil), CategoryDevice)
+}
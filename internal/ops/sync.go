@@ -0,0 +1,473 @@
+package ops
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/acker1019/fedora-phoenix/internal/logging"
+	"github.com/acker1019/fedora-phoenix/internal/statestore"
+	"github.com/acker1019/fedora-phoenix/internal/utils"
+	"golang.org/x/sys/unix"
+)
+
+var syncLog = logging.WithSource("ops/sync")
+
+// SyncItem is a sync rule with absolute, home-expanded paths.
+type SyncItem struct {
+	Src  string      // File or directory inside the dotfiles repo
+	Dest string      // Corresponding path on the system
+	Mode os.FileMode // Enforced file mode; 0 keeps the source file's mode
+}
+
+// SyncDotfiles physically copies dotfiles from the repo onto the system
+// (Master-Replica pattern, ADR-0007). Files are compared by SHA-256 and
+// written with the real user's ownership, so no root-owned files end up in $HOME.
+//
+// Mode and owner come from the repo's state store when a record exists,
+// then from the item's default mode, then from the source file.
+type SyncDotfiles struct {
+	BaseDir string // Dotfiles repo root, holds the state store
+	Items   []SyncItem
+	UID     int // Fallback owner when the state store has no record
+	GID     int
+}
+
+func (a *SyncDotfiles) Name() string { return "SyncDotfiles" }
+func (a *SyncDotfiles) Block() Block { return BlockUserSpace }
+
+// syncFile is a single regular file resolved from a SyncItem.
+type syncFile struct {
+	item string // Dest of the SyncItem the file belongs to
+	src  string
+	dest string
+	mode os.FileMode
+	uid  int
+	gid  int
+}
+
+// Check compares content hash, mode and ownership of every managed file.
+// An item whose source is not in the repo yet (on a fresh machine, before
+// the dotfiles are restored) is pending as a whole.
+func (a *SyncDotfiles) Check(r utils.Runner) ([]Diff, error) {
+	files, missing, err := a.files()
+	if err != nil {
+		return nil, err
+	}
+
+	syncLog.Infof("Checking %d synced files...", len(files))
+
+	diffs := make([]Diff, 0, len(files)+len(missing))
+	for _, item := range missing {
+		diffs = append(diffs, pending(item.Dest, fmt.Sprintf("file %s", item.Dest), "would sync (source not yet present)"))
+	}
+	for _, f := range files {
+		item := fmt.Sprintf("file %s", f.dest)
+		reasons, err := a.drift(f)
+		if err != nil {
+			return nil, err
+		}
+		if len(reasons) == 0 {
+			diffs = append(diffs, satisfied(f.dest, item, "in sync"))
+		} else {
+			diffs = append(diffs, pending(f.dest, item, fmt.Sprintf("would sync (%s)", strings.Join(reasons, ", "))))
+		}
+	}
+	return diffs, nil
+}
+
+// Apply copies changed files and enforces mode and ownership. Every file
+// of an item that was pending as a whole is synced.
+func (a *SyncDotfiles) Apply(r utils.Runner, pending []Diff) error {
+	files, missing, err := a.files()
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fail(CategoryConfig, "Check userspace.sync.items against the dotfiles repository",
+			fmt.Errorf("sync source %s does not exist", missing[0].Src))
+	}
+
+	todo := make(map[string]bool, len(pending))
+	for _, d := range pending {
+		todo[d.Key] = true
+	}
+	whole := make(map[string]bool)
+	for _, item := range a.Items {
+		whole[item.Dest] = todo[item.Dest]
+	}
+
+	for _, f := range files {
+		if !todo[f.dest] && !whole[f.item] {
+			continue
+		}
+		if err := a.syncFile(f); err != nil {
+			return fail(CategoryPermission, "Check ownership of the destination directories in $HOME",
+				fmt.Errorf("failed to sync %s: %w", f.dest, err))
+		}
+	}
+
+	syncLog.Infof("Synced %d files", len(pending))
+	return nil
+}
+
+// files expands every SyncItem into regular files, walking directories recursively,
+// and applies the state store records on top of the blueprint defaults.
+// Items whose source does not exist are returned as missing; a source that
+// exists but cannot be read is an error.
+func (a *SyncDotfiles) files() (files []syncFile, missing []SyncItem, err error) {
+	for _, item := range a.Items {
+		info, err := os.Stat(item.Src)
+		if os.IsNotExist(err) {
+			missing = append(missing, item)
+			continue
+		}
+		if err != nil {
+			return nil, nil, fail(CategoryConfig, "Check userspace.sync.items against the dotfiles repository",
+				fmt.Errorf("sync source %s: %w", item.Src, err))
+		}
+
+		if !info.IsDir() {
+			files = append(files, a.newSyncFile(item, item.Src, item.Dest, modeOr(item.Mode, info.Mode())))
+			continue
+		}
+
+		err = filepath.WalkDir(item.Src, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			if !d.Type().IsRegular() {
+				syncLog.Warnf("Skipping non-regular file: %s", path)
+				return nil
+			}
+
+			rel, err := filepath.Rel(item.Src, path)
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			files = append(files, a.newSyncFile(item, path, filepath.Join(item.Dest, rel), modeOr(item.Mode, info.Mode())))
+			return nil
+		})
+		if err != nil {
+			return nil, nil, fail(CategoryConfig, "Check the permissions of the dotfiles repository",
+				fmt.Errorf("failed to walk %s: %w", item.Src, err))
+		}
+	}
+
+	if err := a.applyState(files); err != nil {
+		return nil, nil, err
+	}
+	return files, missing, nil
+}
+
+// newSyncFile builds a syncFile of item owned by the fallback owner.
+func (a *SyncDotfiles) newSyncFile(item SyncItem, src, dest string, mode os.FileMode) syncFile {
+	return syncFile{item: item.Dest, src: src, dest: dest, mode: mode, uid: a.UID, gid: a.GID}
+}
+
+// applyState overrides mode and owner with the records from the state store.
+// It is loaded on every call because ExtractTarball may create the repo
+// earlier in the same Block.
+func (a *SyncDotfiles) applyState(files []syncFile) error {
+	store, err := statestore.Load(filepath.Join(a.BaseDir, statestore.FileName))
+	if err != nil {
+		return fail(CategoryConfig, "Fix or remove the state store file in the dotfiles repo", err)
+	}
+
+	for i := range files {
+		rel, err := filepath.Rel(a.BaseDir, files[i].src)
+		if err != nil {
+			continue
+		}
+		rec, ok := store.Get(filepath.ToSlash(rel))
+		if !ok {
+			continue
+		}
+
+		if mode, err := rec.FileMode(); err == nil {
+			files[i].mode = mode
+		}
+		if rec.Owner != "" {
+			uid, gid, err := utils.LookupOwner(rec.Owner)
+			if err != nil {
+				syncLog.Warnf("Ignoring recorded owner of %s: %v", rec.Path, err)
+				continue
+			}
+			files[i].uid, files[i].gid = uid, gid
+		}
+	}
+	return nil
+}
+
+// drift lists why dest does not match src (empty if in sync).
+// A symlink at dest is drift in itself: it is never followed, so that
+// neither the hash nor the mode of whatever it points to is taken for dest's.
+func (a *SyncDotfiles) drift(f syncFile) ([]string, error) {
+	info, err := os.Lstat(f.dest)
+	if os.IsNotExist(err) {
+		return []string{"missing"}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return []string{"is a symlink"}, nil
+	}
+	if !info.Mode().IsRegular() {
+		return []string{"not a regular file"}, nil
+	}
+
+	var reasons []string
+
+	srcHash, err := utils.FileSHA256(f.src)
+	if err != nil {
+		return nil, err
+	}
+	destHash, err := utils.FileSHA256(f.dest)
+	if err != nil {
+		return nil, err
+	}
+	if srcHash != destHash {
+		reasons = append(reasons, "content differs")
+	}
+
+	if info.Mode().Perm() != f.mode {
+		reasons = append(reasons, fmt.Sprintf("mode %04o != %04o", info.Mode().Perm(), f.mode))
+	}
+
+	if uid, gid, ok := fileOwner(info); ok && (uid != f.uid || gid != f.gid) {
+		reasons = append(reasons, fmt.Sprintf("owner %d:%d != %d:%d", uid, gid, f.uid, f.gid))
+	}
+
+	return reasons, nil
+}
+
+// syncFile copies f (only if content differs) and enforces mode and ownership.
+// Root writes into the user's home here, so dest's directory is opened
+// without following a symlink the user could have planted (see
+// openUserDir), and every write goes through that directory. Anything but
+// a regular file at dest (a symlink in particular) is replaced rather than
+// written through.
+func (a *SyncDotfiles) syncFile(f syncFile) error {
+	dir, err := openUserDir(filepath.Dir(f.dest), dirMode(f.mode), f.uid, f.gid)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	name := filepath.Base(f.dest)
+
+	srcHash, err := utils.FileSHA256(f.src)
+	if err != nil {
+		return err
+	}
+	replace := true
+	if dest, err := openRegularAt(dir, name); err == nil {
+		destHash, err := utils.ReaderSHA256(dest, f.dest)
+		dest.Close()
+		replace = err != nil || destHash != srcHash
+	}
+	if replace {
+		syncLog.Infof("Copying %s -> %s", f.src, f.dest)
+		if err := copyFileAt(f.src, dir, name, f.mode); err != nil {
+			return err
+		}
+	}
+
+	dest, err := openRegularAt(dir, name)
+	if err != nil {
+		return err
+	}
+	defer dest.Close()
+	if err := dest.Chmod(f.mode); err != nil {
+		return err
+	}
+	return dest.Chown(f.uid, f.gid)
+}
+
+// copyFile atomically replaces dest with the content of src
+// (temp file in the same directory + rename), with permissions perm.
+func copyFile(src, dest string, perm os.FileMode) error {
+	dir, err := os.Open(filepath.Dir(dest))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return copyFileAt(src, dir, filepath.Base(dest), perm)
+}
+
+// copyFileAt atomically replaces name in dir with the content of src
+// (temp file in dir + rename). The temp file is created with mode 0600
+// and gets perm before it is renamed into place.
+func copyFileAt(src string, dir *os.File, name string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	dirfd := int(dir.Fd())
+	var tmpName string
+	var fd int
+	for range 100 {
+		tmpName = fmt.Sprintf(".phoenix-sync-%d", rand.Uint32())
+		fd, err = unix.Openat(dirfd, tmpName, unix.O_WRONLY|unix.O_CREAT|unix.O_EXCL|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0600)
+		if err != unix.EEXIST {
+			break
+		}
+	}
+	if err != nil {
+		return &os.PathError{Op: "create", Path: filepath.Join(dir.Name(), tmpName), Err: err}
+	}
+	tmp := os.NewFile(uintptr(fd), filepath.Join(dir.Name(), tmpName))
+	renamed := false
+	defer func() {
+		if !renamed {
+			unix.Unlinkat(dirfd, tmpName, 0)
+		}
+	}()
+
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := unix.Renameat(dirfd, tmpName, dirfd, name); err != nil {
+		return &os.LinkError{Op: "rename", Old: tmp.Name(), New: filepath.Join(dir.Name(), name), Err: err}
+	}
+	renamed = true
+	return nil
+}
+
+// openRegularAt opens name in dir for reading without following a symlink,
+// and fails unless it is a regular file.
+func openRegularAt(dir *os.File, name string) (*os.File, error) {
+	path := filepath.Join(dir.Name(), name)
+	fd, err := unix.Openat(int(dir.Fd()), name, unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	file := os.NewFile(uintptr(fd), path)
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return nil, fmt.Errorf("%s is not a regular file", path)
+	}
+	return file, nil
+}
+
+// ensureUserDir creates dir and any missing parents owned by uid:gid.
+// Existing directories are left untouched.
+func ensureUserDir(dir string, mode os.FileMode, uid, gid int) error {
+	d, err := openUserDir(dir, mode, uid, gid)
+	if err != nil {
+		return err
+	}
+	return d.Close()
+}
+
+// openUserDir opens dir, creating missing directories owned by uid:gid.
+// It resolves dir one component at a time: once the walk enters a
+// directory the user controls (owned by uid, or writable by group or
+// others), no further component may be a symlink, so that root cannot be
+// sent elsewhere by a link the user planted in $HOME. Symlinks above that
+// point, such as /home -> /var/home, are followed.
+func openUserDir(dir string, mode os.FileMode, uid, gid int) (*os.File, error) {
+	dir = filepath.Clean(dir)
+	if !filepath.IsAbs(dir) {
+		return nil, fmt.Errorf("%s is not an absolute path", dir)
+	}
+	fd, err := unix.Open("/", unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: "/", Err: err}
+	}
+
+	path := "/"
+	userControlled := false
+	for _, name := range strings.Split(dir, "/") {
+		if name == "" {
+			continue
+		}
+		var st unix.Stat_t
+		if err := unix.Fstat(fd, &st); err != nil {
+			unix.Close(fd)
+			return nil, &os.PathError{Op: "stat", Path: path, Err: err}
+		}
+		userControlled = userControlled || int(st.Uid) == uid || st.Mode&0022 != 0
+		path = filepath.Join(path, name)
+
+		flags := unix.O_RDONLY | unix.O_DIRECTORY | unix.O_CLOEXEC
+		if userControlled {
+			flags |= unix.O_NOFOLLOW
+		}
+		next, err := unix.Openat(fd, name, flags, 0)
+		if err == unix.ENOENT {
+			err = unix.Mkdirat(fd, name, uint32(mode.Perm()))
+			if err == nil {
+				err = unix.Fchownat(fd, name, uid, gid, unix.AT_SYMLINK_NOFOLLOW)
+			}
+			if err == nil || err == unix.EEXIST {
+				next, err = unix.Openat(fd, name, flags, 0)
+			}
+		}
+		if err != nil && userControlled {
+			var lst unix.Stat_t
+			if unix.Fstatat(fd, name, &lst, unix.AT_SYMLINK_NOFOLLOW) == nil && lst.Mode&unix.S_IFMT == unix.S_IFLNK {
+				err = fmt.Errorf("refusing to follow a symlink in a user-writable directory")
+			}
+		}
+		unix.Close(fd)
+		if err != nil {
+			return nil, &os.PathError{Op: "open", Path: path, Err: err}
+		}
+		fd = next
+	}
+	return os.NewFile(uintptr(fd), dir), nil
+}
+
+// modeOr returns mode if set, otherwise the permission bits of fallback.
+func modeOr(mode, fallback os.FileMode) os.FileMode {
+	if mode != 0 {
+		return mode
+	}
+	return fallback.Perm()
+}
+
+// dirMode derives a directory mode from a file mode by adding the
+// execute bit wherever read is granted (0600 -> 0700, 0644 -> 0755).
+func dirMode(mode os.FileMode) os.FileMode {
+	return mode | (mode&0444)>>2
+}
+
+// fileOwner extracts the UID and GID from file stat info.
+func fileOwner(info os.FileInfo) (uid, gid int, ok bool) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(st.Uid), int(st.Gid), true
+	}
+	return 0, 0, false
+}
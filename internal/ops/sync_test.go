@@ -0,0 +1,203 @@
+package ops
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// newSyncFixture creates a dotfiles repo with a zshrc and an ssh directory,
+// and a SyncDotfiles that syncs both into an empty home directory.
+func newSyncFixture(t *testing.T) (a *SyncDotfiles, repo, home string) {
+	t.Helper()
+	repo = t.TempDir()
+	home = t.TempDir()
+	if err := os.MkdirAll(filepath.Join(repo, "ssh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"zshrc": "export EDITOR=vim\n", "ssh/config": "Host *\n"} {
+		if err := os.WriteFile(filepath.Join(repo, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a = &SyncDotfiles{
+		BaseDir: repo,
+		Items: []SyncItem{
+			{Src: filepath.Join(repo, "zshrc"), Dest: filepath.Join(home, ".zshrc")},
+			{Src: filepath.Join(repo, "ssh"), Dest: filepath.Join(home, ".ssh"), Mode: 0600},
+		},
+		UID: os.Getuid(),
+		GID: os.Getgid(),
+	}
+	return a, repo, home
+}
+
+// syncOnce runs Check and applies what it reports as pending, returning
+// the keys of the pending Diffs.
+func syncOnce(t *testing.T, a *SyncDotfiles) []string {
+	t.Helper()
+	diffs, err := a.Check(nil)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	pending := pendingDiffs(diffs)
+	if len(pending) > 0 {
+		if err := a.Apply(nil, pending); err != nil {
+			t.Fatalf("Apply() error = %v", err)
+		}
+	}
+	return diffKeys(pending)
+}
+
+func TestSyncDotfilesCopiesMissingFiles(t *testing.T) {
+	a, _, home := newSyncFixture(t)
+	zshrc := filepath.Join(home, ".zshrc")
+	sshConfig := filepath.Join(home, ".ssh", "config")
+
+	if got, want := syncOnce(t, a), []string{zshrc, sshConfig}; !slices.Equal(got, want) {
+		t.Errorf("pending = %q, want %q", got, want)
+	}
+	if content, err := os.ReadFile(zshrc); err != nil || string(content) != "export EDITOR=vim\n" {
+		t.Errorf("%s = %q (%v), want the repo content", zshrc, content, err)
+	}
+	if info, err := os.Stat(zshrc); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("%s mode = %v (%v), want the source's 0644", zshrc, info.Mode().Perm(), err)
+	}
+	if info, err := os.Stat(sshConfig); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("%s mode = %v (%v), want 0600", sshConfig, info.Mode().Perm(), err)
+	}
+	if info, err := os.Stat(filepath.Dir(sshConfig)); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("%s mode = %v (%v), want 0700", filepath.Dir(sshConfig), info.Mode().Perm(), err)
+	}
+
+	if got := syncOnce(t, a); got != nil {
+		t.Errorf("pending after sync = %q, want none", got)
+	}
+}
+
+func TestSyncDotfilesRestoresDrift(t *testing.T) {
+	a, _, home := newSyncFixture(t)
+	zshrc := filepath.Join(home, ".zshrc")
+	sshConfig := filepath.Join(home, ".ssh", "config")
+	syncOnce(t, a)
+
+	if err := os.WriteFile(zshrc, []byte("export EDITOR=nano\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(sshConfig, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := syncOnce(t, a), []string{zshrc, sshConfig}; !slices.Equal(got, want) {
+		t.Errorf("pending = %q, want %q", got, want)
+	}
+	if content, err := os.ReadFile(zshrc); err != nil || string(content) != "export EDITOR=vim\n" {
+		t.Errorf("%s = %q (%v), want the repo content", zshrc, content, err)
+	}
+	if info, err := os.Stat(sshConfig); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("%s mode = %v (%v), want 0600", sshConfig, info.Mode().Perm(), err)
+	}
+}
+
+func TestSyncDotfilesReplacesSymlink(t *testing.T) {
+	a, _, home := newSyncFixture(t)
+	zshrc := filepath.Join(home, ".zshrc")
+	syncOnce(t, a)
+
+	// Same content as the repo's zshrc, so only the symlink itself is drift
+	outside := filepath.Join(t.TempDir(), "zshrc")
+	if err := os.WriteFile(outside, []byte("export EDITOR=vim\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(zshrc); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, zshrc); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := syncOnce(t, a), []string{zshrc}; !slices.Equal(got, want) {
+		t.Errorf("pending = %q, want %q", got, want)
+	}
+	if info, err := os.Lstat(zshrc); err != nil || !info.Mode().IsRegular() || info.Mode().Perm() != 0644 {
+		t.Errorf("%s is not a regular 0644 file: %v (%v)", zshrc, info.Mode(), err)
+	}
+	if info, err := os.Stat(outside); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("symlink target mode = %v (%v), want 0640 kept", info.Mode().Perm(), err)
+	}
+}
+
+func TestSyncDotfilesSourceNotYetPresent(t *testing.T) {
+	a, repo, home := newSyncFixture(t)
+	src := filepath.Join(repo, "config", "nvim")
+	dest := filepath.Join(home, ".config", "nvim")
+	a.Items = []SyncItem{{Src: src, Dest: dest}}
+
+	// Checked before the dotfiles are restored, e.g. by a dry run
+	diffs, err := a.Check(nil)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if got := diffKeys(pendingDiffs(diffs)); !slices.Equal(got, []string{dest}) {
+		t.Errorf("pending = %q, want %q", got, dest)
+	}
+	checkCategory(t, a.Apply(nil, pendingDiffs(diffs)), CategoryConfig)
+
+	// Restored between Check and Apply: every file of the item is synced
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "init.lua"), []byte("vim.o.number = true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Apply(nil, pendingDiffs(diffs)); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "init.lua")); err != nil {
+		t.Errorf("item not synced: %v", err)
+	}
+}
+
+func TestSyncDotfilesUnreadableSource(t *testing.T) {
+	a, repo, home := newSyncFixture(t)
+	// A path through a regular file exists as far as it goes, but cannot be read
+	a.Items = []SyncItem{{Src: filepath.Join(repo, "zshrc", "vimrc"), Dest: filepath.Join(home, ".vimrc")}}
+
+	_, err := a.Check(nil)
+	checkCategory(t, err, CategoryConfig)
+}
+
+func TestSyncDotfilesRefusesSymlinkedParent(t *testing.T) {
+	// The home directory belongs to another user, as it does for provision
+	const uid, gid = 65534, 65534
+	a, _, home := newSyncFixture(t)
+	if err := os.Chown(home, uid, gid); err != nil {
+		t.Skipf("needs root to hand the home directory to another user: %v", err)
+	}
+	a.UID, a.GID = uid, gid
+
+	// The user points ~/.ssh at a directory only root may write to
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(home, ".ssh")); err != nil {
+		t.Fatal(err)
+	}
+
+	diffs, err := a.Check(nil)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	checkCategory(t, a.Apply(nil, pendingDiffs(diffs)), CategoryPermission)
+
+	if entries, err := os.ReadDir(outside); err != nil || len(entries) != 0 {
+		t.Errorf("root wrote through the symlink: %v (%v)", entries, err)
+	}
+	// Files before the symlinked one are synced, as the user
+	info, err := os.Stat(filepath.Join(home, ".zshrc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner, _, _ := fileOwner(info); owner != uid {
+		t.Errorf(".zshrc owned by %d, want %d", owner, uid)
+	}
+}
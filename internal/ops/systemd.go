@@ -2,43 +2,59 @@ package ops
 
 import (
 	"fmt"
-	"os/exec"
 
 	"github.com/acker1019/fedora-phoenix/internal/logging"
+	"github.com/acker1019/fedora-phoenix/internal/utils"
 )
 
 var systemdLog = logging.WithSource("ops/systemd")
 
 // EnsureServices enables and starts systemd services.
 // Follows Check-Diff-Act pattern for idempotency.
-func EnsureServices(services []string) error {
-	if len(services) == 0 {
-		return nil
+type EnsureServices struct {
+	Services []string
+}
+
+func (a *EnsureServices) Name() string { return "EnsureServices" }
+func (a *EnsureServices) Block() Block { return BlockSystem }
+
+// Check reports which services are already enabled and running.
+func (a *EnsureServices) Check(r utils.Runner) ([]Diff, error) {
+	if len(a.Services) == 0 {
+		return nil, nil
 	}
 
-	systemdLog.Infof("Processing %d systemd services...", len(services))
+	systemdLog.Infof("Processing %d systemd services...", len(a.Services))
 
-	for _, svc := range services {
+	diffs := make([]Diff, 0, len(a.Services))
+	for _, svc := range a.Services {
 		systemdLog.Infof("Checking service: %s", svc)
+		item := fmt.Sprintf("service %s", svc)
 
 		// Check: Is service already enabled and active?
-		isEnabledCmd := exec.Command("systemctl", "is-enabled", svc)
-		isEnabled := isEnabledCmd.Run() == nil
-
-		isActiveCmd := exec.Command("systemctl", "is-active", svc)
-		isActive := isActiveCmd.Run() == nil
+		isEnabled, isActive := serviceState(r, svc)
 
 		// Diff: If both enabled and active, skip
 		if isEnabled && isActive {
 			systemdLog.Infof("Service %s already enabled and running. Skipping.", svc)
-			continue
+			diffs = append(diffs, satisfied(svc, item, "already enabled and running"))
+		} else {
+			diffs = append(diffs, pending(svc, item, "would enable and start"))
 		}
+	}
+	return diffs, nil
+}
+
+// Apply enables and starts every pending service.
+func (a *EnsureServices) Apply(r utils.Runner, pending []Diff) error {
+	for _, d := range pending {
+		svc := d.Key
 
 		// Act: Enable and start service
 		systemdLog.Infof("Enabling and starting service: %s", svc)
-		cmd := exec.Command("systemctl", "enable", "--now", svc)
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("failed to enable service %s: %w", svc, err)
+		if err := r.Run("systemctl", "enable", "--now", svc); err != nil {
+			return fail(CategoryService, fmt.Sprintf("Inspect the unit with `systemctl status %s` and `journalctl -u %s`", svc, svc),
+				fmt.Errorf("failed to enable service %s: %w", svc, err))
 		}
 
 		systemdLog.Infof("Service %s enabled and started", svc)
@@ -47,3 +63,10 @@ func EnsureServices(services []string) error {
 	systemdLog.Info("All services verified")
 	return nil
 }
+
+// serviceState queries systemctl for the enabled and active state of a unit.
+func serviceState(r utils.Runner, svc string) (isEnabled, isActive bool) {
+	_, enabledErr := r.Output("systemctl", "is-enabled", svc)
+	_, activeErr := r.Output("systemctl", "is-active", svc)
+	return enabledErr == nil, activeErr == nil
+}
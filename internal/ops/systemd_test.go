@@ -0,0 +1,44 @@
+package ops
+
+import (
+	"testing"
+
+	"github.com/acker1019/fedora-phoenix/internal/utils/utilstest"
+)
+
+func TestEnsureServices(t *testing.T) {
+	runActCases(t, []actCase{
+		{
+			name: "enabled and running",
+			act:  constAct(&EnsureServices{Services: []string{"sshd"}}),
+			script: func(r *utilstest.FakeRunner) {
+				r.Expect("systemctl is-enabled sshd")
+				r.Expect("systemctl is-active sshd")
+			},
+		},
+		{
+			name: "stopped or disabled services are enabled and started",
+			act:  constAct(&EnsureServices{Services: []string{"sshd", "docker"}}),
+			script: func(r *utilstest.FakeRunner) {
+				r.Expect("systemctl is-enabled sshd")
+				r.Expect("systemctl is-active sshd").Exit(3)
+				r.Expect("systemctl is-enabled docker").Exit(1)
+				r.Expect("systemctl is-active docker").Exit(3)
+				r.Expect("systemctl enable --now sshd")
+				r.Expect("systemctl enable --now docker")
+			},
+			pending: []string{"sshd", "docker"},
+		},
+		{
+			name: "unit fails to start",
+			act:  constAct(&EnsureServices{Services: []string{"docker"}}),
+			script: func(r *utilstest.FakeRunner) {
+				r.Expect("systemctl is-enabled docker").Exit(1)
+				r.Expect("systemctl is-active docker").Exit(3)
+				r.Expect("systemctl enable --now docker").Exit(1).Stderr("Job for docker.service failed")
+			},
+			fail: CategoryService,
+			exit: 1,
+		},
+	})
+}
@@ -4,10 +4,11 @@ import (
 	"bufio"
 	"fmt"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"github.com/acker1019/fedora-phoenix/internal/logging"
+	"github.com/acker1019/fedora-phoenix/internal/secret"
 	"github.com/acker1019/fedora-phoenix/internal/utils"
 )
 
@@ -15,67 +16,104 @@ var userLog = logging.WithSource("ops/user")
 
 // EnsureUserShell changes the user's default shell if it doesn't match.
 // Idempotent: checks /etc/passwd before executing usermod.
-func EnsureUserShell(username, targetShell string) error {
-	userLog.Infof("Checking shell for user: %s", username)
+type EnsureUserShell struct {
+	Username string
+	Shell    string
+}
+
+func (a *EnsureUserShell) Name() string { return "EnsureUserShell" }
+func (a *EnsureUserShell) Block() Block { return BlockSystem }
+
+// Check reports whether the user's login shell already matches.
+func (a *EnsureUserShell) Check(r utils.Runner) ([]Diff, error) {
+	userLog.Infof("Checking shell for user: %s", a.Username)
+	item := fmt.Sprintf("shell for %s", a.Username)
 
 	// Read /etc/passwd to get current shell
+	currentShell, err := currentShell(a.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if shell already matches
+	if currentShell == a.Shell {
+		userLog.Infof("User %s already has shell %s. Skipping.", a.Username, a.Shell)
+		return []Diff{satisfied(a.Username, item, fmt.Sprintf("already %s", a.Shell))}, nil
+	}
+	return []Diff{pending(a.Username, item, fmt.Sprintf("would change %s -> %s", currentShell, a.Shell))}, nil
+}
+
+// Apply executes usermod to switch the login shell.
+func (a *EnsureUserShell) Apply(r utils.Runner, pending []Diff) error {
+	userLog.Infof("Changing shell for %s -> %s", a.Username, a.Shell)
+
+	// Execute usermod
+	if err := r.Run("usermod", "-s", a.Shell, a.Username); err != nil {
+		return fail(CategoryConfig, "Check that identity.shell is installed and listed in /etc/shells",
+			fmt.Errorf("failed to change shell for %s: %w", a.Username, err))
+	}
+
+	userLog.Infof("Shell changed successfully for user %s", a.Username)
+	return nil
+}
+
+// currentShell reads /etc/passwd to get the user's login shell.
+func currentShell(username string) (string, error) {
 	file, err := os.Open("/etc/passwd")
 	if err != nil {
-		return fmt.Errorf("failed to open /etc/passwd: %w", err)
+		return "", fmt.Errorf("failed to open /etc/passwd: %w", err)
 	}
 	defer file.Close()
 
-	var currentShell string
+	var shell string
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		line := scanner.Text()
 		if strings.HasPrefix(line, username+":") {
 			fields := strings.Split(line, ":")
 			if len(fields) >= 7 {
-				currentShell = fields[6]
+				shell = fields[6]
 				break
 			}
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("failed to read /etc/passwd: %w", err)
+		return "", fmt.Errorf("failed to read /etc/passwd: %w", err)
 	}
 
-	// Check if shell already matches
-	if currentShell == targetShell {
-		userLog.Infof("User %s already has shell %s. Skipping.", username, targetShell)
-		return nil
-	}
-
-	userLog.Infof("Changing shell for %s: %s -> %s", username, currentShell, targetShell)
-
-	// Execute usermod
-	cmd := exec.Command("usermod", "-s", targetShell, username)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("failed to change shell for %s: %w", username, err)
-	}
-
-	userLog.Infof("Shell changed successfully for user %s", username)
-	return nil
+	return shell, nil
 }
 
-// EnsureSymlink creates a symlink from src to dest as the specified user.
+// EnsureSymlink creates a symlink from Src to Dest as the specified user.
 // Idempotent: checks if symlink already exists and points to correct target.
-func EnsureSymlink(src, dest, username string) error {
-	userLog.Infof("Ensuring symlink: %s -> %s (as %s)", dest, src, username)
+type EnsureSymlink struct {
+	Src      string
+	Dest     string
+	Username string
+}
+
+func (a *EnsureSymlink) Name() string { return "EnsureSymlink" }
+func (a *EnsureSymlink) Block() Block { return BlockUserSpace }
+
+// Check reports whether Dest is already a symlink to Src.
+func (a *EnsureSymlink) Check(r utils.Runner) ([]Diff, error) {
+	userLog.Infof("Ensuring symlink: %s -> %s (as %s)", a.Dest, a.Src, a.Username)
+	item := fmt.Sprintf("symlink %s", a.Dest)
 
 	// Check if dest exists and is correct
-	if target, err := os.Readlink(dest); err == nil {
-		if target == src {
-			userLog.Infof("Symlink already correct. Skipping.")
-			return nil
-		}
+	if target, err := os.Readlink(a.Dest); err == nil && target == a.Src {
+		userLog.Infof("Symlink already correct. Skipping.")
+		return []Diff{satisfied(a.Dest, item, fmt.Sprintf("already -> %s", a.Src))}, nil
 	}
+	return []Diff{pending(a.Dest, item, fmt.Sprintf("would link -> %s", a.Src))}, nil
+}
 
-	// Create/update symlink using RunCommandAsUser
-	if err := utils.RunCommandAsUser(username, "ln", "-sfn", src, dest); err != nil {
-		return fmt.Errorf("failed to create symlink: %w", err)
+// Apply creates or updates the symlink as the user.
+func (a *EnsureSymlink) Apply(r utils.Runner, pending []Diff) error {
+	if err := r.RunAsUser(a.Username, "ln", "-sfn", a.Src, a.Dest); err != nil {
+		return fail(CategoryPermission, "Check that the link's parent directory is owned by the user",
+			fmt.Errorf("failed to create symlink: %w", err))
 	}
 
 	userLog.Info("Symlink created successfully")
@@ -84,48 +122,94 @@ func EnsureSymlink(src, dest, username string) error {
 
 // ExtractTarball extracts a tarball to the destination directory as the specified user.
 // Follows Check-Diff-Act pattern: checks if destination is non-empty before extracting.
-func ExtractTarball(archivePath, destDir, username string) error {
-	userLog.Infof("Checking tarball extraction: %s -> %s (as %s)", archivePath, destDir, username)
+type ExtractTarball struct {
+	Archive  string
+	DestDir  string
+	Username string
+}
 
-	// Ensure destination directory exists
-	if err := utils.RunCommandAsUser(username, "mkdir", "-p", destDir); err != nil {
-		return fmt.Errorf("failed to create destination directory: %w", err)
-	}
+func (a *ExtractTarball) Name() string { return "ExtractTarball" }
+func (a *ExtractTarball) Block() Block { return BlockUserSpace }
+
+// Check reports whether the tarball would be extracted into DestDir.
+// It does not create the destination directory; Apply does.
+func (a *ExtractTarball) Check(r utils.Runner) ([]Diff, error) {
+	userLog.Infof("Checking tarball extraction: %s -> %s (as %s)", a.Archive, a.DestDir, a.Username)
+	item := fmt.Sprintf("archive %s", a.Archive)
 
 	// Check: Is destination directory non-empty?
-	entries, err := os.ReadDir(destDir)
-	if err == nil && len(entries) > 0 {
-		userLog.Infof("Destination %s is non-empty (contains %d items). Skipping extraction.", destDir, len(entries))
-		return nil
+	if n := countEntries(a.DestDir); n > 0 {
+		userLog.Infof("Destination %s is non-empty (contains %d items). Skipping extraction.", a.DestDir, n)
+		return []Diff{satisfied(a.Archive, item, fmt.Sprintf("%s is non-empty (%d items)", a.DestDir, n))}, nil
+	}
+	return []Diff{pending(a.Archive, item, fmt.Sprintf("would extract to %s", a.DestDir))}, nil
+}
+
+// Apply creates the destination directory and extracts the tarball into it.
+func (a *ExtractTarball) Apply(r utils.Runner, pending []Diff) error {
+	// Ensure destination directory exists
+	if err := r.RunAsUser(a.Username, "mkdir", "-p", a.DestDir); err != nil {
+		return fail(CategoryPermission, "Check that the destination's parent directory is owned by the user",
+			fmt.Errorf("failed to create destination directory: %w", err))
 	}
 
 	// Act: Extract tarball
-	userLog.Infof("Extracting %s to %s", archivePath, destDir)
-	if err := utils.RunCommandAsUser(username, "tar", "-xzf", archivePath, "-C", destDir); err != nil {
-		return fmt.Errorf("failed to extract tarball: %w", err)
+	userLog.Infof("Extracting %s to %s", a.Archive, a.DestDir)
+	if err := r.RunAsUser(a.Username, "tar", "-xzf", a.Archive, "-C", a.DestDir); err != nil {
+		return fail(CategoryCommand, "Check that --dotfiles-archive is a readable .tgz file",
+			fmt.Errorf("failed to extract tarball: %w", err))
 	}
 
 	userLog.Info("Tarball extracted successfully")
 	return nil
 }
 
+// countEntries returns the number of entries in dir, or 0 if it cannot be read.
+func countEntries(dir string) int {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+	return len(entries)
+}
+
 // RunStow deploys dotfiles using GNU Stow as the specified user.
 // Idempotent: stow -R (restow) is inherently idempotent - it will recreate
 // correct symlinks even if they already exist, and fix broken ones.
-func RunStow(sourceDir, targetDir string, packages []string, username string) error {
-	if len(packages) == 0 {
-		return nil
+type RunStow struct {
+	SourceDir string
+	TargetDir string
+	Packages  []string
+	Username  string
+}
+
+func (a *RunStow) Name() string { return "RunStow" }
+func (a *RunStow) Block() Block { return BlockUserSpace }
+
+// Check reports the Stow packages that would be deployed.
+// Restow (-R) is always applied, so every package is reported as a pending change.
+func (a *RunStow) Check(r utils.Runner) ([]Diff, error) {
+	diffs := make([]Diff, 0, len(a.Packages))
+	for _, pkg := range a.Packages {
+		item := fmt.Sprintf("stow %s", pkg)
+		diffs = append(diffs, pending(pkg, item, fmt.Sprintf("would restow %s -> %s", filepath.Join(a.SourceDir, pkg), a.TargetDir)))
 	}
+	return diffs, nil
+}
 
-	userLog.Infof("Running Stow to deploy %d packages...", len(packages))
+// Apply runs stow -R for every package.
+func (a *RunStow) Apply(r utils.Runner, pending []Diff) error {
+	userLog.Infof("Running Stow to deploy %d packages...", len(pending))
 
-	for _, pkg := range packages {
+	for _, d := range pending {
+		pkg := d.Key
 		userLog.Infof("Deploying package: %s", pkg)
 
 		// Act: Execute stow -R (restow)
 		// The -R flag ensures idempotency by recreating all symlinks
-		if err := utils.RunCommandAsUser(username, "stow", "-d", sourceDir, "-t", targetDir, "-R", pkg); err != nil {
-			return fmt.Errorf("failed to deploy package %s: %w", pkg, err)
+		if err := r.RunAsUser(a.Username, "stow", "-d", a.SourceDir, "-t", a.TargetDir, "-R", pkg); err != nil {
+			return fail(CategoryCommand, "Remove conflicting files in the target directory, then rerun",
+				fmt.Errorf("failed to deploy package %s: %w", pkg, err))
 		}
 	}
 
@@ -135,20 +219,100 @@ func RunStow(sourceDir, targetDir string, packages []string, username string) er
 
 // GitClone clones a git repository to the destination as the specified user.
 // Idempotent: checks if destination already exists.
-func GitClone(url, dest, username string) error {
-	userLog.Infof("Cloning %s to %s (as %s)", url, dest, username)
+//
+// URL never carries credentials. A Token is handed to a credential-cache
+// daemon of the user over stdin, used by the clone and dropped right after,
+// so it shows up neither in argv, nor in .git/config, nor in the logs.
+type GitClone struct {
+	URL       string
+	Dest      string
+	Username  string
+	TokenUser string        // HTTPS user name sent with Token (e.g. "oauth2")
+	Token     *secret.Value // HTTPS password or access token; nil clones without one
+}
+
+// How long the credential-cache daemon keeps the token if it is never told to exit
+const gitCredentialTimeout = "300"
+
+func (a *GitClone) Name() string { return "GitClone" }
+func (a *GitClone) Block() Block { return BlockUserSpace }
+
+// Check reports whether the repository would be cloned.
+func (a *GitClone) Check(r utils.Runner) ([]Diff, error) {
+	userLog.Infof("Cloning %s to %s (as %s)", a.URL, a.Dest, a.Username)
+	item := fmt.Sprintf("repo %s", a.Dest)
 
 	// Check if destination exists
-	if _, err := os.Stat(dest); err == nil {
-		userLog.Infof("Destination %s already exists. Skipping clone.", dest)
-		return nil
+	if _, err := os.Stat(a.Dest); err == nil {
+		userLog.Infof("Destination %s already exists. Skipping clone.", a.Dest)
+		return []Diff{satisfied(a.Dest, item, "already exists")}, nil
+	}
+	detail := fmt.Sprintf("would clone %s", a.URL)
+	if !a.Token.Empty() {
+		detail += fmt.Sprintf(" with the token of %s", a.TokenUser)
+	}
+	return []Diff{pending(a.Dest, item, detail)}, nil
+}
+
+// Apply clones the repository.
+func (a *GitClone) Apply(r utils.Runner, pending []Diff) error {
+	args := []string{"clone", a.URL, a.Dest}
+	if !a.Token.Empty() {
+		helper, done, err := a.cacheToken(r)
+		if err != nil {
+			return err
+		}
+		defer done()
+		args = append(helper, args...)
 	}
 
-	// Clone repository
-	if err := utils.RunCommandAsUser(username, "git", "clone", url, dest); err != nil {
-		return fmt.Errorf("failed to clone repository: %w", err)
+	if err := r.RunAsUser(a.Username, "git", args...); err != nil {
+		return fail(CategoryCommand, "Check the repository URL and that the user's SSH keys (or token) are in place",
+			fmt.Errorf("failed to clone repository: %w", err))
 	}
 
 	userLog.Info("Repository cloned successfully")
 	return nil
 }
+
+// cacheToken stores the token in a credential-cache daemon of the user,
+// listening on a socket of this run only. It returns the git options that
+// make a command use that daemon alone (no credential helper of the user
+// gets to store the token), and a function that stops the daemon.
+func (a *GitClone) cacheToken(r utils.Runner) (helper []string, done func(), err error) {
+	_, _, home, err := utils.LookupUser(a.Username)
+	if err != nil {
+		return nil, nil, fail(CategoryConfig, "", err)
+	}
+	// git creates the socket's directory, as the user and with mode 0700
+	socketDir := filepath.Join(home, ".cache", "phoenix", fmt.Sprintf("git-credential-%d", os.Getpid()))
+	socket := filepath.Join(socketDir, "socket")
+	helper = []string{
+		"-c", "credential.helper=",
+		"-c", fmt.Sprintf("credential.helper=cache --timeout=%s --socket=%s", gitCredentialTimeout, socket),
+	}
+	done = func() {
+		if err := r.RunAsUser(a.Username, "git", "credential-cache", "--socket="+socket, "exit"); err != nil {
+			userLog.Warnf("Failed to stop the git credential cache at %s: %v", socket, err)
+		}
+		os.RemoveAll(socketDir)
+	}
+
+	// The credential protocol input. Sized up front, so that no copy of the
+	// token is left behind by a growing slice; New zeroes it.
+	prefix := fmt.Sprintf("url=%s\nusername=%s\npassword=", a.URL, a.TokenUser)
+	input := make([]byte, 0, len(prefix)+a.Token.Len()+2)
+	input = append(input, prefix...)
+	input = append(input, a.Token.Bytes()...)
+	input = append(input, "\n\n"...)
+	credential := secret.New(input)
+	defer credential.Destroy()
+
+	args := append(append([]string{}, helper...), "credential", "approve")
+	if err := r.RunAsUserWithStdin(credential.Reader(), a.Username, "git", args...); err != nil {
+		done()
+		return nil, nil, fail(CategoryCommand, "git credential-cache is part of git-core: check that git is installed",
+			fmt.Errorf("failed to hand the token of %s to git: %w", a.URL, err))
+	}
+	return helper, done, nil
+}
@@ -0,0 +1,224 @@
+package ops
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/acker1019/fedora-phoenix/internal/secret"
+	"github.com/acker1019/fedora-phoenix/internal/utils"
+	"github.com/acker1019/fedora-phoenix/internal/utils/utilstest"
+)
+
+func TestEnsureUserShell(t *testing.T) {
+	shell, err := currentShell("root")
+	if err != nil {
+		t.Fatalf("currentShell() error = %v", err)
+	}
+
+	runActCases(t, []actCase{
+		{
+			name: "shell already set",
+			act:  constAct(&EnsureUserShell{Username: "root", Shell: shell}),
+		},
+		{
+			name:    "shell is changed",
+			act:     constAct(&EnsureUserShell{Username: "root", Shell: "/usr/bin/phoenix-test-sh"}),
+			script:  func(r *utilstest.FakeRunner) { r.Expect("usermod -s /usr/bin/phoenix-test-sh root") },
+			pending: []string{"root"},
+		},
+		{
+			name:   "usermod rejects the shell",
+			act:    constAct(&EnsureUserShell{Username: "root", Shell: "/usr/bin/phoenix-test-sh"}),
+			script: func(r *utilstest.FakeRunner) { r.Expect("usermod -s /usr/bin/phoenix-test-sh root").Exit(6) },
+			fail:   CategoryConfig,
+			exit:   6,
+		},
+	})
+}
+
+func TestEnsureSymlink(t *testing.T) {
+	dir := t.TempDir()
+	linked := filepath.Join(dir, "linked")
+	if err := os.Symlink("/data/notes", linked); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing")
+
+	runActCases(t, []actCase{
+		{
+			name: "link already correct",
+			act:  constAct(&EnsureSymlink{Src: "/data/notes", Dest: linked, Username: "ack"}),
+		},
+		{
+			name:    "link is created as the user",
+			act:     constAct(&EnsureSymlink{Src: "/data/notes", Dest: missing, Username: "ack"}),
+			script:  func(r *utilstest.FakeRunner) { r.Expect("ack@ln -sfn /data/notes " + missing) },
+			pending: []string{missing},
+		},
+		{
+			name:    "link pointing elsewhere is replaced",
+			act:     constAct(&EnsureSymlink{Src: "/data/other", Dest: linked, Username: "ack"}),
+			script:  func(r *utilstest.FakeRunner) { r.Expect("ack@ln -sfn /data/other " + linked) },
+			pending: []string{linked},
+		},
+		{
+			name:   "parent not writable",
+			act:    constAct(&EnsureSymlink{Src: "/data/notes", Dest: missing, Username: "ack"}),
+			script: func(r *utilstest.FakeRunner) { r.Expect("ack@ln -sfn /data/notes " + missing).Exit(1) },
+			fail:   CategoryPermission,
+			exit:   1,
+		},
+	})
+}
+
+func TestExtractTarball(t *testing.T) {
+	dir := t.TempDir()
+	full := filepath.Join(dir, "full")
+	if err := os.MkdirAll(filepath.Join(full, "zsh"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	empty := filepath.Join(dir, "empty")
+	archive := filepath.Join(dir, "dotfiles.tgz")
+
+	runActCases(t, []actCase{
+		{
+			name: "destination already populated",
+			act:  constAct(&ExtractTarball{Archive: archive, DestDir: full, Username: "ack"}),
+		},
+		{
+			name: "archive is extracted as the user",
+			act:  constAct(&ExtractTarball{Archive: archive, DestDir: empty, Username: "ack"}),
+			script: func(r *utilstest.FakeRunner) {
+				r.Expect("ack@mkdir -p " + empty)
+				r.Expect("ack@tar -xzf " + archive + " -C " + empty)
+			},
+			pending: []string{archive},
+		},
+		{
+			name:   "destination cannot be created",
+			act:    constAct(&ExtractTarball{Archive: archive, DestDir: empty, Username: "ack"}),
+			script: func(r *utilstest.FakeRunner) { r.Expect("ack@mkdir -p " + empty).Exit(1) },
+			fail:   CategoryPermission,
+			exit:   1,
+		},
+		{
+			name: "corrupt archive",
+			act:  constAct(&ExtractTarball{Archive: archive, DestDir: empty, Username: "ack"}),
+			script: func(r *utilstest.FakeRunner) {
+				r.Expect("ack@mkdir -p " + empty)
+				r.Expect("ack@tar -xzf " + archive + " -C " + empty).Exit(2).Stderr("gzip: stdin: not in gzip format")
+			},
+			fail: CategoryCommand,
+			exit: 2,
+		},
+	})
+}
+
+func TestRunStow(t *testing.T) {
+	runActCases(t, []actCase{
+		{
+			name: "nothing to stow",
+			act:  constAct(&RunStow{SourceDir: "/home/ack/dotfiles", TargetDir: "/home/ack", Username: "ack"}),
+		},
+		{
+			name: "every package is restowed",
+			act: constAct(&RunStow{
+				SourceDir: "/home/ack/dotfiles", TargetDir: "/home/ack", Packages: []string{"zsh", "git"}, Username: "ack",
+			}),
+			script: func(r *utilstest.FakeRunner) {
+				r.Expect("ack@stow -d /home/ack/dotfiles -t /home/ack -R zsh")
+				r.Expect("ack@stow -d /home/ack/dotfiles -t /home/ack -R git")
+			},
+			pending: []string{"zsh", "git"},
+		},
+		{
+			name: "conflicting file in the target",
+			act: constAct(&RunStow{
+				SourceDir: "/home/ack/dotfiles", TargetDir: "/home/ack", Packages: []string{"zsh"}, Username: "ack",
+			}),
+			script: func(r *utilstest.FakeRunner) {
+				r.Expect("ack@stow -d /home/ack/dotfiles -t /home/ack -R zsh").Exit(1)
+			},
+			fail: CategoryCommand,
+			exit: 1,
+		},
+	})
+}
+
+func TestGitClone(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "project")
+	const url = "git@github.com:user/project.git"
+
+	runActCases(t, []actCase{
+		{
+			name: "destination exists",
+			act:  constAct(&GitClone{URL: url, Dest: dir, Username: "ack"}),
+		},
+		{
+			name:    "repository is cloned as the user",
+			act:     constAct(&GitClone{URL: url, Dest: missing, Username: "ack"}),
+			script:  func(r *utilstest.FakeRunner) { r.Expect("ack@git clone " + url + " " + missing) },
+			pending: []string{missing},
+		},
+		{
+			name:   "clone fails",
+			act:    constAct(&GitClone{URL: url, Dest: missing, Username: "ack"}),
+			script: func(r *utilstest.FakeRunner) { r.Expect("ack@git clone " + url + " " + missing).Exit(128) },
+			fail:   CategoryCommand,
+			exit:   128,
+		},
+	})
+}
+
+func TestGitCloneToken(t *testing.T) {
+	_, _, home, err := utils.LookupUser("root")
+	if err != nil {
+		t.Fatalf("LookupUser() error = %v", err)
+	}
+	dest := filepath.Join(t.TempDir(), "project")
+	const url = "https://github.com/user/project.git"
+	const token = "ghp_phoenixtesttoken"
+
+	socket := filepath.Join(home, ".cache", "phoenix", fmt.Sprintf("git-credential-%d", os.Getpid()), "socket")
+	helper := "-c credential.helper= -c credential.helper=cache --timeout=" + gitCredentialTimeout + " --socket=" + socket
+
+	for _, tc := range []struct {
+		name  string
+		clone int // Exit code of git clone
+	}{
+		{name: "token is cached for the clone"},
+		{name: "daemon is stopped when the clone fails", clone: 128},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := utilstest.NewFakeRunner()
+			r.Expect("root@git " + helper + " credential approve")
+			r.Expect("root@git " + helper + " clone " + url + " " + dest).Exit(tc.clone)
+			r.Expect("root@git credential-cache --socket=" + socket + " exit")
+
+			act := &GitClone{URL: url, Dest: dest, Username: "root", TokenUser: "oauth2", Token: secret.FromString(token)}
+			_, err := (&Playbook{Runner: r}).Run([]Act{act})
+			if tc.clone != 0 {
+				checkActError(t, act, err, CategoryCommand, tc.clone)
+			} else if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if unmet := r.Unmet(); unmet != nil {
+				t.Fatalf("runner script not followed: %q", unmet)
+			}
+
+			want := "url=" + url + "\nusername=oauth2\npassword=" + token + "\n\n"
+			if got := r.Calls[0].Stdin; got != want {
+				t.Errorf("credential approve stdin = %q, want %q", got, want)
+			}
+			for _, c := range r.Calls {
+				if strings.Contains(c.String(), token) {
+					t.Errorf("token passed on the command line: %q", c.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package ops
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/acker1019/fedora-phoenix/internal/logging"
+	"github.com/acker1019/fedora-phoenix/internal/utils"
+)
+
+var verifyLog = logging.WithSource("ops/verify")
+
+// Verify runs only the Check half of every Checker and reports drift.
+// Unlike Playbook.Run it never stops early: a failing Check is recorded
+// in its Result and the audit moves on.
+func Verify(r utils.Runner, checkers []Checker) []Result {
+	results := make([]Result, 0, len(checkers))
+
+	for _, c := range checkers {
+		start := time.Now()
+		res := Result{Act: c}
+		res.Diffs, res.Err = c.Check(r)
+		if res.Err != nil {
+			verifyLog.Warnf("Check %s failed: %v", c.Name(), res.Err)
+		}
+		res.Duration = time.Since(start)
+		results = append(results, res)
+	}
+
+	return results
+}
+
+// ExtraPackages reports user-installed packages that the blueprint does not declare.
+// It is audit-only and has no Apply half: Phoenix never removes packages.
+type ExtraPackages struct {
+	Declared []string // Packages and pinned packages from the blueprint
+}
+
+func (c *ExtraPackages) Name() string { return "ExtraPackages" }
+func (c *ExtraPackages) Block() Block { return BlockSystem }
+
+// Check lists every user-installed package name not covered by Declared.
+func (c *ExtraPackages) Check(r utils.Runner) ([]Diff, error) {
+	output, err := r.Output("dnf", "repoquery", "--userinstalled", "--queryformat", "%{name}\\n")
+	if err != nil {
+		return nil, fail(CategoryPackage, "Check that dnf works for the current user", fmt.Errorf("failed to list user-installed packages: %w", err))
+	}
+
+	declared := c.declaredNames()
+	var diffs []Diff
+	for _, name := range strings.Fields(string(output)) {
+		if declared[name] {
+			continue
+		}
+		diffs = append(diffs, pending(name, fmt.Sprintf("package %s", name), "installed but not in blueprint"))
+	}
+	return diffs, nil
+}
+
+// declaredNames returns the package names of Declared. A spec counts both
+// as written, for plain names that look like versions, and by the name
+// parsed from a pinned name-version-release spec.
+func (c *ExtraPackages) declaredNames() map[string]bool {
+	names := make(map[string]bool, 2*len(c.Declared))
+	for _, spec := range c.Declared {
+		names[spec] = true
+		names[parsePackageSpec(spec).name] = true
+	}
+	return names
+}
+
+// rpmArches are the arch suffixes a package spec may end with.
+var rpmArches = []string{"x86_64", "noarch", "i686", "aarch64", "ppc64le", "s390x", "armv7hl", "src"}
+
+// packageSpec is a package spec split into its rpm fields; the fields
+// the spec does not give are empty.
+type packageSpec struct {
+	name    string
+	epoch   string
+	version string
+	release string
+	arch    string
+}
+
+// parsePackageSpec splits name[-[epoch:]version[-release]][.arch].
+// Version and release must start with a digit, so that the dashes of
+// names such as python3-dnf-plugin-versionlock stay in the name.
+func parsePackageSpec(spec string) packageSpec {
+	var p packageSpec
+	if i := strings.LastIndexByte(spec, '.'); i > 0 && slices.Contains(rpmArches, spec[i+1:]) {
+		spec, p.arch = spec[:i], spec[i+1:]
+	}
+
+	parts := strings.Split(spec, "-")
+	n := len(parts)
+	switch {
+	case n >= 3 && startsWithDigit(parts[n-2]) && startsWithDigit(parts[n-1]):
+		p.name, p.version, p.release = strings.Join(parts[:n-2], "-"), parts[n-2], parts[n-1]
+	case n >= 2 && startsWithDigit(parts[n-1]):
+		p.name, p.version = strings.Join(parts[:n-1], "-"), parts[n-1]
+	default:
+		p.name = spec
+	}
+	if epoch, version, ok := strings.Cut(p.version, ":"); ok {
+		p.epoch, p.version = epoch, version
+	}
+	return p
+}
+
+func startsWithDigit(s string) bool {
+	return s != "" && s[0] >= '0' && s[0] <= '9'
+}
+
+// StowLinks reports Stow packages whose files are not linked into the target directory.
+// It is audit-only: RunStow -R is the Act that repairs them.
+type StowLinks struct {
+	SourceDir string
+	TargetDir string
+	Packages  []string
+}
+
+func (c *StowLinks) Name() string { return "StowLinks" }
+func (c *StowLinks) Block() Block { return BlockUserSpace }
+
+// Check walks each package and verifies that every file resolves back to it,
+// either through a file symlink or a folded directory symlink.
+func (c *StowLinks) Check(r utils.Runner) ([]Diff, error) {
+	diffs := make([]Diff, 0, len(c.Packages))
+	for _, pkg := range c.Packages {
+		item := fmt.Sprintf("dotfiles %s", pkg)
+		broken, err := c.brokenLinks(filepath.Join(c.SourceDir, pkg))
+		if err != nil {
+			diffs = append(diffs, pending(pkg, item, err.Error()))
+			continue
+		}
+		if len(broken) > 0 {
+			slices.Sort(broken)
+			diffs = append(diffs, pending(pkg, item, fmt.Sprintf("%d broken links: %s", len(broken), strings.Join(broken, ", "))))
+			continue
+		}
+		diffs = append(diffs, satisfied(pkg, item, "all links intact"))
+	}
+	return diffs, nil
+}
+
+// brokenLinks returns the package-relative paths that do not resolve to the package.
+func (c *StowLinks) brokenLinks(pkgDir string) ([]string, error) {
+	var broken []string
+
+	err := filepath.WalkDir(pkgDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(pkgDir, path)
+		if err != nil {
+			return err
+		}
+
+		want, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return err
+		}
+		got, err := filepath.EvalSymlinks(filepath.Join(c.TargetDir, rel))
+		if err != nil || got != want {
+			broken = append(broken, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("package directory %s is missing", pkgDir)
+		}
+		return nil, err
+	}
+
+	return broken, nil
+}
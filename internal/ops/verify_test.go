@@ -0,0 +1,58 @@
+package ops
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/acker1019/fedora-phoenix/internal/utils/utilstest"
+)
+
+func TestParsePackageSpec(t *testing.T) {
+	tests := []struct {
+		spec string
+		want packageSpec
+	}{
+		{"vim", packageSpec{name: "vim"}},
+		{"vim-enhanced", packageSpec{name: "vim-enhanced"}},
+		{"python3-dnf-plugin-versionlock", packageSpec{name: "python3-dnf-plugin-versionlock"}},
+		{"glibc.i686", packageSpec{name: "glibc", arch: "i686"}},
+		{"tmux-3.5a", packageSpec{name: "tmux", version: "3.5a"}},
+		{"kernel-6.11.4-301.fc41", packageSpec{name: "kernel", version: "6.11.4", release: "301.fc41"}},
+		{"kernel-6.11.4-301.fc41.x86_64", packageSpec{name: "kernel", version: "6.11.4", release: "301.fc41", arch: "x86_64"}},
+		{"vim-enhanced-2:9.1.0-1.fc41", packageSpec{name: "vim-enhanced", epoch: "2", version: "9.1.0", release: "1.fc41"}},
+		{"java-21-openjdk", packageSpec{name: "java-21-openjdk"}},
+		{"java-21-openjdk-21.0.5.0.11-1.fc41", packageSpec{name: "java-21-openjdk", version: "21.0.5.0.11", release: "1.fc41"}},
+	}
+
+	for _, tt := range tests {
+		if got := parsePackageSpec(tt.spec); got != tt.want {
+			t.Errorf("parsePackageSpec(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestExtraPackages(t *testing.T) {
+	const query = "dnf repoquery --userinstalled --queryformat %{name}\\n"
+	installed := "vim\nvim-enhanced\nkernel\njava-21-openjdk\ntmux\nsteam\n"
+
+	r := utilstest.NewFakeRunner()
+	r.Expect(query).Stdout(installed)
+	check := &ExtraPackages{Declared: []string{"vim-enhanced", "kernel-6.11.4-301.fc41", "java-21-openjdk", "tmux-3.5a-1.fc41.x86_64"}}
+
+	diffs, err := check.Check(r)
+	if err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	// vim is not declared by vim-enhanced, whatever their shared prefix
+	if got, want := diffKeys(diffs), []string{"vim", "steam"}; !slices.Equal(got, want) {
+		t.Errorf("extra packages = %q, want %q", got, want)
+	}
+
+	r = utilstest.NewFakeRunner()
+	r.Expect(query).Exit(1)
+	if _, err := check.Check(r); err == nil {
+		t.Error("Check() succeeded without a package list")
+	} else {
+		checkCategory(t, err, CategoryPackage)
+	}
+}
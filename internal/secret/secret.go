@@ -0,0 +1,148 @@
+// Package secret holds sensitive values (passwords, tokens, keys) outside
+// the Go heap: in mlock'ed pages between two guard pages, excluded from
+// core dumps, registered for log redaction and zeroed when destroyed.
+package secret
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/acker1019/fedora-phoenix/internal/logging"
+	"golang.org/x/sys/unix"
+	"gopkg.in/yaml.v3"
+)
+
+var secretLog = logging.WithSource("secret")
+
+// lockWarning makes sure a failed mlock is only reported once.
+var lockWarning sync.Once
+
+// Value is a secret byte string. Its String and %v formatting print
+// logging.RedactedText, never the value. The zero Value and nil are empty.
+//
+// A Value must be destroyed once it is no longer needed; its memory is
+// not managed by the garbage collector.
+type Value struct {
+	mem []byte // Whole mapping, including the guard pages
+	buf []byte // The value, at the end of the accessible pages
+}
+
+// New moves b into a new Value and zeroes b.
+func New(b []byte) *Value {
+	v := &Value{}
+	if len(b) == 0 {
+		return v
+	}
+
+	page := os.Getpagesize()
+	size := (len(b) + page - 1) / page * page
+	mem, err := unix.Mmap(-1, 0, size+2*page, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		// Out of address space: keep the value on the heap rather than lose it
+		secretLog.Warnf("Cannot map memory for a secret: %v", err)
+		v.buf = bytes.Clone(b)
+	} else {
+		// Guard pages turn an overrun into a crash instead of a leak
+		unix.Mprotect(mem[:page], unix.PROT_NONE)
+		unix.Mprotect(mem[page+size:], unix.PROT_NONE)
+		unix.Madvise(mem[page:page+size], unix.MADV_DONTDUMP)
+		if err := unix.Mlock(mem[page : page+size]); err != nil {
+			lockWarning.Do(func() {
+				secretLog.Warnf("Cannot lock secrets in memory (%v): they may be written to swap", err)
+			})
+		}
+		v.mem = mem
+		v.buf = mem[page+size-len(b) : page+size]
+		copy(v.buf, b)
+	}
+	clear(b)
+
+	logging.AddSecret(v.buf)
+	return v
+}
+
+// FromString returns a Value holding s. The string itself cannot be
+// zeroed, so prefer New where the bytes are at hand.
+func FromString(s string) *Value {
+	return New([]byte(s))
+}
+
+// Bytes returns the value without copying. The slice is only valid until
+// Destroy, and must not be retained or modified.
+func (v *Value) Bytes() []byte {
+	if v == nil {
+		return nil
+	}
+	return v.buf
+}
+
+// Reader returns a reader of the value, e.g. to feed a command's stdin.
+func (v *Value) Reader() io.Reader {
+	return bytes.NewReader(v.Bytes())
+}
+
+// Len returns the length of the value in bytes.
+func (v *Value) Len() int {
+	return len(v.Bytes())
+}
+
+// Empty reports whether the value is nil, empty or destroyed.
+func (v *Value) Empty() bool {
+	return v.Len() == 0
+}
+
+// Equal compares two values in constant time.
+func (v *Value) Equal(other *Value) bool {
+	return subtle.ConstantTimeCompare(v.Bytes(), other.Bytes()) == 1
+}
+
+// Destroy zeroes the value and releases its memory. It is safe to call
+// more than once, and on nil.
+func (v *Value) Destroy() {
+	if v == nil || v.buf == nil {
+		return
+	}
+	logging.RemoveSecret(v.buf)
+	clear(v.buf)
+	if v.mem != nil {
+		unix.Munlock(v.mem)
+		unix.Munmap(v.mem)
+	}
+	v.mem, v.buf = nil, nil
+}
+
+// String returns the redaction marker, never the value.
+func (v *Value) String() string {
+	return logging.RedactedText
+}
+
+// GoString keeps %#v from printing the fields.
+func (v *Value) GoString() string {
+	return logging.RedactedText
+}
+
+// Format prints the redaction marker for every verb.
+func (v *Value) Format(f fmt.State, verb rune) {
+	io.WriteString(f, logging.RedactedText)
+}
+
+// UnmarshalYAML reads a scalar into locked memory. The YAML decoder has
+// already made a string of it, which cannot be zeroed; the raw file
+// content is cleared by the callers instead.
+func (v *Value) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: a secret must be a string", node.Line)
+	}
+	*v = *New([]byte(node.Value))
+	return nil
+}
+
+// MarshalYAML writes the redaction marker, so that marshalling a
+// structure never leaks its secrets.
+func (v *Value) MarshalYAML() (any, error) {
+	return logging.RedactedText, nil
+}
@@ -2,6 +2,7 @@ package session
 
 import (
 	"github.com/acker1019/fedora-phoenix/internal/config"
+	"github.com/acker1019/fedora-phoenix/internal/utils"
 )
 
 // Session holds all runtime state for a single provision execution.
@@ -11,7 +12,11 @@ import (
 type Session struct {
 	// Configuration (loaded from files)
 	Blueprint *config.Blueprint
-	Secrets   *config.Secrets
+	Secrets   *config.Secrets // In locked memory, destroyed at the end of the run
+	Prompt    bool            // Missing or wrong secrets may be asked for on the terminal
+
+	// Command Execution (replaceable by utilstest.FakeRunner)
+	Runner utils.Runner
 
 	// User Identity (discovered at runtime)
 	Username string // Real user who invoked sudo (e.g., "ack")
@@ -20,15 +25,21 @@ type Session struct {
 	UserHome string // User's home directory path (e.g., "/home/ack")
 
 	// Infrastructure State (from Block II)
-	LuksMapperName string // LUKS mapper name (e.g., "company_data")
-	LuksMountPoint string // LUKS mount point (e.g., "/mnt/company_data")
-	LuksUnlocked   bool   // Whether LUKS device is currently unlocked
-	LuksMounted    bool   // Whether LUKS device is currently mounted
+	LuksVolumes []LuksVolume // One entry per infrastructure.luks volume, in blueprint order
 
 	// Expanded Paths (from Block IV)
 	StowSourceDir string // Expanded stow source directory
 	StowTargetDir string // Expanded stow target directory
+	SyncBaseDir   string // Expanded sync base directory (dotfiles repo)
 
 	// Temporary Variables
 	DotfilesArchive string // Path to dotfiles tarball (from --dotfiles-archive flag)
 }
+
+// LuksVolume is the runtime state of one LUKS volume.
+type LuksVolume struct {
+	MapperName string // LUKS mapper name (e.g., "company_data")
+	MountPoint string // LUKS mount point (e.g., "/mnt/company_data")
+	Optional   bool   // Volume may be absent (e.g. external disk)
+	Mounted    bool   // Whether the volume is mounted after Block II
+}
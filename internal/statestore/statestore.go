@@ -0,0 +1,169 @@
+// Package statestore persists the metadata Git cannot track for synced
+// dotfiles (exact mode, owner, content hash). The store is a sidecar file
+// that lives inside the dotfiles repo, see ADR-0007 "The Sidecar State Store".
+package statestore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/acker1019/fedora-phoenix/internal/logging"
+	"gopkg.in/yaml.v3"
+)
+
+var storeLog = logging.WithSource("statestore")
+
+// FileName is the store's name at the root of the dotfiles repo.
+const FileName = ".phoenix-state.yml"
+
+// CurrentVersion is the on-disk format version written by Save.
+const CurrentVersion = 1
+
+// Record is the metadata of one managed file.
+type Record struct {
+	Path   string `yaml:"path"`   // Repo-relative path (e.g. "ssh/id_ed25519")
+	Mode   string `yaml:"mode"`   // Octal permission bits (e.g. "0600")
+	Owner  string `yaml:"owner"`  // "user:group" by name, portable across machines
+	SHA256 string `yaml:"sha256"` // Content hash at the time of recording
+}
+
+// FileMode parses the recorded octal mode.
+func (r Record) FileMode() (os.FileMode, error) {
+	mode, err := strconv.ParseUint(r.Mode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid mode %q for %s", r.Mode, r.Path)
+	}
+	return os.FileMode(mode), nil
+}
+
+// FormatMode renders permission bits the way Record.Mode stores them.
+func FormatMode(mode os.FileMode) string {
+	return fmt.Sprintf("%04o", mode.Perm())
+}
+
+// Store is the in-memory form of the sidecar file.
+type Store struct {
+	Version int      `yaml:"version"`
+	Files   []Record `yaml:"files"`
+}
+
+// New returns an empty store at the current version.
+func New() *Store {
+	return &Store{Version: CurrentVersion}
+}
+
+// Load reads the store at path. A missing file yields an empty store,
+// since a fresh dotfiles repo has no recorded metadata yet.
+func Load(path string) (*Store, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		storeLog.Infof("No state store at %s, starting empty", path)
+		return New(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state store: %w", err)
+	}
+
+	var s Store
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse state store %s: %w", path, err)
+	}
+	if s.Version == 0 || s.Version > CurrentVersion {
+		return nil, fmt.Errorf("unsupported state store version %d in %s (this binary supports up to %d)", s.Version, path, CurrentVersion)
+	}
+
+	for _, rec := range s.Files {
+		if _, err := rec.FileMode(); err != nil {
+			return nil, fmt.Errorf("corrupt state store %s: %w", path, err)
+		}
+	}
+
+	s.sort()
+	return &s, nil
+}
+
+// Save writes the store atomically with records sorted by path,
+// so that repeated saves produce clean, minimal git diffs.
+func (s *Store) Save(path string) error {
+	s.Version = CurrentVersion
+	s.sort()
+
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to encode state store: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".phoenix-state-*")
+	if err != nil {
+		return fmt.Errorf("failed to write state store: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state store: %w", err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write state store: %w", err)
+	}
+
+	storeLog.Infof("State store saved: %s (%d records)", path, len(s.Files))
+	return nil
+}
+
+// Get returns the record for a repo-relative path.
+func (s *Store) Get(path string) (Record, bool) {
+	i := s.index(path)
+	if i < 0 {
+		return Record{}, false
+	}
+	return s.Files[i], true
+}
+
+// Set inserts or replaces the record for rec.Path.
+// It reports whether the store changed.
+func (s *Store) Set(rec Record) bool {
+	i := s.index(rec.Path)
+	if i < 0 {
+		s.Files = append(s.Files, rec)
+		return true
+	}
+	if s.Files[i] == rec {
+		return false
+	}
+	s.Files[i] = rec
+	return true
+}
+
+// Merge copies every record of other into s; records in other win.
+func (s *Store) Merge(other *Store) {
+	for _, rec := range other.Files {
+		s.Set(rec)
+	}
+	s.sort()
+}
+
+// index finds a record by path, or returns -1.
+func (s *Store) index(path string) int {
+	for i := range s.Files {
+		if s.Files[i].Path == path {
+			return i
+		}
+	}
+	return -1
+}
+
+// sort orders records by path for deterministic output.
+func (s *Store) sort() {
+	sort.Slice(s.Files, func(i, j int) bool { return s.Files[i].Path < s.Files[j].Path })
+}
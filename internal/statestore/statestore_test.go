@@ -0,0 +1,130 @@
+package statestore
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSaveLoadRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	s := New()
+	s.Set(Record{Path: "zshrc", Mode: "0644", Owner: "ack:ack", SHA256: "aa"})
+	s.Set(Record{Path: "ssh/id_ed25519", Mode: "0600", Owner: "ack:ack", SHA256: "bb"})
+	s.Set(Record{Path: "gitconfig", Mode: "0644", Owner: "ack:ack", SHA256: "cc"})
+
+	if err := s.Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !reflect.DeepEqual(loaded, s) {
+		t.Errorf("Load() = %+v, want %+v", loaded, s)
+	}
+
+	var paths []string
+	for _, rec := range loaded.Files {
+		paths = append(paths, rec.Path)
+	}
+	if want := []string{"gitconfig", "ssh/id_ed25519", "zshrc"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("record order = %q, want %q", paths, want)
+	}
+
+	// Saving again without changes must not change the file
+	first, _ := os.ReadFile(path)
+	if err := loaded.Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if second, _ := os.ReadFile(path); string(first) != string(second) {
+		t.Errorf("second save changed the file:\n%s\n---\n%s", first, second)
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	s, err := Load(filepath.Join(t.TempDir(), FileName))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if s.Version != CurrentVersion || len(s.Files) != 0 {
+		t.Errorf("Load() = %+v, want an empty store", s)
+	}
+}
+
+func TestLoadRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"no version", "files: []\n", "unsupported state store version 0"},
+		{"future version", "version: 2\nfiles: []\n", "unsupported state store version 2"},
+		{"invalid yaml", "version: [1\n", "failed to parse"},
+		{"mode not octal", "version: 1\nfiles:\n  - path: zshrc\n    mode: \"0689\"\n", `invalid mode "0689" for zshrc`},
+		{"mode beyond permission bits", "version: 1\nfiles:\n  - path: zshrc\n    mode: \"4755\"\n", `invalid mode "4755"`},
+		{"mode missing", "version: 1\nfiles:\n  - path: zshrc\n", `invalid mode "" for zshrc`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), FileName)
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := Load(path)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Load() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSet(t *testing.T) {
+	s := New()
+	rec := Record{Path: "zshrc", Mode: "0644", Owner: "ack:ack", SHA256: "aa"}
+	if !s.Set(rec) {
+		t.Error("Set() of a new record reported no change")
+	}
+	if s.Set(rec) {
+		t.Error("Set() of an identical record reported a change")
+	}
+	rec.Mode = "0600"
+	if !s.Set(rec) {
+		t.Error("Set() of a changed record reported no change")
+	}
+	if got, ok := s.Get("zshrc"); !ok || got != rec || len(s.Files) != 1 {
+		t.Errorf("Get() = %+v, %v with %d records, want %+v", got, ok, len(s.Files), rec)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	s := New()
+	s.Set(Record{Path: "zshrc", Mode: "0644", Owner: "ack:ack", SHA256: "old"})
+	s.Set(Record{Path: "vimrc", Mode: "0644", Owner: "ack:ack", SHA256: "vim"})
+
+	other := New()
+	other.Set(Record{Path: "zshrc", Mode: "0600", Owner: "root:root", SHA256: "new"})
+	other.Set(Record{Path: "bashrc", Mode: "0644", Owner: "ack:ack", SHA256: "bash"})
+
+	s.Merge(other)
+	want := []Record{
+		{Path: "bashrc", Mode: "0644", Owner: "ack:ack", SHA256: "bash"},
+		{Path: "vimrc", Mode: "0644", Owner: "ack:ack", SHA256: "vim"},
+		{Path: "zshrc", Mode: "0600", Owner: "root:root", SHA256: "new"},
+	}
+	if !reflect.DeepEqual(s.Files, want) {
+		t.Errorf("Merge() = %+v, want %+v", s.Files, want)
+	}
+}
+
+func TestFileMode(t *testing.T) {
+	if mode, err := (Record{Path: "zshrc", Mode: "0600"}).FileMode(); err != nil || mode != 0600 {
+		t.Errorf("FileMode() = %v, %v; want 0600", mode, err)
+	}
+	if got := FormatMode(0755 | os.ModeDir); got != "0755" {
+		t.Errorf("FormatMode() = %q, want 0755", got)
+	}
+}
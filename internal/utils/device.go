@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// deviceSelectors maps blueprint device selectors to the udev symlink
+// directories that resolve them.
+var deviceSelectors = map[string]string{
+	"uuid":      "/dev/disk/by-uuid",
+	"partuuid":  "/dev/disk/by-partuuid",
+	"partlabel": "/dev/disk/by-partlabel",
+	"label":     "/dev/disk/by-label",
+}
+
+// DeviceSpecPath turns a device spec into a path. Selectors such as
+// "uuid:1234-abcd" or "partlabel:work data" become their /dev/disk/by-*
+// symlink; plain paths (e.g. /dev/nvme0n1p4) are returned unchanged.
+func DeviceSpecPath(spec string) (string, error) {
+	if strings.HasPrefix(spec, "/") {
+		return spec, nil
+	}
+
+	kind, value, ok := strings.Cut(spec, ":")
+	dir, known := deviceSelectors[kind]
+	if !ok || !known || value == "" {
+		return "", fmt.Errorf("invalid device %q: use an absolute path or one of uuid:, partuuid:, partlabel:, label:", spec)
+	}
+	return filepath.Join(dir, udevEscape(value)), nil
+}
+
+// ResolveDevice resolves a device spec to its real device node
+// (e.g. /dev/nvme0n1p4). The error wraps os.ErrNotExist if the device is absent.
+func ResolveDevice(spec string) (string, error) {
+	path, err := DeviceSpecPath(spec)
+	if err != nil {
+		return "", err
+	}
+
+	dev, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("device %s not found: %w", spec, os.ErrNotExist)
+		}
+		return "", fmt.Errorf("failed to resolve device %s: %w", spec, err)
+	}
+	return dev, nil
+}
+
+// udevEscape encodes a label the way udev names /dev/disk/by-*label links:
+// every byte outside [A-Za-z0-9#+-.:=@_] becomes \xNN (e.g. " " -> \x20).
+func udevEscape(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9',
+			strings.IndexByte("#+-.:=@_", c) >= 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		}
+	}
+	return b.String()
+}
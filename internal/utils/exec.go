@@ -1,11 +1,12 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
-	"os/user"
-	"strconv"
+	"strings"
 	"syscall"
 
 	"github.com/acker1019/fedora-phoenix/internal/logging"
@@ -19,23 +20,17 @@ var execLog = logging.WithSource("utils/exec")
 // It switches the process context to the target user's UID/GID
 // before executing the command, preventing "root-owned files" in user space.
 func RunCommandAsUser(username, name string, args ...string) error {
+	return RunCommandAsUserWithStdin(nil, username, name, args...)
+}
+
+// RunCommandAsUserWithStdin is RunCommandAsUser with stdin fed from stdin.
+func RunCommandAsUserWithStdin(stdin io.Reader, username, name string, args ...string) error {
 	execLog.Infof("Executing as %s: %s %v", username, name, args)
 
 	// Lookup user information
-	u, err := user.Lookup(username)
-	if err != nil {
-		return fmt.Errorf("failed to lookup user %s: %w", username, err)
-	}
-
-	// Parse UID and GID
-	uid, err := strconv.Atoi(u.Uid)
-	if err != nil {
-		return fmt.Errorf("invalid UID for user %s: %w", username, err)
-	}
-
-	gid, err := strconv.Atoi(u.Gid)
+	uid, gid, homeDir, err := LookupUser(username)
 	if err != nil {
-		return fmt.Errorf("invalid GID for user %s: %w", username, err)
+		return err
 	}
 
 	// Create command
@@ -50,17 +45,83 @@ func RunCommandAsUser(username, name string, args ...string) error {
 	}
 
 	// Set HOME environment variable for the user
-	cmd.Env = append(os.Environ(), fmt.Sprintf("HOME=%s", u.HomeDir))
+	cmd.Env = append(os.Environ(), fmt.Sprintf("HOME=%s", homeDir))
 
 	// Connect stdout/stderr for visibility
+	cmd.Stdin = stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
 	// Execute command
-	if err := cmd.Run(); err != nil {
+	if err := RunCommand(cmd); err != nil {
 		return fmt.Errorf("command failed: %w", err)
 	}
 
 	execLog.Infof("Command executed successfully as %s", username)
 	return nil
 }
+
+// stderrTailLines is the number of trailing stderr lines kept in a CommandError.
+const stderrTailLines = 10
+
+// CommandError describes an external command that failed to run or exited non-zero.
+type CommandError struct {
+	Command  string // Command line as executed (e.g. "mount /dev/mapper/x /mnt/x")
+	ExitCode int    // Process exit code, or -1 if the process never ran
+	Stderr   string // Last lines written to stderr
+	Err      error  // Underlying *exec.ExitError or start error
+}
+
+func (e *CommandError) Error() string {
+	if e.ExitCode >= 0 {
+		return fmt.Sprintf("%s: exit status %d", e.Command, e.ExitCode)
+	}
+	return fmt.Sprintf("%s: %v", e.Command, e.Err)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// RunCommand runs cmd and returns a *CommandError on failure.
+// Stderr keeps going wherever cmd.Stderr points (if anywhere), while its
+// tail is captured so that failures can be reported with context.
+func RunCommand(cmd *exec.Cmd) error {
+	var tail strings.Builder
+	if cmd.Stderr != nil {
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, &tail)
+	} else {
+		cmd.Stderr = &tail
+	}
+
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+	return newCommandError(cmd, tail.String(), err)
+}
+
+// newCommandError builds a CommandError from a finished (or unstartable) command.
+func newCommandError(cmd *exec.Cmd, stderr string, err error) *CommandError {
+	exitCode := -1
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	}
+
+	return &CommandError{
+		Command:  strings.Join(cmd.Args, " "),
+		ExitCode: exitCode,
+		Stderr:   tailLines(stderr, stderrTailLines),
+		Err:      err,
+	}
+}
+
+// tailLines returns the last n non-empty lines of s.
+func tailLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+// FileSHA256 returns the hex-encoded SHA-256 of a file's content.
+// Content hashes are the change detector for dotfiles sync (ADR-0007).
+func FileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return ReaderSHA256(file, path)
+}
+
+// ReaderSHA256 is FileSHA256 for a file that is already open (e.g. one
+// opened relative to a directory); name is only used in errors.
+func ReaderSHA256(r io.Reader, name string) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", name, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
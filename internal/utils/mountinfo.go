@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// MountInfoPath is the kernel's per-process mount table (see proc(5)).
+const MountInfoPath = "/proc/self/mountinfo"
+
+// MountInfo is one line of /proc/self/mountinfo.
+type MountInfo struct {
+	ID         int
+	ParentID   int
+	MajorMinor string // st_dev of the filesystem (e.g. "253:0")
+	Root       string // Directory of the filesystem mounted here ("/" unless a bind mount)
+	MountPoint string
+	Options    string // Per-mount options (e.g. "rw,relatime")
+	FSType     string
+	Source     string // e.g. /dev/mapper/company_data
+	SuperOpts  string // Per-superblock options
+}
+
+// ReadMountInfo parses /proc/self/mountinfo.
+func ReadMountInfo() ([]MountInfo, error) {
+	file, err := os.Open(MountInfoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mount table: %w", err)
+	}
+	defer file.Close()
+
+	return parseMountInfo(file)
+}
+
+// parseMountInfo parses a mount table in the mountinfo format.
+func parseMountInfo(r io.Reader) ([]MountInfo, error) {
+	var mounts []MountInfo
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		m, err := parseMountInfoLine(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read mount table: %w", err)
+	}
+	return mounts, nil
+}
+
+// FindMount returns the topmost mount at mountPoint, if any.
+// Later lines stack on top of earlier ones. mountPoint is cleaned first,
+// since the kernel lists mount points without trailing or double slashes.
+func FindMount(mounts []MountInfo, mountPoint string) (MountInfo, bool) {
+	mountPoint = filepath.Clean(mountPoint)
+	for i := len(mounts) - 1; i >= 0; i-- {
+		if mounts[i].MountPoint == mountPoint {
+			return mounts[i], true
+		}
+	}
+	return MountInfo{}, false
+}
+
+// parseMountInfoLine splits "36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw"
+// around the "-" separator that follows the optional fields.
+func parseMountInfoLine(line string) (MountInfo, error) {
+	fields := strings.Fields(line)
+	sep := -1
+	for i := 6; i < len(fields); i++ {
+		if fields[i] == "-" {
+			sep = i
+			break
+		}
+	}
+	if len(fields) < 7 || sep < 0 || len(fields) < sep+3 {
+		return MountInfo{}, fmt.Errorf("malformed mountinfo line: %q", line)
+	}
+
+	id, _ := strconv.Atoi(fields[0])
+	parent, _ := strconv.Atoi(fields[1])
+	m := MountInfo{
+		ID:         id,
+		ParentID:   parent,
+		MajorMinor: fields[2],
+		Root:       unescapeMountField(fields[3]),
+		MountPoint: unescapeMountField(fields[4]),
+		Options:    fields[5],
+		FSType:     fields[sep+1],
+		Source:     unescapeMountField(fields[sep+2]),
+	}
+	if len(fields) > sep+3 {
+		m.SuperOpts = fields[sep+3]
+	}
+	return m, nil
+}
+
+// unescapeMountField decodes the octal escapes the kernel uses for
+// space, tab, newline and backslash (e.g. "\040").
+func unescapeMountField(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
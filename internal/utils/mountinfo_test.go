@@ -0,0 +1,101 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+// mountTable is a mountinfo excerpt: the root filesystem, an unlocked
+// LUKS volume with a btrfs subvolume at a path with a space, and two
+// bind mounts stacked on the same directory.
+const mountTable = `23 1 253:1 / / rw,relatime shared:1 - xfs /dev/mapper/fedora-root rw,attr2
+61 23 0:52 /@data /mnt/my\040vault rw,noatime shared:30 - btrfs /dev/mapper/vault rw,compress=zstd:3,subvol=/@data
+75 23 0:52 /@data/notes /home/ack/notes rw,noatime shared:30 - btrfs /dev/mapper/vault rw,subvol=/@data
+76 75 0:52 /@data/tab\011notes /home/ack/notes rw,noatime master:1 propagation_from:2 - btrfs /dev/mapper/vault rw
+`
+
+func TestParseMountInfo(t *testing.T) {
+	mounts, err := parseMountInfo(strings.NewReader(mountTable))
+	if err != nil {
+		t.Fatalf("parseMountInfo() error = %v", err)
+	}
+	if len(mounts) != 4 {
+		t.Fatalf("parsed %d mounts, want 4", len(mounts))
+	}
+
+	want := MountInfo{
+		ID:         61,
+		ParentID:   23,
+		MajorMinor: "0:52",
+		Root:       "/@data",
+		MountPoint: "/mnt/my vault",
+		Options:    "rw,noatime",
+		FSType:     "btrfs",
+		Source:     "/dev/mapper/vault",
+		SuperOpts:  "rw,compress=zstd:3,subvol=/@data",
+	}
+	if mounts[1] != want {
+		t.Errorf("mounts[1] = %+v, want %+v", mounts[1], want)
+	}
+	// Any number of optional fields precede the separator
+	if m := mounts[3]; m.Root != "/@data/tab\tnotes" || m.FSType != "btrfs" || m.Source != "/dev/mapper/vault" {
+		t.Errorf("mounts[3] = %+v", m)
+	}
+}
+
+func TestParseMountInfoMalformed(t *testing.T) {
+	for _, line := range []string{
+		"23 1 253:1 / / rw,relatime shared:1 xfs /dev/mapper/fedora-root rw", // No separator
+		"23 1 253:1 / / rw - xfs", // No source
+		"23 1 253:1 /",
+	} {
+		if _, err := parseMountInfo(strings.NewReader(line + "\n")); err == nil {
+			t.Errorf("parseMountInfo(%q) succeeded, want an error", line)
+		}
+	}
+}
+
+func TestUnescapeMountField(t *testing.T) {
+	tests := map[string]string{
+		`/mnt/plain`:         "/mnt/plain",
+		`/mnt/my\040vault`:   "/mnt/my vault",
+		`/mnt/a\011b\012c`:   "/mnt/a\tb\nc",
+		`/mnt/back\134slash`: `/mnt/back\slash`,
+		`/mnt/trailing\04`:   `/mnt/trailing\04`, // Too short to be an escape
+		`/mnt/not\999octal`:  `/mnt/not\999octal`,
+		`/mnt/end\040`:       "/mnt/end ",
+		`\040\040`:           "  ",
+	}
+	for in, want := range tests {
+		if got := unescapeMountField(in); got != want {
+			t.Errorf("unescapeMountField(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestFindMount(t *testing.T) {
+	mounts, err := parseMountInfo(strings.NewReader(mountTable))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path   string
+		wantID int // 0 when nothing is mounted there
+	}{
+		{"/", 23},
+		{"/mnt/my vault", 61},
+		{"/mnt/my vault/", 61},
+		{"/mnt//my vault", 61},
+		{"/home/ack/notes", 76}, // The topmost of the stacked mounts
+		{"/home/ack/notes/./", 76},
+		{"/home/ack", 0},
+		{"/mnt/my\\040vault", 0}, // Lookups take decoded paths
+	}
+	for _, tt := range tests {
+		m, ok := FindMount(mounts, tt.path)
+		if ok != (tt.wantID != 0) || m.ID != tt.wantID {
+			t.Errorf("FindMount(%q) = %d, %v; want %d", tt.path, m.ID, ok, tt.wantID)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ProcessUse is a process that keeps a path busy.
+type ProcessUse struct {
+	PID     int
+	Command string // /proc/<pid>/comm
+	Use     string // "cwd", "root", "exe", "fd" or "mmap"
+	Path    string // The file or directory under the busy path
+}
+
+func (p ProcessUse) String() string {
+	return fmt.Sprintf("%d %s (%s %s)", p.PID, p.Command, p.Use, p.Path)
+}
+
+// ProcessesUsing scans /proc for processes whose working directory, root,
+// executable, open files or mapped files lie under dir, the same sources
+// `fuser -m` looks at. Only the first use per process is reported.
+// Processes that vanish or cannot be inspected are skipped.
+func ProcessesUsing(dir string) ([]ProcessUse, error) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan /proc: %w", err)
+	}
+
+	var uses []ProcessUse
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		if use, ok := processUse(pid, dir); ok {
+			uses = append(uses, use)
+		}
+	}
+
+	sort.Slice(uses, func(i, j int) bool { return uses[i].PID < uses[j].PID })
+	return uses, nil
+}
+
+// processUse returns the first way pid uses dir.
+func processUse(pid int, dir string) (ProcessUse, bool) {
+	base := filepath.Join("/proc", strconv.Itoa(pid))
+	found := func(use, path string) (ProcessUse, bool) {
+		comm, _ := os.ReadFile(filepath.Join(base, "comm"))
+		return ProcessUse{PID: pid, Command: strings.TrimSpace(string(comm)), Use: use, Path: path}, true
+	}
+
+	for _, link := range []string{"cwd", "root", "exe"} {
+		if target, err := os.Readlink(filepath.Join(base, link)); err == nil && underDir(target, dir) {
+			return found(link, target)
+		}
+	}
+
+	if fds, err := os.ReadDir(filepath.Join(base, "fd")); err == nil {
+		for _, fd := range fds {
+			if target, err := os.Readlink(filepath.Join(base, "fd", fd.Name())); err == nil && underDir(target, dir) {
+				return found("fd", target)
+			}
+		}
+	}
+
+	if maps, err := os.Open(filepath.Join(base, "maps")); err == nil {
+		defer maps.Close()
+		scanner := bufio.NewScanner(maps)
+		for scanner.Scan() {
+			// address perms offset dev inode pathname
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 6 && underDir(fields[5], dir) {
+				return found("mmap", fields[5])
+			}
+		}
+	}
+
+	return ProcessUse{}, false
+}
+
+// underDir reports whether path is dir or lies below it.
+func underDir(path, dir string) bool {
+	path = strings.TrimSuffix(path, " (deleted)")
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// Runner executes external commands on behalf of Acts.
+// The Session carries one instance so that every rpm/dnf/systemctl/cryptsetup
+// call goes through a single seam that can be replaced by a fake.
+//
+// Failed commands are reported as *CommandError.
+type Runner interface {
+	// Run executes a command as root, streaming its output to the terminal.
+	Run(name string, args ...string) error
+
+	// RunWithStdin is Run with stdin fed from r (e.g. a password pipe).
+	RunWithStdin(stdin io.Reader, name string, args ...string) error
+
+	// RunAsUser executes a command with the UID/GID and HOME of username.
+	RunAsUser(username, name string, args ...string) error
+
+	// RunAsUserWithStdin is RunAsUser with stdin fed from r (e.g. a credential).
+	RunAsUserWithStdin(stdin io.Reader, username, name string, args ...string) error
+
+	// Output executes a command quietly and returns its stdout.
+	// It is meant for Check-phase queries such as `rpm -q`.
+	Output(name string, args ...string) ([]byte, error)
+}
+
+// SystemRunner is the Runner backed by os/exec.
+type SystemRunner struct{}
+
+// NewSystemRunner returns the Runner used for real provisioning.
+func NewSystemRunner() *SystemRunner {
+	return &SystemRunner{}
+}
+
+func (r *SystemRunner) Run(name string, args ...string) error {
+	return r.RunWithStdin(nil, name, args...)
+}
+
+func (r *SystemRunner) RunWithStdin(stdin io.Reader, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return RunCommand(cmd)
+}
+
+func (r *SystemRunner) RunAsUser(username, name string, args ...string) error {
+	return RunCommandAsUser(username, name, args...)
+}
+
+func (r *SystemRunner) RunAsUserWithStdin(stdin io.Reader, username, name string, args ...string) error {
+	return RunCommandAsUserWithStdin(stdin, username, name, args...)
+}
+
+func (r *SystemRunner) Output(name string, args ...string) ([]byte, error) {
+	var stdout bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = &stdout
+	err := RunCommand(cmd)
+	return stdout.Bytes(), err
+}
@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// zfsSuperMagic is missing from x/sys (ZFS is out of tree)
+const zfsSuperMagic = 0x2fc12fc1
+
+// fsNames maps statfs magic numbers to filesystem names
+var fsNames = map[int64]string{
+	unix.TMPFS_MAGIC:           "tmpfs",
+	unix.RAMFS_MAGIC:           "ramfs",
+	unix.BTRFS_SUPER_MAGIC:     "btrfs",
+	unix.BCACHEFS_SUPER_MAGIC:  "bcachefs",
+	zfsSuperMagic:              "zfs",
+	unix.F2FS_SUPER_MAGIC:      "f2fs",
+	unix.NILFS_SUPER_MAGIC:     "nilfs2",
+	unix.EXT4_SUPER_MAGIC:      "ext4", // also ext2/ext3
+	unix.XFS_SUPER_MAGIC:       "xfs",
+	unix.MSDOS_SUPER_MAGIC:     "vfat",
+	unix.EXFAT_SUPER_MAGIC:     "exfat",
+	unix.NFS_SUPER_MAGIC:       "nfs",
+	unix.CIFS_SUPER_MAGIC:      "cifs",
+	unix.SMB2_SUPER_MAGIC:      "smb2",
+	unix.FUSE_SUPER_MAGIC:      "fuse",
+	unix.OVERLAYFS_SUPER_MAGIC: "overlay",
+}
+
+// Storage describes where the data of a file physically lives, as far as
+// the kernel tells.
+type Storage struct {
+	FSType      string // e.g. "btrfs"; "0x..." for unknown filesystems
+	InMemory    bool   // tmpfs or ramfs: never reaches a disk (unless swapped)
+	CopyOnWrite bool   // Overwrites go to new blocks (btrfs, zfs, f2fs, ...)
+	Network     bool   // Data lives on another machine (nfs, cifs, fuse)
+	Device      string // Backing block device (e.g. "sda1"), empty if none or unknown
+	Rotational  bool   // The device is a spinning disk
+	Removable   bool   // The device is removable media (e.g. a USB stick)
+}
+
+// FileStorage describes the storage of an open file.
+func FileStorage(f *os.File) (Storage, error) {
+	var fs unix.Statfs_t
+	if err := unix.Fstatfs(int(f.Fd()), &fs); err != nil {
+		return Storage{}, fmt.Errorf("failed to statfs %s: %w", f.Name(), err)
+	}
+	magic := int64(fs.Type)
+	s := Storage{FSType: fsNames[magic]}
+	if s.FSType == "" {
+		s.FSType = fmt.Sprintf("0x%x", magic)
+	}
+	switch magic {
+	case unix.TMPFS_MAGIC, unix.RAMFS_MAGIC:
+		s.InMemory = true
+		return s, nil
+	case unix.BTRFS_SUPER_MAGIC, unix.BCACHEFS_SUPER_MAGIC, zfsSuperMagic, unix.F2FS_SUPER_MAGIC, unix.NILFS_SUPER_MAGIC:
+		s.CopyOnWrite = true
+	case unix.NFS_SUPER_MAGIC, unix.CIFS_SUPER_MAGIC, unix.SMB2_SUPER_MAGIC, unix.FUSE_SUPER_MAGIC:
+		s.Network = true
+		return s, nil
+	}
+
+	var st unix.Stat_t
+	if err := unix.Fstat(int(f.Fd()), &st); err != nil {
+		return s, fmt.Errorf("failed to stat %s: %w", f.Name(), err)
+	}
+	s.Device, s.Rotational, s.Removable = blockDeviceInfo(unix.Major(st.Dev), unix.Minor(st.Dev))
+	return s, nil
+}
+
+// blockDeviceInfo reads the sysfs queue attributes of a block device. A
+// partition has none of its own, so its parent disk is consulted.
+func blockDeviceInfo(major, minor uint32) (name string, rotational, removable bool) {
+	dir, err := filepath.EvalSymlinks(fmt.Sprintf("/sys/dev/block/%d:%d", major, minor))
+	if err != nil {
+		return "", false, false
+	}
+	name = filepath.Base(dir)
+	for _, d := range []string{dir, filepath.Dir(dir)} {
+		if rot, err := os.ReadFile(filepath.Join(d, "queue", "rotational")); err == nil {
+			rem, _ := os.ReadFile(filepath.Join(d, "removable"))
+			return name, strings.TrimSpace(string(rot)) == "1", strings.TrimSpace(string(rem)) == "1"
+		}
+	}
+	return name, false, false
+}
@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SwapsPath is the kernel's table of active swap areas (see proc(5)).
+const SwapsPath = "/proc/swaps"
+
+// SwapInfo is one line of /proc/swaps.
+type SwapInfo struct {
+	Filename string // Device node (e.g. /dev/dm-3, /dev/zram0) or swap file path
+	Type     string // "partition" or "file"
+}
+
+// ActiveSwaps parses /proc/swaps.
+func ActiveSwaps() ([]SwapInfo, error) {
+	file, err := os.Open(SwapsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read swap table: %w", err)
+	}
+	defer file.Close()
+
+	var swaps []SwapInfo
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // Header: Filename Type Size Used Priority
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		swaps = append(swaps, SwapInfo{Filename: unescapeMountField(fields[0]), Type: fields[1]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read swap table: %w", err)
+	}
+	return swaps, nil
+}
@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/acker1019/fedora-phoenix/internal/secret"
+	"golang.org/x/term"
+)
+
+// TTYPath is the controlling terminal. Prompts use it rather than
+// stdin/stdout, so they work when those are redirected.
+const TTYPath = "/dev/tty"
+
+// HasTTY reports whether a controlling terminal is available for prompts.
+func HasTTY() bool {
+	tty, err := os.OpenFile(TTYPath, os.O_RDWR, 0)
+	if err != nil {
+		return false
+	}
+	defer tty.Close()
+	return term.IsTerminal(int(tty.Fd()))
+}
+
+// ReadSecret prints prompt on the terminal and reads one line with echo
+// disabled into locked memory. If the user interrupts the prompt, echo is
+// restored before exiting.
+func ReadSecret(prompt string) (*secret.Value, error) {
+	tty, err := os.OpenFile(TTYPath, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("no terminal to prompt on: %w", err)
+	}
+	defer tty.Close()
+
+	fd := int(tty.Fd())
+	state, err := term.GetState(fd)
+	if err != nil {
+		return nil, fmt.Errorf("no terminal to prompt on: %w", err)
+	}
+
+	// term.ReadPassword restores echo when it returns, but not on a signal
+	sig := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	defer close(done)
+	go func() {
+		select {
+		case <-sig:
+			term.Restore(fd, state)
+			fmt.Fprintln(tty)
+			os.Exit(130)
+		case <-done:
+		}
+	}()
+
+	fmt.Fprint(tty, prompt)
+	line, err := term.ReadPassword(fd)
+	fmt.Fprintln(tty)
+	if err != nil {
+		clear(line)
+		return nil, fmt.Errorf("failed to read from terminal: %w", err)
+	}
+	value := secret.New(bytes.TrimRight(line, "\r\n"))
+	clear(line)
+	return value, nil
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/user"
 	"strconv"
+	"strings"
 	"syscall"
 
 	"github.com/acker1019/fedora-phoenix/internal/logging"
@@ -67,6 +68,61 @@ func GetRealUser() (username string, uid int, gid int, err error) {
 	return "", 0, 0, fmt.Errorf("unable to determine real user: no SUDO_USER, XAUTHORITY, or XDG_RUNTIME_DIR available")
 }
 
+// LookupUser resolves a username to its UID, GID and home directory.
+func LookupUser(username string) (uid int, gid int, homeDir string, err error) {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("failed to lookup user %s: %w", username, err)
+	}
+
+	// Parse UID and GID
+	uid, err = strconv.Atoi(u.Uid)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("invalid UID for user %s: %w", username, err)
+	}
+
+	gid, err = strconv.Atoi(u.Gid)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("invalid GID for user %s: %w", username, err)
+	}
+
+	return uid, gid, u.HomeDir, nil
+}
+
+// LookupOwner resolves an "user:group" owner string (by name) to UID and GID.
+func LookupOwner(owner string) (uid int, gid int, err error) {
+	name, group, ok := strings.Cut(owner, ":")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid owner %q: expected user:group", owner)
+	}
+
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to lookup user %s: %w", name, err)
+	}
+	g, err := user.LookupGroup(group)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to lookup group %s: %w", group, err)
+	}
+
+	uid, _ = strconv.Atoi(u.Uid)
+	gid, _ = strconv.Atoi(g.Gid)
+	return uid, gid, nil
+}
+
+// OwnerName renders a UID/GID pair as "user:group", falling back to numeric IDs.
+func OwnerName(uid, gid int) string {
+	name := strconv.Itoa(uid)
+	if u, err := user.LookupId(name); err == nil {
+		name = u.Username
+	}
+	group := strconv.Itoa(gid)
+	if g, err := user.LookupGroupId(group); err == nil {
+		group = g.Name
+	}
+	return name + ":" + group
+}
+
 // getFileOwnerUID extracts the UID from file stat info.
 func getFileOwnerUID(stat os.FileInfo) (int, bool) {
 	if sysStat, ok := stat.Sys().(*syscall.Stat_t); ok {
@@ -0,0 +1,164 @@
+// Package utilstest provides a scripted utils.Runner so Acts can be
+// exercised without root or a real Fedora system.
+package utilstest
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/acker1019/fedora-phoenix/internal/utils"
+)
+
+// Call is one command invocation recorded by FakeRunner.
+type Call struct {
+	User  string // Non-empty for RunAsUser
+	Name  string
+	Args  []string
+	Stdin string // Everything read from stdin, for RunWithStdin and RunAsUserWithStdin
+}
+
+// String renders the call as "[user@]name arg1 arg2".
+func (c Call) String() string {
+	line := strings.Join(append([]string{c.Name}, c.Args...), " ")
+	if c.User != "" {
+		return c.User + "@" + line
+	}
+	return line
+}
+
+// Expectation is a scripted response to one command line.
+type Expectation struct {
+	command  string
+	exitCode int
+	stdout   string
+	stderr   string
+}
+
+// Exit sets the exit code the command will report.
+func (e *Expectation) Exit(code int) *Expectation {
+	e.exitCode = code
+	return e
+}
+
+// Stdout sets what Output returns for the command.
+func (e *Expectation) Stdout(s string) *Expectation {
+	e.stdout = s
+	return e
+}
+
+// Stderr sets the stderr tail carried by the resulting CommandError.
+func (e *Expectation) Stderr(s string) *Expectation {
+	e.stderr = s
+	return e
+}
+
+// FakeRunner is a utils.Runner that replays Expectations in order and
+// records every Call. A command that does not match the next Expectation
+// fails with exit code 127 and is reported by Unmet.
+type FakeRunner struct {
+	mu         sync.Mutex
+	script     []*Expectation
+	Calls      []Call
+	unexpected []string
+}
+
+// NewFakeRunner returns an empty FakeRunner.
+func NewFakeRunner() *FakeRunner {
+	return &FakeRunner{}
+}
+
+// Expect scripts the next command line, written as Call.String renders it
+// (e.g. "rpm -q vim" or "ack@git clone url dest"). It succeeds by default.
+func (f *FakeRunner) Expect(command string) *Expectation {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	e := &Expectation{command: command}
+	f.script = append(f.script, e)
+	return e
+}
+
+// Unmet lists unexpected calls and Expectations that were never consumed.
+// It returns nil when the script was followed exactly.
+func (f *FakeRunner) Unmet() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	unmet := append([]string(nil), f.unexpected...)
+	for _, e := range f.script {
+		unmet = append(unmet, "missing: "+e.command)
+	}
+	return unmet
+}
+
+func (f *FakeRunner) Run(name string, args ...string) error {
+	_, err := f.call(Call{Name: name, Args: args})
+	return err
+}
+
+func (f *FakeRunner) RunWithStdin(stdin io.Reader, name string, args ...string) error {
+	c := Call{Name: name, Args: args}
+	if stdin != nil {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return err
+		}
+		c.Stdin = string(data)
+	}
+	_, err := f.call(c)
+	return err
+}
+
+func (f *FakeRunner) RunAsUser(username, name string, args ...string) error {
+	_, err := f.call(Call{User: username, Name: name, Args: args})
+	return err
+}
+
+func (f *FakeRunner) RunAsUserWithStdin(stdin io.Reader, username, name string, args ...string) error {
+	c := Call{User: username, Name: name, Args: args}
+	if stdin != nil {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return err
+		}
+		c.Stdin = string(data)
+	}
+	_, err := f.call(c)
+	return err
+}
+
+func (f *FakeRunner) Output(name string, args ...string) ([]byte, error) {
+	stdout, err := f.call(Call{Name: name, Args: args})
+	return []byte(stdout), err
+}
+
+// call records c and replays the next Expectation if it matches.
+func (f *FakeRunner) call(c Call) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.Calls = append(f.Calls, c)
+	line := c.String()
+
+	if len(f.script) == 0 || f.script[0].command != line {
+		f.unexpected = append(f.unexpected, "unexpected: "+line)
+		return "", &utils.CommandError{Command: line, ExitCode: 127, Err: fmt.Errorf("unexpected command")}
+	}
+
+	e := f.script[0]
+	f.script = f.script[1:]
+	if e.exitCode != 0 {
+		return e.stdout, &utils.CommandError{
+			Command:  line,
+			ExitCode: e.exitCode,
+			Stderr:   e.stderr,
+			Err:      fmt.Errorf("exit status %d", e.exitCode),
+		}
+	}
+	return e.stdout, nil
+}
+
+// Compile-time check that FakeRunner satisfies utils.Runner.
+var _ utils.Runner = (*FakeRunner)(nil)